@@ -0,0 +1,336 @@
+// Файл: api.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"shtrih-kkt/pkg/shtrih"
+)
+
+// Состояния последнего опроса ККТ в ответе GET /devices.
+const (
+	pollStatusOK      = "ok"      // Данные получены
+	pollStatusError   = "error"   // Опрос завершился ошибкой
+	pollStatusUnknown = "unknown" // Устройство из connect.json еще не опрашивалось
+)
+
+// deviceStatus - последнее известное состояние ККТ в режиме службы.
+type deviceStatus struct {
+	Address      string     `json:"address"`
+	SerialNumber string     `json:"serial_number,omitempty"`
+	ModelName    string     `json:"model_name,omitempty"`
+	Configured   bool       `json:"configured"` // Устройство есть в connect.json
+	Status       string     `json:"status"`
+	LastPoll     *time.Time `json:"last_poll,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	DurationMs   int64      `json:"duration_ms,omitempty"` // Длительность последнего опроса
+	LastError    string     `json:"last_error,omitempty"`
+
+	config shtrih.Config
+	info   *shtrih.FiscalInfo // Данные последнего успешного опроса
+}
+
+// discoveryStatus - итог последнего автопоиска.
+type discoveryStatus struct {
+	LastRun  time.Time
+	Found    int
+	Denied   int
+	Duration time.Duration
+}
+
+// deviceRegistry хранит результаты опросов по адресам устройств и итог
+// последнего автопоиска.
+type deviceRegistry struct {
+	mu        sync.Mutex
+	devices   map[string]*deviceStatus
+	discovery discoveryStatus
+	now       func() time.Time
+}
+
+func newDeviceRegistry() *deviceRegistry {
+	return &deviceRegistry{devices: make(map[string]*deviceStatus), now: time.Now}
+}
+
+// record сохраняет результат опроса. Подходит для pollObserver. Успешный опрос
+// удаляет записи той же ККТ по другим адресам: устройство переехало.
+func (r *deviceRegistry) record(config shtrih.Config, info *shtrih.FiscalInfo, err error, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addr := deviceAddress(config)
+	d, ok := r.devices[addr]
+	if !ok {
+		d = &deviceStatus{Address: addr}
+		r.devices[addr] = d
+	}
+	now := r.now()
+	d.config = config
+	d.LastPoll = &now
+	d.DurationMs = elapsed.Milliseconds()
+	if err != nil {
+		d.Status, d.LastError = pollStatusError, err.Error()
+		return
+	}
+	d.Status, d.LastError = pollStatusOK, ""
+	d.LastSuccess = &now
+	d.info = info
+	d.SerialNumber, d.ModelName = info.SerialNumber, info.ModelName
+	for other, od := range r.devices {
+		if other != addr && od.SerialNumber == info.SerialNumber {
+			delete(r.devices, other)
+		}
+	}
+}
+
+// recordDiscovery сохраняет итог автопоиска. Подходит для discoveryObserver.
+func (r *deviceRegistry) recordDiscovery(found, denied int, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.discovery = discoveryStatus{LastRun: r.now(), Found: found, Denied: denied, Duration: elapsed}
+}
+
+// lastDiscovery возвращает итог последнего автопоиска.
+func (r *deviceRegistry) lastDiscovery() discoveryStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.discovery
+}
+
+// list возвращает состояние устройств из settings и устройств, найденных
+// автопоиском и ответивших хотя бы раз, в порядке адресов.
+func (r *deviceRegistry) list(settings []ConnectionSettings) []deviceStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	configured := make(map[string]bool)
+	var list []deviceStatus
+	for _, s := range settings {
+		addr := settingsAddress(s)
+		configured[addr] = true
+		d := deviceStatus{Address: addr, SerialNumber: s.SerialNumber, Status: pollStatusUnknown}
+		if polled, ok := r.devices[addr]; ok {
+			d = *polled
+			if d.SerialNumber == "" {
+				d.SerialNumber = s.SerialNumber
+			}
+		}
+		d.Configured = true
+		list = append(list, d)
+	}
+	for addr, d := range r.devices {
+		if !configured[addr] && d.info != nil {
+			list = append(list, *d)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Address < list[j].Address })
+	return list
+}
+
+// latest возвращает данные последнего успешного опроса ККТ с заводским номером
+// serial и конфигурацию, по которой она ответила.
+func (r *deviceRegistry) latest(serial string) (*shtrih.FiscalInfo, shtrih.Config, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found *deviceStatus
+	for _, d := range r.devices {
+		if d.info != nil && d.info.SerialNumber == serial && (found == nil || d.LastSuccess.After(*found.LastSuccess)) {
+			found = d
+		}
+	}
+	if found == nil {
+		return nil, shtrih.Config{}, false
+	}
+	return found.info, found.config, true
+}
+
+// apiRequestHeader - заголовок, без которого API отклоняет POST-запросы.
+// Страница в браузере кассы не может отправить его на чужой адрес без
+// предварительного CORS-запроса, который API не разрешает.
+const apiRequestHeader = "X-Shtrih-Request"
+
+// apiServer обслуживает HTTP API режима службы. Опрос и автопоиск не
+// выполняются в обработчиках, а ставятся в очередь jobs, которую разбирает
+// runDaemon между плановыми задачами.
+type apiServer struct {
+	registry *deviceRegistry
+	jobs     chan<- func(ctx context.Context)
+	discover func(ctx context.Context)
+	// settings возвращает записи connect.json. Вынесена в поле для подмены в тестах.
+	settings func() []ConnectionSettings
+}
+
+// ServeHTTP разбирает путь запроса:
+//
+//	GET  /devices               - устройства и состояние последнего опроса
+//	GET  /devices/{serial}      - данные последнего успешного опроса ККТ
+//	POST /devices/{serial}/poll - внеочередной опрос ККТ
+//	POST /discover              - внеочередной автопоиск
+//	GET  /metrics               - метрики в формате Prometheus
+//
+// Запросы с нелокальным заголовком Host (DNS rebinding) и POST-запросы без
+// заголовка apiRequestHeader (CSRF со страниц в браузере) отклоняются.
+func (a *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isLoopbackHost(r.Host) {
+		writeError(w, http.StatusForbidden, "API доступен только по локальному адресу")
+		return
+	}
+	if r.Method == http.MethodPost && r.Header.Get(apiRequestHeader) == "" {
+		writeError(w, http.StatusForbidden, "требуется заголовок "+apiRequestHeader)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "devices":
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, a.registry.list(a.settings()))
+		}
+	case len(parts) == 2 && parts[0] == "devices":
+		if allowMethod(w, r, http.MethodGet) {
+			a.handleDevice(w, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "devices" && parts[2] == "poll":
+		if allowMethod(w, r, http.MethodPost) {
+			a.handlePoll(w, parts[1])
+		}
+	case len(parts) == 1 && parts[0] == "discover":
+		if allowMethod(w, r, http.MethodPost) {
+			a.enqueue(w, "автопоиск", a.discover)
+		}
+	case len(parts) == 1 && parts[0] == "metrics":
+		if allowMethod(w, r, http.MethodGet) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			if err := writeMetrics(w, metricsDevices(a.registry, a.settings()), a.registry.lastDiscovery(), time.Now()); err != nil {
+				log.Printf("API: не удалось отправить метрики: %v", err)
+			}
+		}
+	default:
+		writeError(w, http.StatusNotFound, "неизвестный адрес")
+	}
+}
+
+func (a *apiServer) handleDevice(w http.ResponseWriter, serial string) {
+	info, _, ok := a.registry.latest(serial)
+	if !ok {
+		writeError(w, http.StatusNotFound, "нет данных ККТ "+serial)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handlePoll ставит в очередь опрос ККТ по адресу, где она ответила в последний
+// раз, или по записи connect.json с ее заводским номером.
+func (a *apiServer) handlePoll(w http.ResponseWriter, serial string) {
+	_, config, ok := a.registry.latest(serial)
+	if !ok {
+		for _, s := range a.settings() {
+			if s.SerialNumber != serial {
+				continue
+			}
+			if configs := convertSettingsToConfigs([]ConnectionSettings{s}); len(configs) == 1 {
+				config, ok = configs[0], true
+			}
+			break
+		}
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "ККТ "+serial+" не найдена в connect.json и не опрашивалась")
+		return
+	}
+	a.enqueue(w, "опрос ККТ "+serial, func(ctx context.Context) {
+		if polled := pollDevices(ctx, []shtrih.Config{config}, newDriver); len(polled) > 0 {
+			writeDeviceRecords(polled)
+		}
+	})
+}
+
+// enqueue ставит задачу в очередь службы и отвечает 202, а если очередь
+// заполнена - 503.
+func (a *apiServer) enqueue(w http.ResponseWriter, name string, job func(ctx context.Context)) {
+	select {
+	case a.jobs <- job:
+		log.Printf("API: %s поставлен в очередь.", name)
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+	default:
+		writeError(w, http.StatusServiceUnavailable, "очередь заданий службы заполнена, повторите позже")
+	}
+}
+
+// allowMethod отвечает 405, если метод запроса не method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "метод не поддерживается")
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("API: не удалось отправить ответ: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+// isLoopbackAddr сообщает, что адрес host:port доступен только с этого компьютера.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && isLoopbackHost(host)
+}
+
+// isLoopbackHost сообщает, что заголовок Host (с портом или без) указывает на
+// этот компьютер.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// startAPI запускает HTTP API на адресе addr и возвращает функцию остановки.
+// API доступен только с локального компьютера: адреса других интерфейсов
+// отклоняются. Если адрес занят (например, предыдущая версия после обновления
+// еще не остановилась), подключение повторяется apiListenRetries раз.
+func startAPI(addr string, handler http.Handler) (stop func()) {
+	if !isLoopbackAddr(addr) {
+		log.Printf("API службы не запущен: адрес '%s' не локальный. Укажите 127.0.0.1:порт в 'daemon.api_addr'.", addr)
+		return func() {}
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		ln, err := net.Listen("tcp", addr)
+		for attempt := 0; err != nil && attempt < apiListenRetries; attempt++ {
+			time.Sleep(time.Second)
+			ln, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			log.Printf("API службы не запущен: %v", err)
+			return
+		}
+		log.Printf("API службы доступен по адресу http://%s", ln.Addr())
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("Ошибка API службы: %v", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}
+}
@@ -0,0 +1,33 @@
+// Команда shtrih-emulator запускает программный эмулятор ККТ "Штрих-М"
+// для демонстраций и ручной проверки утилиты без оборудования.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"shtrih-kkt/pkg/shtrih"
+)
+
+func main() {
+	statePath := flag.String("state", "pkg/shtrih/testdata/canonical_kkt_data.json", "JSON-фикстура с состоянием ККТ")
+	listenAddr := flag.String("listen", "127.0.0.1:7778", "адрес TCP для приема подключений")
+	flag.Parse()
+
+	state, err := shtrih.LoadEmulatorState(*statePath)
+	if err != nil {
+		log.Fatalf("Ошибка загрузки состояния эмулятора: %v", err)
+	}
+	ln, err := shtrih.NewEmulator(state).ListenTCP(*listenAddr)
+	if err != nil {
+		log.Fatalf("Не удалось запустить эмулятор на %s: %v", *listenAddr, err)
+	}
+	defer ln.Close()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+	log.Println("Эмулятор остановлен.")
+}
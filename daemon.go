@@ -0,0 +1,140 @@
+// Файл: daemon.go
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// daemonTasks - задачи, которые служба выполняет по расписанию.
+type daemonTasks struct {
+	poll     func(ctx context.Context) // Опрос ККТ
+	discover func(ctx context.Context) // Плановый автопоиск
+	update   func() bool               // Проверка обновлений; true - запущена новая версия
+	// jobs - внеочередные задачи из API службы.
+	jobs <-chan func(ctx context.Context)
+}
+
+// serviceTasks возвращает задачи службы: опрос устройств из connect.json,
+// плановый автопоиск и проверку обновлений по manifestURL (если он задан).
+func serviceTasks(manifestURL string) daemonTasks {
+	tasks := daemonTasks{
+		poll:     pollConfigured,
+		discover: runScheduledDiscovery,
+	}
+	if manifestURL != "" {
+		tasks.update = func() bool { return updateApplication(version, manifestURL) }
+	}
+	return tasks
+}
+
+// runService запускает службу: HTTP API на apiAddr (вместе с метриками
+// /metrics) и задачи по расписанию до отмены ctx.
+func runService(ctx context.Context, manifestURL string) {
+	registry := newDeviceRegistry()
+	pollObserver, discoveryObserver = registry.record, registry.recordDiscovery
+	defer func() { pollObserver, discoveryObserver = nil, nil }()
+
+	jobs := make(chan func(ctx context.Context), apiQueueSize)
+	tasks := serviceTasks(manifestURL)
+	tasks.jobs = jobs
+	stopAPI := func() {}
+	if apiAddr != "" {
+		stopAPI = startAPI(apiAddr, &apiServer{registry: registry, jobs: jobs, discover: tasks.discover, settings: loadShtrihSettings})
+	}
+	runDaemon(ctx, tasks)
+	stopAPI()
+}
+
+// runDaemon выполняет задачи по расписанию pollInterval, discoveryInterval
+// и updateInterval до отмены ctx. Обновления проверяются и ККТ опрашиваются
+// сразу при запуске. Задачи выполняются по очереди в одной горутине, поэтому
+// опрос и автопоиск не обращаются к портам одновременно. Отмена ctx прерывает
+// текущую задачу: драйверы отключаются, собранные данные передаются приемникам.
+func runDaemon(ctx context.Context, tasks daemonTasks) {
+	log.Printf("--- Запуск в режиме службы: опрос каждые %v, автопоиск каждые %v, проверка обновлений каждые %v ---",
+		pollInterval, discoveryInterval, updateInterval)
+
+	if tasks.update != nil && tasks.update() {
+		log.Println("Запущена новая версия приложения. Служба останавливается.")
+		return
+	}
+	tasks.poll(ctx)
+
+	pollTicker := time.NewTicker(pollInterval)
+	defer pollTicker.Stop()
+	discoveryTicker := time.NewTicker(discoveryInterval)
+	defer discoveryTicker.Stop()
+	var updateC <-chan time.Time
+	if tasks.update != nil {
+		updateTicker := time.NewTicker(updateInterval)
+		defer updateTicker.Stop()
+		updateC = updateTicker.C
+	}
+
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-pollTicker.C:
+			tasks.poll(ctx)
+		case <-discoveryTicker.C:
+			log.Println("--- Плановый автопоиск устройств ---")
+			tasks.discover(ctx)
+		case job := <-tasks.jobs:
+			job(ctx)
+		case <-updateC:
+			if tasks.update() {
+				log.Println("Запущена новая версия приложения. Служба останавливается.")
+				return
+			}
+		}
+	}
+	log.Println("--- Получен сигнал завершения. Служба остановлена. ---")
+}
+
+// pollConfigured выполняет плановый опрос службы: опрашивает устройства из
+// connect.json и передает данные приемникам. Пропавшие и новые ККТ при этом
+// не ищутся - это задача планового автопоиска (runScheduledDiscovery). Без
+// connect.json (первый запуск службы) выполняется режим автопоиска.
+func pollConfigured(ctx context.Context) {
+	settings := loadShtrihSettings()
+	if settings == nil {
+		log.Printf("Файл конфигурации '%s' не найден или поврежден. Запускаю режим автопоиска...", configFileName)
+		runDiscoveryMode(ctx)
+		return
+	}
+	configs := convertSettingsToConfigs(settings)
+	if len(configs) == 0 {
+		log.Printf("В '%s' нет устройств для опроса.", configFileName)
+		return
+	}
+	writeDeviceRecords(pollDevices(ctx, configs, newDriver))
+}
+
+// runScheduledDiscovery выполняет плановый автопоиск службы. Найденные ККТ
+// объединяются с connect.json так же, как при самовосстановлении (см.
+// rediscoverDevices), поэтому новые кассы опрашиваются без перезапуска службы.
+// Затем из connect.json удаляются давно не отвечающие ККТ (см. retireDevices).
+// Без connect.json выполняется обычный режим автопоиска.
+func runScheduledDiscovery(ctx context.Context) {
+	settings := loadShtrihSettings()
+	if settings == nil {
+		runDiscoveryMode(ctx)
+		return
+	}
+
+	settings, polledDevices, changed := rediscoverDevices(ctx, settings, nil, newDriver)
+	if len(polledDevices) > 0 {
+		writeDeviceRecords(polledDevices)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	state := loadRunState()
+	settings, retired := retireDevices(settings, polledDevices, state, time.Now())
+	state.save()
+	if changed || retired {
+		saveShtrihSettings(settings)
+	}
+}
@@ -0,0 +1,116 @@
+// Файл: dump.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"shtrih-kkt/pkg/shtrih"
+)
+
+// dumpDir - каталог для снимков таблиц ККТ (режимы -dump и -diff).
+var dumpDir = "dumps"
+
+// runDumpMode снимает таблицы со всех устройств и сохраняет снимки в dumpDir.
+// Возвращает пути к созданным файлам.
+func runDumpMode(configs []shtrih.Config, newDriverFunc func(shtrih.Config) shtrih.Driver) []string {
+	var paths []string
+	for _, config := range configs {
+		log.Printf("--- Снимаю таблицы устройства: %s ---", deviceAddress(config))
+		dump, err := dumpDevice(config, newDriverFunc)
+		if err != nil {
+			logPollError(err)
+			continue
+		}
+		path, err := shtrih.SaveDump(dump, dumpDir)
+		if err != nil {
+			log.Printf("Не удалось сохранить снимок: %v", err)
+			continue
+		}
+		log.Printf("Снимок таблиц ККТ %s сохранен в '%s'.", dump.SerialNumber, path)
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// runDiffMode сравнивает два снимка (-diff old.json new.json) или снимок
+// с текущим состоянием устройства с тем же заводским номером (-diff old.json).
+// Во втором случае свежий снимок также сохраняется в dumpDir.
+func runDiffMode(args []string, configs []shtrih.Config, newDriverFunc func(shtrih.Config) shtrih.Driver, w io.Writer) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("укажите один снимок для сравнения с устройством или два снимка для сравнения между собой")
+	}
+	oldDump, err := shtrih.LoadDump(args[0])
+	if err != nil {
+		return err
+	}
+
+	var newDump *shtrih.TableDump
+	if len(args) == 2 {
+		if newDump, err = shtrih.LoadDump(args[1]); err != nil {
+			return err
+		}
+	} else {
+		for _, config := range configs {
+			dump, err := dumpDevice(config, newDriverFunc)
+			if err != nil {
+				logPollError(err)
+				continue
+			}
+			if dump.SerialNumber == oldDump.SerialNumber {
+				newDump = dump
+				break
+			}
+		}
+		if newDump == nil {
+			return fmt.Errorf("устройство с заводским номером %s не найдено", oldDump.SerialNumber)
+		}
+		if path, err := shtrih.SaveDump(newDump, dumpDir); err != nil {
+			log.Printf("Не удалось сохранить снимок: %v", err)
+		} else {
+			log.Printf("Текущий снимок таблиц сохранен в '%s'.", path)
+		}
+	}
+
+	changes := shtrih.DiffDumps(oldDump, newDump)
+	fmt.Fprintf(w, "ККТ %s: %s -> %s, отличий: %d\n", oldDump.SerialNumber, oldDump.CreatedAt, newDump.CreatedAt, len(changes))
+	for _, c := range changes {
+		fmt.Fprintln(w, c)
+	}
+	return nil
+}
+
+// dumpDevice подключается к устройству и снимает все его таблицы.
+func dumpDevice(config shtrih.Config, newDriverFunc func(shtrih.Config) shtrih.Driver) (*shtrih.TableDump, error) {
+	driver, ok := newDriverFunc(config).(shtrih.TableDriver)
+	if !ok {
+		return nil, fmt.Errorf("драйвер не поддерживает чтение таблиц")
+	}
+	if err := connectWithTimeout(context.Background(), driver); err != nil {
+		return nil, fmt.Errorf("не удалось подключиться к устройству: %w", err)
+	}
+	defer driver.Disconnect()
+	return shtrih.DumpTables(driver)
+}
+
+// loadDeviceConfigs возвращает конфигурации устройств из connect.json,
+// а при его отсутствии - результат автопоиска (без сохранения в файл).
+func loadDeviceConfigs() []shtrih.Config {
+	data, err := os.ReadFile(configFileName)
+	if err == nil {
+		var configFile ConfigFile
+		if err := json.Unmarshal(data, &configFile); err == nil && len(configFile.Shtrih) > 0 {
+			return convertSettingsToConfigs(configFile.Shtrih)
+		}
+	}
+	log.Printf("Устройства в '%s' не заданы. Выполняю автопоиск...", configFileName)
+	configs, err := searchDevices(context.Background(), searchOptions)
+	if err != nil {
+		log.Printf("Во время поиска устройств произошла ошибка: %v", err)
+	}
+	return configs
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"shtrih-kkt/pkg/shtrih"
+	"shtrih-kkt/pkg/sink"
 
 	"gopkg.in/natefinch/lumberjack.v2"
 )
@@ -23,13 +30,69 @@ const (
 	serviceConfigName  = "service.json"
 	defaultManifestURL = "http://f.serty.top/distr/installer/assets/shtrihscanner/update.json"
 	logsDir            = "logs"
-	comSearchTimeout   = 200 * time.Millisecond
-	tcpSearchTimeout   = 200 * time.Millisecond
+	// spoolDir - очередь данных, не отправленных HTTP-приемником.
+	spoolDir = "spool"
+	// defaultDevicePassword - пароль системного администратора ККТ по умолчанию.
+	defaultDevicePassword int32 = 30
 )
 
 var (
 	outputDir = "date"
 	version   = "0.1.7"
+	// newDriver - конструктор драйвера, выбранный по настройке "driver" и платформе.
+	newDriver = shtrih.New
+	// pollRetries - число повторных попыток опроса устройства при повторяемых ошибках
+	// (нет связи, порт занят, ККТ занята печатью).
+	pollRetries = 2
+	// pollRetryDelay - пауза перед повторной попыткой опроса.
+	pollRetryDelay = 2 * time.Second
+	// connectTimeout и infoTimeout - предельное время подключения к устройству
+	// и сбора информации о нем в одной попытке опроса.
+	connectTimeout = 30 * time.Second
+	infoTimeout    = 2 * time.Minute
+	// pollConcurrency - сколько устройств опрашивается одновременно.
+	// Устройства на одном последовательном порту всегда опрашиваются по очереди.
+	pollConcurrency = 4
+	// pollObserver, если задан, получает результат каждого опроса устройства:
+	// данные (nil при ошибке), ошибку и длительность опроса.
+	pollObserver func(config shtrih.Config, info *shtrih.FiscalInfo, err error, elapsed time.Duration)
+	// discoveryObserver, если задан, получает итог каждого автопоиска: число
+	// найденных ККТ, число ККТ, отклонивших пароль, и длительность поиска.
+	discoveryObserver func(found, denied int, elapsed time.Duration)
+	// deviceTimeout - общий бюджет времени на опрос одного устройства, включая повторы.
+	deviceTimeout = 3 * time.Minute
+	// searchOptions - параметры автопоиска, переопределяются блоком "discovery" в service.json.
+	searchOptions = shtrih.DefaultSearchOptions()
+	// stateFileName - файл состояния стационарного режима между запусками.
+	stateFileName = "shtrihscanner_state.json"
+	// rediscoverAfterRuns - после стольких запусков подряд, в которых не ответило
+	// ни одно устройство из connect.json, выполняется полный поиск. 0 - не выполнять.
+	rediscoverAfterRuns = 3
+	// retireAfter - устройства, не отвечающие дольше этого срока, удаляются
+	// из connect.json. 0 - не удалять.
+	retireAfter time.Duration
+	// relocateInterval - пропавшие ККТ ищутся не чаще этого срока, чтобы
+	// выключенная касса не занимала порты поиском в каждом запуске. 0 - в каждом запуске.
+	relocateInterval = time.Hour
+	// pollInterval, discoveryInterval и updateInterval - расписание режима службы
+	// (-daemon): опрос ККТ, плановый автопоиск и проверка обновлений.
+	pollInterval      = 5 * time.Minute
+	discoveryInterval = 24 * time.Hour
+	updateInterval    = 6 * time.Hour
+	// apiAddr - адрес HTTP API службы (только локальный). Пустая строка - API отключен.
+	apiAddr = "127.0.0.1:8765"
+	// apiQueueSize - сколько внеочередных задач из API может ожидать выполнения.
+	apiQueueSize = 8
+	// apiListenRetries - сколько раз повторяется подключение к занятому адресу API.
+	apiListenRetries = 10
+	// metricsTextfile - файл метрик разового запуска. Пустая строка - не сохранять.
+	metricsTextfile string
+	// sinkConfigs - приемники данных ККТ из service.json.
+	sinkConfigs []sink.Config
+	// searchDevices выполняет автопоиск. Вынесена в переменную для подмены в тестах.
+	searchDevices = shtrih.SearchDevicesWithOptions
+	// fnThresholds - пороги прогноза по замене ФН, задаются в service.json.
+	fnThresholds = shtrih.DefaultForecastThresholds()
 )
 
 // --- СТРУКТУРЫ ДЛЯ ПАРСИНГА КОНФИГУРАЦИОННЫХ ФАЙЛОВ ---
@@ -51,6 +114,136 @@ type ShtrihScannerConfig struct {
 	Enabled     bool   `json:"enabled"`
 	ExeName     string `json:"exe_name"`
 	ManifestURL string `json:"manifest_url"`
+	// Driver задает реализацию драйвера: "com", "native" или пусто для автовыбора.
+	Driver string `json:"driver,omitempty"`
+	// FnForecast задает пороги прогноза по замене ФН (дни).
+	FnForecast *shtrih.ForecastThresholds `json:"fn_forecast,omitempty"`
+	// PollConcurrency ограничивает число одновременно опрашиваемых устройств.
+	PollConcurrency int `json:"poll_concurrency,omitempty"`
+	// DeviceTimeoutSec - бюджет времени на опрос одного устройства, секунды.
+	DeviceTimeoutSec int `json:"device_timeout_sec,omitempty"`
+	// Discovery переопределяет параметры автопоиска.
+	Discovery *DiscoveryConfig `json:"discovery,omitempty"`
+	// Recovery задает самовосстановление стационарного режима.
+	Recovery *RecoveryConfig `json:"recovery,omitempty"`
+	// Sinks - приемники данных ККТ. По умолчанию - файлы в каталоге date.
+	Sinks []sink.Config `json:"sinks,omitempty"`
+	// Daemon - расписание режима службы (-daemon).
+	Daemon *DaemonConfig `json:"daemon,omitempty"`
+	// MetricsTextfile - файл метрик для textfile collector node_exporter,
+	// обновляется после каждого разового запуска.
+	MetricsTextfile string `json:"metrics_textfile,omitempty"`
+}
+
+// RecoveryConfig - параметры самовосстановления стационарного режима из service.json.
+type RecoveryConfig struct {
+	RediscoverAfterRuns int `json:"rediscover_after_runs,omitempty"` // Полный поиск после N запусков без ответа устройств (< 0 - отключить)
+	RetireAfterDays     int `json:"retire_after_days,omitempty"`     // Удалять ККТ, не отвечающие N дней (0 - не удалять)
+	RelocateIntervalMin int `json:"relocate_interval_min,omitempty"` // Искать пропавшие ККТ не чаще раза в N минут (< 0 - в каждом запуске)
+}
+
+// apply переносит заданные параметры в переменные пакета.
+func (rc *RecoveryConfig) apply() {
+	if rc.RediscoverAfterRuns != 0 {
+		rediscoverAfterRuns = rc.RediscoverAfterRuns
+	}
+	if rc.RetireAfterDays > 0 {
+		retireAfter = time.Duration(rc.RetireAfterDays) * 24 * time.Hour
+	}
+	if rc.RelocateIntervalMin != 0 {
+		relocateInterval = time.Duration(rc.RelocateIntervalMin) * time.Minute
+	}
+}
+
+// DaemonConfig - расписание режима службы из service.json. Незаданные (нулевые)
+// поля оставляют значения по умолчанию.
+type DaemonConfig struct {
+	PollIntervalSec      int `json:"poll_interval_sec,omitempty"`      // Период опроса ККТ
+	DiscoveryIntervalSec int `json:"discovery_interval_sec,omitempty"` // Период планового автопоиска
+	UpdateIntervalSec    int `json:"update_interval_sec,omitempty"`    // Период проверки обновлений
+	// APIAddr - адрес HTTP API службы, например "127.0.0.1:8765"; "off" - отключить.
+	APIAddr string `json:"api_addr,omitempty"`
+}
+
+// apply переносит заданные параметры в переменные пакета.
+func (dc *DaemonConfig) apply() {
+	if dc.PollIntervalSec > 0 {
+		pollInterval = time.Duration(dc.PollIntervalSec) * time.Second
+	}
+	if dc.DiscoveryIntervalSec > 0 {
+		discoveryInterval = time.Duration(dc.DiscoveryIntervalSec) * time.Second
+	}
+	if dc.UpdateIntervalSec > 0 {
+		updateInterval = time.Duration(dc.UpdateIntervalSec) * time.Second
+	}
+	switch dc.APIAddr {
+	case "":
+	case "off":
+		apiAddr = ""
+	default:
+		apiAddr = dc.APIAddr
+	}
+}
+
+// DiscoveryConfig - параметры автопоиска из service.json. Незаданные (нулевые)
+// поля оставляют значения по умолчанию.
+type DiscoveryConfig struct {
+	BaudRates       []int    `json:"baud_rates,omitempty"`       // Скорости COM-портов, бод
+	Subnets         []string `json:"subnets,omitempty"`          // Подсети в нотации CIDR (по умолчанию - по интерфейсам хоста)
+	MaxHosts        int      `json:"max_hosts,omitempty"`        // Лимит адресов в подсетях интерфейсов хоста
+	TCPPort         int32    `json:"tcp_port,omitempty"`         // TCP-порт ККТ
+	Password        int32    `json:"password,omitempty"`         // Пароль для проверки связи
+	Passwords       []int32  `json:"passwords,omitempty"`        // Пароли, которые пробуются, если ККТ отклонила password
+	NetworkWorkers  int      `json:"network_workers,omitempty"`  // Одновременно проверяемые IP-адреса
+	ComParallelism  int      `json:"com_parallelism,omitempty"`  // Одновременно проверяемые COM-порты
+	ComTimeoutMs    int      `json:"com_timeout_ms,omitempty"`   // Таймаут проверки COM-порта, мс
+	TCPTimeoutMs    int      `json:"tcp_timeout_ms,omitempty"`   // Таймаут проверки IP-адреса, мс
+	ExpectedDevices int      `json:"expected_devices,omitempty"` // Завершить поиск после N устройств
+	PreferredUSB    []string `json:"preferred_usb,omitempty"`    // VID или VID:PID портов, проверяемых первыми
+	BlacklistUSB    []string `json:"blacklist_usb,omitempty"`    // VID или VID:PID портов, которые не проверяются
+}
+
+// apply переносит заданные параметры в opts.
+func (dc *DiscoveryConfig) apply(opts *shtrih.SearchOptions) {
+	if len(dc.BaudRates) > 0 {
+		opts.BaudRates = dc.BaudRates
+	}
+	if len(dc.Subnets) > 0 {
+		opts.Subnets = dc.Subnets
+	}
+	if dc.MaxHosts > 0 {
+		opts.MaxHosts = dc.MaxHosts
+	}
+	if dc.TCPPort > 0 {
+		opts.TCPPort = dc.TCPPort
+	}
+	if dc.Password > 0 {
+		opts.Password = dc.Password
+	}
+	if len(dc.Passwords) > 0 {
+		opts.Passwords = dc.Passwords
+	}
+	if dc.NetworkWorkers > 0 {
+		opts.NetworkWorkers = dc.NetworkWorkers
+	}
+	if dc.ComParallelism > 0 {
+		opts.ComParallelism = dc.ComParallelism
+	}
+	if dc.ComTimeoutMs > 0 {
+		opts.ComTimeout = time.Duration(dc.ComTimeoutMs) * time.Millisecond
+	}
+	if dc.TCPTimeoutMs > 0 {
+		opts.TCPTimeout = time.Duration(dc.TCPTimeoutMs) * time.Millisecond
+	}
+	if dc.ExpectedDevices > 0 {
+		opts.ExpectedDevices = dc.ExpectedDevices
+	}
+	if len(dc.PreferredUSB) > 0 {
+		opts.PreferredUSB = dc.PreferredUSB
+	}
+	if len(dc.BlacklistUSB) > 0 {
+		opts.BlacklistUSB = dc.BlacklistUSB
+	}
 }
 
 type ConfigFile struct {
@@ -63,6 +256,15 @@ type ConnectionSettings struct {
 	ComBaudrate string `json:"com_baudrate"`
 	IP          string `json:"ip"`
 	IPPort      string `json:"ip_port"`
+	// USB - идентификация USB-COM адаптера, на котором была найдена ККТ.
+	USB *shtrih.USBIdentity `json:"usb,omitempty"`
+	// Password - пароль ККТ, заданный вручную. Утилита его не записывает:
+	// пароли автопоиска хранятся только в service.json.
+	Password int32 `json:"password,omitempty"`
+	// PasswordRef - ссылка на пароль вместо Password: "env:ИМЯ" или "file:путь".
+	PasswordRef string `json:"password_ref,omitempty"`
+	// SerialNumber - заводской номер ККТ, ожидаемый по этому адресу.
+	SerialNumber string `json:"serial_number,omitempty"`
 }
 type PolledDevice struct {
 	Config shtrih.Config
@@ -72,6 +274,11 @@ type PolledDevice struct {
 // --- ОСНОВНАЯ ЛОГИКА ПРИЛОЖЕНИЯ ---
 
 func main() {
+	dumpFlag := flag.Bool("dump", false, "снять все таблицы настроек ККТ и сохранить снимки в каталог dumps")
+	diffFlag := flag.Bool("diff", false, "сравнить снимок с устройством (-diff old.json) или два снимка (-diff old.json new.json)")
+	daemonFlag := flag.Bool("daemon", false, "работать как служба: опрашивать ККТ по расписанию до получения сигнала завершения")
+	flag.Parse()
+
 	log.Println("--- ЭТО ЗАПУСК ОБНОВЛЕННОЙ ВЕРСИИ! ---")
 	log.Printf("Запуск сбора данных по протоколу Штрих, версия: %s", version)
 
@@ -83,6 +290,46 @@ func main() {
 
 	appConfig := loadAndPrepareServiceConfig()
 	setupLogger(appConfig.Logging)
+	driverName := ""
+	if appConfig.Shtrih != nil {
+		driverName = appConfig.Shtrih.Driver
+	}
+	newDriver = selectDriverFactory(driverName)
+	if appConfig.Shtrih != nil && appConfig.Shtrih.FnForecast != nil {
+		fnThresholds = *appConfig.Shtrih.FnForecast
+	}
+	if appConfig.Shtrih != nil && appConfig.Shtrih.PollConcurrency > 0 {
+		pollConcurrency = appConfig.Shtrih.PollConcurrency
+	}
+	if appConfig.Shtrih != nil && appConfig.Shtrih.DeviceTimeoutSec > 0 {
+		deviceTimeout = time.Duration(appConfig.Shtrih.DeviceTimeoutSec) * time.Second
+	}
+	if appConfig.Shtrih != nil && appConfig.Shtrih.Discovery != nil {
+		appConfig.Shtrih.Discovery.apply(&searchOptions)
+	}
+	if appConfig.Shtrih != nil && appConfig.Shtrih.Recovery != nil {
+		appConfig.Shtrih.Recovery.apply()
+	}
+	if appConfig.Shtrih != nil && appConfig.Shtrih.Daemon != nil {
+		appConfig.Shtrih.Daemon.apply()
+	}
+	if appConfig.Shtrih != nil {
+		sinkConfigs = appConfig.Shtrih.Sinks
+		metricsTextfile = appConfig.Shtrih.MetricsTextfile
+	}
+
+	if *daemonFlag {
+		// Служба сама проверяет обновления по расписанию (см. daemon.go).
+		manifestURL := ""
+		if appConfig.Shtrih != nil {
+			manifestURL = appConfig.Shtrih.ManifestURL
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		runService(ctx, manifestURL)
+		log.Println("Работа приложения завершена.")
+		return
+	}
 
 	// В фоне запускаем проверку обновлений. Функция находится в updater.go
 	if appConfig.Shtrih != nil && appConfig.Shtrih.ManifestURL != "" {
@@ -90,17 +337,33 @@ func main() {
 		go checkForUpdates(version, appConfig.Shtrih.ManifestURL, &wg)
 	}
 
-	configData, err := os.ReadFile(configFileName)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Printf("Файл конфигурации '%s' не найден. Запускаю режим автопоиска...", configFileName)
-			runDiscoveryMode()
-		} else {
-			log.Fatalf("Ошибка чтения файла конфигурации '%s': %v", configFileName, err)
+	switch {
+	case *dumpFlag:
+		runDumpMode(loadDeviceConfigs(), newDriver)
+		wg.Wait()
+		return
+	case *diffFlag:
+		var configs []shtrih.Config
+		if flag.NArg() == 1 {
+			configs = loadDeviceConfigs()
 		}
-	} else {
-		log.Printf("Найден файл конфигурации '%s'. Запускаю стационарный режим...", configFileName)
-		runConfigMode(configData)
+		if err := runDiffMode(flag.Args(), configs, newDriver, os.Stdout); err != nil {
+			log.Printf("Сравнение снимков не выполнено: %v", err)
+		}
+		wg.Wait()
+		return
+	}
+
+	var registry *deviceRegistry
+	if metricsTextfile != "" {
+		registry = newDeviceRegistry()
+		pollObserver, discoveryObserver = registry.record, registry.recordDiscovery
+	}
+	if err := runOnce(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if registry != nil {
+		writeMetricsTextfile(metricsTextfile, registry)
 	}
 
 	log.Println("Опрос завершен. Ожидание завершения фоновых задач")
@@ -144,6 +407,15 @@ func loadAndPrepareServiceConfig() *AppConfig {
 	shtrihScannerRaw, sectionExists := fileStructure["shtrihscanner"]
 
 	if sectionExists {
+		// Указанные в service.json пороги прогноза дополняются значениями по
+		// умолчанию, чтобы можно было задать только часть из них. Без секции
+		// 'fn_forecast' пороги по умолчанию действуют без записи в файл (см. fnThresholds).
+		if section, ok := shtrihScannerRaw.(map[string]interface{}); ok {
+			if _, ok := section["fn_forecast"]; ok {
+				defaultThresholds := shtrih.DefaultForecastThresholds()
+				sc.FnForecast = &defaultThresholds
+			}
+		}
 		// Секция есть, разбираем ее в нашу структуру
 		if shtrihScannerBytes, err := json.Marshal(shtrihScannerRaw); err == nil {
 			json.Unmarshal(shtrihScannerBytes, &sc)
@@ -232,7 +504,46 @@ func setupLogger(config *LogConfig) {
 	log.Printf("Логирование настроено. Уровень: %s, ротация: %d дней, maxSize: %d. Файл: %s", config.LogLevel, logDays, lumberjackLogger.MaxSize, logFilePath)
 }
 
-func runConfigMode(data []byte) {
+// selectDriverFactory возвращает конструктор драйвера по имени из service.json.
+// При пустом значении COM-драйвер используется только там, где он может работать:
+// на 32-битной Windows. В остальных случаях выбирается нативный протокол.
+func selectDriverFactory(name string) func(shtrih.Config) shtrih.Driver {
+	switch strings.ToLower(name) {
+	case "com":
+		log.Println("Используется COM-драйвер Штрих-М.")
+		return shtrih.New
+	case "native":
+		log.Println("Используется нативный протокол Штрих-М.")
+		return shtrih.NewNative
+	case "", "auto":
+	default:
+		log.Printf("Неизвестное значение 'driver': '%s'. Драйвер будет выбран автоматически.", name)
+	}
+	if runtime.GOOS == "windows" && runtime.GOARCH == "386" {
+		return shtrih.New
+	}
+	log.Printf("Платформа %s/%s не поддерживает COM-драйвер, используется нативный протокол.", runtime.GOOS, runtime.GOARCH)
+	return shtrih.NewNative
+}
+
+// runOnce выполняет один цикл работы: стационарный режим, если есть connect.json,
+// иначе автопоиск. Возвращает ошибку, только если connect.json не удалось прочитать.
+func runOnce(ctx context.Context) error {
+	configData, err := os.ReadFile(configFileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("ошибка чтения файла конфигурации '%s': %w", configFileName, err)
+		}
+		log.Printf("Файл конфигурации '%s' не найден. Запускаю режим автопоиска...", configFileName)
+		runDiscoveryMode(ctx)
+		return nil
+	}
+	log.Printf("Найден файл конфигурации '%s'. Запускаю стационарный режим...", configFileName)
+	runConfigMode(ctx, configData)
+	return nil
+}
+
+func runConfigMode(ctx context.Context, data []byte) {
 	// runConfigMode запускает приложение в стационарном режиме с использованием
 	// конфигурации из файла connect.json. Парсит настройки устройств и запускает
 	// процесс опроса ККТ. При ошибках парсинга переключается на режим автопоиска.
@@ -240,7 +551,7 @@ func runConfigMode(data []byte) {
 	var configFile ConfigFile
 	if err := json.Unmarshal(data, &configFile); err != nil {
 		log.Printf("Ошибка парсинга JSON из '%s': %v. Переключаюсь на режим автопоиска.", configFileName, err)
-		runDiscoveryMode()
+		runDiscoveryMode(ctx)
 		return
 	}
 
@@ -248,7 +559,7 @@ func runConfigMode(data []byte) {
 	// Пустой массив shtrih: [] является валидным состоянием.
 	if configFile.Shtrih == nil {
 		log.Printf("В файле '%s' отсутствует секция 'shtrih'. Переключаюсь на режим автопоиска.", configFileName)
-		runDiscoveryMode()
+		runDiscoveryMode(ctx)
 		return
 	}
 
@@ -265,19 +576,45 @@ func runConfigMode(data []byte) {
 		return
 	}
 
-	// Передаем конструктор реального драйвера shtrih.New
-	processDevices(configs, shtrih.New)
+	// Передаем конструктор реального драйвера, выбранный при запуске
+	polledDevices := pollDevices(ctx, configs, newDriver)
+	if ctx.Err() != nil {
+		// Опрос прерван сигналом завершения: сохраняем собранное, но не считаем
+		// неопрошенные устройства пропавшими.
+		writeDeviceRecords(polledDevices)
+		return
+	}
+	state := loadRunState()
+	settings, polledDevices, changed := recoverDevices(ctx, configFile.Shtrih, polledDevices, newDriver, state, time.Now())
+	state.save()
+	writeDeviceRecords(polledDevices)
+	if changed {
+		saveShtrihSettings(settings)
+	}
 }
 
-func runDiscoveryMode() {
+func runDiscoveryMode(ctx context.Context) {
 	// runDiscoveryMode запускает приложение в режиме автопоиска устройств.
 	// Выполняет сканирование COM-портов и TCP-сетей для обнаружения ККТ Штрих-М.
 	// При обнаружении устройств сохраняет их конфигурацию для последующих запусков.
-	configs, err := shtrih.SearchDevices(comSearchTimeout, tcpSearchTimeout)
-	if err != nil {
+	configs, err := findDevices(ctx, searchOptions)
+	if ctx.Err() != nil {
+		log.Println("Автопоиск прерван. Конфигурация не сохранена.")
+		return
+	}
+	var authErr *shtrih.SearchAuthError
+	if errors.As(err, &authErr) {
+		logAuthFailures(authErr)
+	} else if err != nil {
 		log.Printf("Во время поиска устройств произошла ошибка: %v", err)
 	}
 
+	if len(configs) == 0 && authErr != nil {
+		// Устройства есть, но опросить их нельзя. Пустой список не сохраняем,
+		// чтобы поиск повторился после исправления паролей.
+		log.Println("Найдены только устройства, отклонившие пароль. Конфигурация не сохранена.")
+		return
+	}
 	if len(configs) == 0 {
 		log.Println("В ходе сканирования не найдено ни одного устройства Штрих-М.")
 		// Сохраняем информацию об отсутствии устройств, чтобы не сканировать в следующий раз.
@@ -286,44 +623,127 @@ func runDiscoveryMode() {
 	}
 
 	log.Printf("Найдено %d устройств. Начинаю сбор информации...", len(configs))
-	// Передаем конструктор реального драйвера shtrih.New
-	polledDevices := processDevices(configs, shtrih.New)
+	// Передаем конструктор реального драйвера, выбранный при запуске
+	polledDevices := writeDeviceRecords(pollDevices(ctx, configs, newDriver))
 
 	if len(polledDevices) > 0 {
 		saveConfiguration(polledDevices)
 	}
 }
 
-// processDevices принимает функцию-фабрику `newDriverFunc` для создания драйвера.
-// Это позволяет подменять реальный драйвер на мок-драйвер в тестах.
-func processDevices(configs []shtrih.Config, newDriverFunc func(shtrih.Config) shtrih.Driver) []PolledDevice {
-	var polledDevices []PolledDevice
-	for _, config := range configs {
-		log.Printf("--- Опрашиваю устройство: %+v ---", config)
+// pollDevice подключается к устройству и собирает фискальную информацию.
+// При повторяемых ошибках (см. shtrih.IsRetryable) опрос повторяется
+// до pollRetries раз с паузой pollRetryDelay.
+func pollDevice(ctx context.Context, config shtrih.Config, newDriverFunc func(shtrih.Config) shtrih.Driver) (*shtrih.FiscalInfo, error) {
+	var lastErr error
+	for attempt := 0; attempt <= pollRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Повторная попытка опроса (%d из %d) через %v: %v", attempt, pollRetries, pollRetryDelay, lastErr)
+			select {
+			case <-time.After(pollRetryDelay):
+			case <-ctx.Done():
+				return nil, lastErr
+			}
+		}
 		// Используем переданную функцию-фабрику для создания драйвера
 		driver := newDriverFunc(config)
-
-		if err := driver.Connect(); err != nil {
-			log.Printf("Не удалось подключиться к устройству: %v", err)
-			continue
+		if err := connectWithTimeout(ctx, driver); err != nil {
+			lastErr = fmt.Errorf("не удалось подключиться к устройству: %w", err)
+		} else {
+			info, err := getFiscalInfoWithTimeout(ctx, driver)
+			driver.Disconnect()
+			if err == nil {
+				return info, nil
+			}
+			lastErr = fmt.Errorf("ошибка при получении фискальной информации: %w", err)
+		}
+		if !shtrih.IsRetryable(lastErr) || ctx.Err() != nil {
+			break
 		}
+	}
+	return nil, lastErr
+}
 
-		info, err := driver.GetFiscalInfo()
-		driver.Disconnect()
+// connectWithTimeout подключает драйвер, ограничивая время подключения connectTimeout.
+func connectWithTimeout(ctx context.Context, driver shtrih.Driver) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	return shtrih.ConnectContext(ctx, driver)
+}
 
-		if err != nil {
-			log.Printf("Ошибка при получении фискальной информации: %v", err)
-			continue
-		}
-		if info == nil || info.SerialNumber == "" {
-			log.Println("Получена пустая информация или отсутствует серийный номер, данные проигнорированы.")
-			continue
-		}
-		polledDevices = append(polledDevices, PolledDevice{Config: config, Info: info})
+// getFiscalInfoWithTimeout собирает информацию о ККТ, ограничивая время infoTimeout.
+func getFiscalInfoWithTimeout(ctx context.Context, driver shtrih.Driver) (*shtrih.FiscalInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, infoTimeout)
+	defer cancel()
+	return shtrih.GetFiscalInfoContext(ctx, driver)
+}
+
+// logAuthFailures сообщает об устройствах, которые ответили при поиске,
+// но отклонили все пароли, отдельно от ненайденных устройств.
+func logAuthFailures(authErr *shtrih.SearchAuthError) {
+	for _, config := range authErr.Devices {
+		log.Printf("ВНИМАНИЕ: ККТ на %s найдена, но отклонила все пароли. Укажите пароль в 'discovery.passwords' в '%s' или в '%s'.",
+			deviceAddress(config), serviceConfigName, configFileName)
 	}
+}
+
+// logPollError выводит ошибку опроса с подсказкой в зависимости от категории.
+func logPollError(err error) {
+	switch {
+	case errors.Is(err, shtrih.CategoryPassword):
+		log.Printf("Устройство отклонило пароль, проверьте настройки доступа: %v", err)
+	case errors.Is(err, shtrih.CategoryTransport):
+		log.Printf("Нет связи с устройством: %v", err)
+	case errors.Is(err, shtrih.CategoryFN):
+		log.Printf("Ошибка фискального накопителя: %v", err)
+	case errors.Is(err, shtrih.CategoryDeviceState):
+		log.Printf("Устройство в состоянии, не позволяющем выполнить опрос: %v", err)
+	default:
+		log.Printf("Ошибка опроса устройства: %v", err)
+	}
+}
+
+// logOfdBacklog предупреждает о документах, не переданных в ОФД. Через
+// shtrih.OfdBlockingDays дней после первого непереданного документа ККТ блокируется.
+func logOfdBacklog(info *shtrih.FiscalInfo) {
+	status := info.OfdStatus
+	if status == nil || status.UnsentCount == 0 {
+		return
+	}
+	days := status.UnsentDays(time.Now())
+	log.Printf("ВНИМАНИЕ: ККТ %s: не передано в ОФД документов: %d, первый №%d от %s (%d дн.). До блокировки ККТ осталось дней: %d.",
+		info.SerialNumber, status.UnsentCount, status.FirstUnsentNumber, status.FirstUnsentDate, days, shtrih.OfdBlockingDays-days)
+}
+
+// logFnForecast выводит прогноз по замене ФН, если он требует внимания.
+func logFnForecast(info *shtrih.FiscalInfo) {
+	f := info.FnForecast
+	if f == nil || f.Severity == shtrih.SeverityOK {
+		return
+	}
+	log.Printf("ВНИМАНИЕ [%s]: ККТ %s, ФН %s: %s.", f.Severity, info.SerialNumber, info.FnSerial, strings.Join(f.Reasons, "; "))
+}
+
+// processDevices принимает функцию-фабрику `newDriverFunc` для создания драйвера.
+// Это позволяет подменять реальный драйвер на мок-драйвер в тестах.
+// Устройства опрашиваются параллельно (см. pollDevices), данные передаются
+// в приемники после завершения опроса всех устройств.
+func processDevices(configs []shtrih.Config, newDriverFunc func(shtrih.Config) shtrih.Driver) []PolledDevice {
+	return writeDeviceRecords(pollDevices(context.Background(), configs, newDriverFunc))
+}
 
+// writeDeviceRecords передает данные опрошенных устройств в приемники из
+// service.json (по умолчанию - файлы в outputDir), предварительно очистив
+// outputDir от временных файлов. Если данных нет, приемники только
+// закрываются, чтобы HTTP-приемники отправили накопленную очередь.
+func writeDeviceRecords(polledDevices []PolledDevice) []PolledDevice {
 	if len(polledDevices) == 0 {
 		log.Println("--- Не удалось собрать данные ни с одного устройства. Завершение. ---")
+		if len(sinkConfigs) > 0 {
+			if err := newOutputSink().Close(); err != nil {
+				log.Printf("Ошибка при завершении передачи данных: %v", err)
+			}
+		}
 		return nil
 	}
 
@@ -333,11 +753,16 @@ func processDevices(configs []shtrih.Config, newDriverFunc func(shtrih.Config) s
 
 	cleanupDateDirectory()
 
+	out := newOutputSink()
+	defer func() {
+		if err := out.Close(); err != nil {
+			log.Printf("Ошибка при завершении передачи данных: %v", err)
+		}
+	}()
+
 	var successCount int
 	for _, pd := range polledDevices {
 		kktInfo := pd.Info
-		fileName := fmt.Sprintf("%s.json", kktInfo.SerialNumber)
-		filePath := filepath.Join(outputDir, fileName)
 
 		// Определяем, какие данные о рабочей станции использовать.
 		var wsDataToUse map[string]interface{}
@@ -350,15 +775,20 @@ func processDevices(configs []shtrih.Config, newDriverFunc func(shtrih.Config) s
 			wsDataToUse = map[string]interface{}{"hostname": hostname}
 		}
 
-		// Безусловно сохраняем/перезаписываем файл.
-		if err := saveNewMergedInfo(kktInfo, wsDataToUse, filePath); err != nil {
-			log.Printf("Не удалось создать/перезаписать файл для ККТ %s: %v", kktInfo.SerialNumber, err)
+		hostname, _ := wsDataToUse["hostname"].(string)
+		record := sink.Record{
+			SerialNumber: kktInfo.SerialNumber,
+			Hostname:     hostname,
+			Data:         mergeDeviceInfo(kktInfo, wsDataToUse),
+		}
+		if err := out.Write(record); err != nil {
+			log.Printf("Не удалось передать данные ККТ %s: %v", kktInfo.SerialNumber, err)
 		} else {
-			// Логика в saveNewMergedInfo уже выводит сообщение об успехе.
+			log.Printf("Данные для ККТ %s успешно переданы в приемники.", kktInfo.SerialNumber)
 			successCount++
 		}
 	}
-	log.Printf("--- Обработка файлов завершена. Успешно создано/обновлено: %d файлов. ---", successCount)
+	log.Printf("--- Обработка данных завершена. Успешно передано: %d записей. ---", successCount)
 
 	return polledDevices
 }
@@ -478,9 +908,9 @@ func cleanupDateDirectory() {
 	}
 }
 
-// saveNewMergedInfo объединяет данные ККТ и данные рабочей станции (в виде map) и сохраняет в новый JSON-файл.
-// Данные от ККТ имеют приоритет и перезаписывают одноименные поля из данных донора.
-func saveNewMergedInfo(kktInfo *shtrih.FiscalInfo, wsData map[string]interface{}, filePath string) error {
+// mergeDeviceInfo объединяет данные ККТ и данные рабочей станции (в виде map)
+// в одну запись для передачи в приемники.
+func mergeDeviceInfo(kktInfo *shtrih.FiscalInfo, wsData map[string]interface{}) map[string]interface{} {
 	// Шаг 1: Преобразуем данные от нашего ККТ (Штрих) в map.
 	var kktMap map[string]interface{}
 	kktJSON, _ := json.Marshal(kktInfo)
@@ -508,23 +938,44 @@ func saveNewMergedInfo(kktInfo *shtrih.FiscalInfo, wsData map[string]interface{}
 	currentTime := time.Now().Format("2006-01-02 15:04:05")
 	finalMap["current_time"] = currentTime
 	finalMap["v_time"] = currentTime
+	return finalMap
+}
 
-	// Шаг 5: Создаем директорию и сохраняем файл.
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-		return fmt.Errorf("не удалось создать директорию '%s': %w", outputDir, err)
+// newOutputSink создает приемники из настройки "sinks" в service.json. Без
+// настройки, а также если ни один приемник не удалось создать, данные
+// записываются в файлы outputDir, как и раньше. Ссылки на секреты
+// разрешаются здесь, HTTP-приемник по умолчанию использует очередь spoolDir.
+func newOutputSink() sink.Sink {
+	if len(sinkConfigs) == 0 {
+		return sink.NewFile(outputDir)
 	}
-
-	finalJSON, err := json.MarshalIndent(finalMap, "", "    ")
+	var cfgs []sink.Config
+	for _, cfg := range sinkConfigs {
+		if cfg.Type == sink.TypeFile && cfg.Dir == "" {
+			cfg.Dir = outputDir
+		}
+		if cfg.Type == sink.TypeHTTP && cfg.SpoolDir == "" {
+			cfg.SpoolDir = spoolDir
+		}
+		if cfg.SecretRef != "" {
+			secret, err := resolveSecret(cfg.SecretRef)
+			if err != nil {
+				log.Printf("Приемник '%s' пропущен: %v", cfg.Type, err)
+				continue
+			}
+			cfg.Secret = secret
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	out, err := sink.NewFromConfigs(cfgs)
 	if err != nil {
-		return fmt.Errorf("ошибка маршалинга итогового JSON: %w", err)
+		log.Printf("Ошибка в настройке приемников 'sinks': %v", err)
 	}
-
-	if err := os.WriteFile(filePath, finalJSON, 0644); err != nil {
-		return fmt.Errorf("ошибка записи в файл '%s': %w", filePath, err)
+	if out == nil {
+		log.Printf("Не создано ни одного приемника, данные будут записаны в '%s'.", outputDir)
+		return sink.NewFile(outputDir)
 	}
-
-	log.Printf("Данные для ККТ %s успешно сохранены в новый файл: %s", kktInfo.SerialNumber, filePath)
-	return nil
+	return out
 }
 
 // saveEmptyShtrihConfig создает или обновляет connect.json, указывая,
@@ -572,6 +1023,33 @@ func saveConfiguration(polledDevices []PolledDevice) {
 	// Преобразует внутренние структуры shtrih.Config в формат ConnectionSettings для JSON.
 	log.Printf("Сохранение %d найденных конфигураций в файл '%s'...", len(polledDevices), configFileName)
 
+	// Готовим новый срез с настройками для устройств Штрих-М.
+	var newShtrihSettings []ConnectionSettings
+	for _, pd := range polledDevices {
+		settings := convertConfigToSettings(pd.Config)
+		settings.SerialNumber = pd.Info.SerialNumber
+		newShtrihSettings = append(newShtrihSettings, settings)
+	}
+	saveShtrihSettings(newShtrihSettings)
+}
+
+// loadShtrihSettings читает записи устройств из connect.json. Если файла нет
+// или он поврежден, возвращает nil.
+func loadShtrihSettings() []ConnectionSettings {
+	data, err := os.ReadFile(configFileName)
+	if err != nil {
+		return nil
+	}
+	var configFile ConfigFile
+	if err := json.Unmarshal(data, &configFile); err != nil {
+		return nil
+	}
+	return configFile.Shtrih
+}
+
+// saveShtrihSettings записывает список устройств в секцию 'shtrih' файла
+// connect.json, сохраняя все остальные секции файла.
+func saveShtrihSettings(newShtrihSettings []ConnectionSettings) {
 	// Используем map[string]interface{} для неразрушающего редактирования JSON.
 	configMap := make(map[string]interface{})
 
@@ -588,12 +1066,6 @@ func saveConfiguration(polledDevices []PolledDevice) {
 		log.Printf("Предупреждение: не удалось прочитать '%s' (%v). Файл будет создан заново.", configFileName, err)
 	}
 
-	// Готовим новый срез с настройками для устройств Штрих-М.
-	var newShtrihSettings []ConnectionSettings
-	for _, pd := range polledDevices {
-		newShtrihSettings = append(newShtrihSettings, convertConfigToSettings(pd.Config))
-	}
-
 	// Обновляем в карте только ключ 'shtrih'. Все остальные ключи остаются нетронутыми.
 	configMap["shtrih"] = newShtrihSettings
 
@@ -621,12 +1093,25 @@ func convertSettingsToConfigs(settings []ConnectionSettings) []shtrih.Config {
 		"115200": 6, "57600": 5, "38400": 4, "19200": 3, "9600": 2, "4800": 1,
 	}
 	for _, s := range settings {
-		config := shtrih.Config{ConnectionType: s.TypeConnect, Password: 30}
+		password, err := settingsPassword(s)
+		if err != nil {
+			log.Printf("Не удалось получить пароль устройства: %v, пропуск.", err)
+			continue
+		}
+		config := shtrih.Config{ConnectionType: s.TypeConnect, Password: password}
 		switch s.TypeConnect {
 		case 0:
-			comNum, err := strconv.Atoi(s.ComPort[3:])
-			if err != nil {
-				log.Printf("Некорректное имя COM-порта '%s' в конфигурации, пропуск.", s.ComPort)
+			// Номер нужен только COM-драйверу; имена вида /dev/ttyACM0 использует нативный драйвер.
+			var comNum int
+			if strings.HasPrefix(strings.ToUpper(s.ComPort), "COM") {
+				n, err := strconv.Atoi(s.ComPort[3:])
+				if err != nil {
+					log.Printf("Некорректное имя COM-порта '%s' в конфигурации, пропуск.", s.ComPort)
+					continue
+				}
+				comNum = n
+			} else if s.ComPort == "" {
+				log.Println("Не указано имя COM-порта в конфигурации, пропуск.")
 				continue
 			}
 			baudRate, ok := baudRateMap[s.ComBaudrate]
@@ -637,6 +1122,7 @@ func convertSettingsToConfigs(settings []ConnectionSettings) []shtrih.Config {
 			config.ComName = s.ComPort
 			config.ComNumber = int32(comNum)
 			config.BaudRate = baudRate
+			config.USB = s.USB
 		case 6:
 			port, err := strconv.Atoi(s.IPPort)
 			if err != nil {
@@ -658,11 +1144,14 @@ func convertConfigToSettings(config shtrih.Config) ConnectionSettings {
 	baudRateReverseMap := map[int32]string{
 		6: "115200", 5: "57600", 4: "38400", 3: "19200", 2: "9600", 1: "4800",
 	}
+	// Пароль не сохраняется: найденный автопоиском пароль берется из списка
+	// паролей автопоиска при опросе (см. pollWithSearchPasswords).
 	settings := ConnectionSettings{TypeConnect: config.ConnectionType}
 	switch config.ConnectionType {
 	case 0:
 		settings.ComPort = config.ComName
 		settings.ComBaudrate = baudRateReverseMap[config.BaudRate]
+		settings.USB = config.USB
 	case 6:
 		settings.IP = config.IPAddress
 		settings.IPPort = strconv.Itoa(int(config.TCPPort))
@@ -1,11 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"shtrih-kkt/pkg/shtrih"
+	"shtrih-kkt/pkg/sink"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // loadCanonicalKKTData загружает эталонные данные ККТ из файла для тестов.
@@ -95,6 +107,11 @@ func TestProcessDevices_NewFileFromDonor(t *testing.T) {
 		t.Errorf("Поле 'vc' из донора не было добавлено. Ожидалось '%s', получено '%v'", donorData["vc"], resultMap["vc"])
 	}
 
+	// Проверка прогноза по замене ФН, рассчитанного при опросе.
+	if forecast, ok := resultMap["fn_forecast"].(map[string]interface{}); !ok || forecast["severity"] == nil {
+		t.Errorf("Отсутствует прогноз по замене ФН 'fn_forecast', получено '%v'", resultMap["fn_forecast"])
+	}
+
 	// Проверка автоматически сгенерированных полей времени.
 	if _, ok := resultMap["current_time"]; !ok {
 		t.Error("Отсутствует обязательное поле 'current_time'.")
@@ -185,3 +202,1069 @@ func TestFindSourceWorkstationData_FileHandling(t *testing.T) {
 		}
 	})
 }
+
+// TestPollDevice_RetryByCategory проверяет, что опрос повторяется только
+// при повторяемых ошибках, а ошибка пароля сразу завершает попытки.
+func TestPollDevice_RetryByCategory(t *testing.T) {
+	originalDelay := pollRetryDelay
+	pollRetryDelay = 0
+	defer func() { pollRetryDelay = originalDelay }()
+
+	mockKKTData := loadCanonicalKKTData(t, "pkg/shtrih/testdata/canonical_kkt_data.json")
+	config := shtrih.Config{ConnectionType: 6, IPAddress: "127.0.0.1"}
+
+	t.Run("transport error is retried", func(t *testing.T) {
+		calls := 0
+		factory := func(c shtrih.Config) shtrih.Driver {
+			calls++
+			if calls == 1 {
+				return shtrih.NewMockDriver(nil, &shtrih.DeviceError{Code: -1, Category: shtrih.CategoryTransport, Retryable: true}, nil)
+			}
+			return shtrih.NewMockDriver(mockKKTData, nil, nil)
+		}
+
+		info, err := pollDevice(context.Background(), config, factory)
+		if err != nil {
+			t.Fatalf("pollDevice() вернул неожиданную ошибку: %v", err)
+		}
+		if calls != 2 || info.SerialNumber != mockKKTData.SerialNumber {
+			t.Errorf("Ожидалось 2 попытки и успешный опрос, получено попыток: %d", calls)
+		}
+	})
+
+	t.Run("password error is not retried", func(t *testing.T) {
+		calls := 0
+		factory := func(c shtrih.Config) shtrih.Driver {
+			calls++
+			return shtrih.NewMockDriver(nil, &shtrih.DeviceError{Code: 0x4F, Category: shtrih.CategoryPassword}, nil)
+		}
+
+		_, err := pollDevice(context.Background(), config, factory)
+		if !errors.Is(err, shtrih.CategoryPassword) {
+			t.Errorf("Ожидалась ошибка пароля, получено: %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("Ошибка пароля не должна повторяться, выполнено попыток: %d", calls)
+		}
+	})
+}
+
+// TestDumpAndDiffModes проверяет снятие снимка таблиц и сравнение его
+// с устройством, настройки которого изменились после снятия.
+func TestDumpAndDiffModes(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	originalDumpDir := dumpDir
+	dumpDir = t.TempDir()
+	defer func() { dumpDir = originalDumpDir }()
+
+	serial := shtrih.FieldStruct{Table: 18, Number: 1, Name: "Заводской номер", Type: shtrih.FieldString, Size: 16}
+	password := shtrih.FieldStruct{Table: 2, Number: 1, Name: "Пароль", Size: 4, Max: 99999999}
+	tables := map[[3]int]shtrih.TableValue{
+		{18, 1, 1}: {Field: serial, Row: 1, Str: "0012345678901234"},
+		{2, 1, 1}:  {Field: password, Row: 1, Int: 30},
+	}
+	factory := func(c shtrih.Config) shtrih.Driver {
+		return shtrih.NewMockTableDriver(nil, tables)
+	}
+	configs := []shtrih.Config{{ConnectionType: 6, IPAddress: "127.0.0.1"}}
+
+	// --- Act (Действие) ---
+	paths := runDumpMode(configs, factory)
+	if len(paths) != 1 {
+		t.Fatalf("Ожидался один файл снимка, получено: %v", paths)
+	}
+	// Имитируем визит техника: пароль изменен.
+	tables[[3]int{2, 1, 1}] = shtrih.TableValue{Field: password, Row: 1, Int: 31}
+	var out strings.Builder
+	err := runDiffMode([]string{paths[0]}, configs, factory, &out)
+
+	// --- Assert (Проверка) ---
+	if err != nil {
+		t.Fatalf("runDiffMode() вернул неожиданную ошибку: %v", err)
+	}
+	if !strings.Contains(out.String(), "отличий: 1") || !strings.Contains(out.String(), `"30" -> "31"`) {
+		t.Errorf("Отчет о различиях не содержит измененного пароля:\n%s", out.String())
+	}
+}
+
+// portTracker считает одновременные опросы всего и по каждому порту.
+type portTracker struct {
+	mu        sync.Mutex
+	active    map[string]int
+	total     int
+	maxTotal  int
+	maxByPort map[string]int
+}
+
+func (p *portTracker) enter(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active[key]++
+	p.total++
+	if p.active[key] > p.maxByPort[key] {
+		p.maxByPort[key] = p.active[key]
+	}
+	if p.total > p.maxTotal {
+		p.maxTotal = p.total
+	}
+}
+
+func (p *portTracker) leave(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active[key]--
+	p.total--
+}
+
+// slowDriver отвечает с задержкой и отмечает время опроса в portTracker.
+// Заводской номер в ответе равен адресу устройства.
+type slowDriver struct {
+	config  shtrih.Config
+	delay   time.Duration
+	tracker *portTracker
+}
+
+func (d *slowDriver) Connect() error {
+	d.tracker.enter(deviceAddress(d.config))
+	return nil
+}
+
+func (d *slowDriver) Disconnect() error {
+	d.tracker.leave(deviceAddress(d.config))
+	return nil
+}
+
+func (d *slowDriver) GetFiscalInfo() (*shtrih.FiscalInfo, error) {
+	time.Sleep(d.delay)
+	return &shtrih.FiscalInfo{SerialNumber: deviceAddress(d.config) + "/" + d.config.IPAddress}, nil
+}
+
+// TestPollDevices_Concurrency проверяет, что устройства опрашиваются параллельно,
+// устройства на одном COM-порту - по очереди, а результаты идут в порядке конфигурации.
+func TestPollDevices_Concurrency(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	originalConcurrency := pollConcurrency
+	pollConcurrency = 4
+	defer func() { pollConcurrency = originalConcurrency }()
+
+	tracker := &portTracker{active: map[string]int{}, maxByPort: map[string]int{}}
+	factory := func(c shtrih.Config) shtrih.Driver {
+		return &slowDriver{config: c, delay: 30 * time.Millisecond, tracker: tracker}
+	}
+	// IPAddress у COM-устройств используется только как метка для различения результатов.
+	configs := []shtrih.Config{
+		{ConnectionType: 0, ComName: "COM1", IPAddress: "a"},
+		{ConnectionType: 6, IPAddress: "10.0.0.1", TCPPort: 7778},
+		{ConnectionType: 0, ComName: "com1", IPAddress: "b"},
+		{ConnectionType: 6, IPAddress: "10.0.0.2", TCPPort: 7778},
+		{ConnectionType: 6, IPAddress: "10.0.0.3", TCPPort: 7778},
+	}
+
+	// --- Act (Действие) ---
+	polled := pollDevices(context.Background(), configs, factory)
+
+	// --- Assert (Проверка) ---
+	if len(polled) != len(configs) {
+		t.Fatalf("Ожидалось %d результатов, получено %d", len(configs), len(polled))
+	}
+	for i, pd := range polled {
+		want := deviceAddress(configs[i]) + "/" + configs[i].IPAddress
+		if pd.Info.SerialNumber != want || pd.Config != configs[i] {
+			t.Errorf("Результат %d не соответствует порядку конфигурации: %q, ожидалось %q", i, pd.Info.SerialNumber, want)
+		}
+	}
+	if tracker.maxByPort["COM1"] != 1 {
+		t.Errorf("Устройства на COM1 опрашивались параллельно: %d одновременно", tracker.maxByPort["COM1"])
+	}
+	if tracker.maxTotal < 2 || tracker.maxTotal > pollConcurrency {
+		t.Errorf("Одновременно опрашивалось %d устройств, ожидалось от 2 до %d", tracker.maxTotal, pollConcurrency)
+	}
+}
+
+// TestPollDevices_DeviceTimeout проверяет, что зависшее устройство не задерживает
+// опрос дольше бюджета deviceTimeout.
+func TestPollDevices_DeviceTimeout(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	originalTimeout := deviceTimeout
+	deviceTimeout = 50 * time.Millisecond
+	defer func() { deviceTimeout = originalTimeout }()
+
+	tracker := &portTracker{active: map[string]int{}, maxByPort: map[string]int{}}
+	factory := func(c shtrih.Config) shtrih.Driver {
+		return &slowDriver{config: c, delay: 2 * time.Second, tracker: tracker}
+	}
+
+	// --- Act (Действие) ---
+	start := time.Now()
+	polled := pollDevices(context.Background(), []shtrih.Config{{ConnectionType: 6, IPAddress: "10.0.0.9", TCPPort: 7778}}, factory)
+
+	// --- Assert (Проверка) ---
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Опрос не прервался по бюджету времени, прошло %v", elapsed)
+	}
+	if len(polled) != 0 {
+		t.Errorf("Зависшее устройство не должно попасть в результаты: %+v", polled)
+	}
+}
+
+// TestDiscoveryConfig_Apply проверяет, что блок "discovery" переопределяет только
+// заданные параметры поиска.
+func TestDiscoveryConfig_Apply(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	section := []byte(`{"discovery": {"baud_rates": [9600], "subnets": ["10.0.5.0/24"], "tcp_port": 7000, "com_timeout_ms": 500}}`)
+	var sc ShtrihScannerConfig
+	if err := json.Unmarshal(section, &sc); err != nil {
+		t.Fatalf("Не удалось разобрать секцию: %v", err)
+	}
+	opts := shtrih.DefaultSearchOptions()
+	defaults := shtrih.DefaultSearchOptions()
+
+	// --- Act (Действие) ---
+	sc.Discovery.apply(&opts)
+
+	// --- Assert (Проверка) ---
+	if len(opts.BaudRates) != 1 || opts.BaudRates[0] != 9600 {
+		t.Errorf("Скорости не переопределены: %v", opts.BaudRates)
+	}
+	if len(opts.Subnets) != 1 || opts.Subnets[0] != "10.0.5.0/24" || opts.TCPPort != 7000 {
+		t.Errorf("Сетевые параметры не переопределены: %v, порт %d", opts.Subnets, opts.TCPPort)
+	}
+	if opts.ComTimeout != 500*time.Millisecond {
+		t.Errorf("Таймаут COM-порта не переопределен: %v", opts.ComTimeout)
+	}
+	if opts.Password != defaults.Password || opts.TCPTimeout != defaults.TCPTimeout || opts.NetworkWorkers != defaults.NetworkWorkers {
+		t.Errorf("Незаданные параметры изменились: %+v", opts)
+	}
+}
+
+// TestLoadAndPrepareServiceConfig_FnForecast проверяет, что пороги прогноза по
+// умолчанию не записываются в service.json при его обновлении, а частично
+// заданные пороги дополняются значениями по умолчанию.
+func TestLoadAndPrepareServiceConfig_FnForecast(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	tempDir := t.TempDir()
+	originalWD, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("Не удалось сменить рабочую директорию: %v", err)
+	}
+	defer os.Chdir(originalWD)
+	readSection := func() map[string]interface{} {
+		data, _ := os.ReadFile(serviceConfigName)
+		var file map[string]map[string]interface{}
+		json.Unmarshal(data, &file)
+		return file["shtrihscanner"]
+	}
+
+	t.Run("defaults are not written", func(t *testing.T) {
+		os.WriteFile(serviceConfigName, []byte(`{"shtrihscanner": {"enabled": true}}`), 0644)
+
+		// --- Act (Действие) ---
+		config := loadAndPrepareServiceConfig()
+
+		// --- Assert (Проверка) ---
+		section := readSection()
+		if section["manifest_url"] == nil {
+			t.Fatalf("Недостающее поле 'manifest_url' не записано: %v", section)
+		}
+		if _, ok := section["fn_forecast"]; ok {
+			t.Errorf("В service.json записаны пороги по умолчанию: %v", section["fn_forecast"])
+		}
+		if config.Shtrih.FnForecast != nil {
+			t.Errorf("Без 'fn_forecast' ожидались пороги из fnThresholds, получено: %+v", config.Shtrih.FnForecast)
+		}
+	})
+
+	t.Run("partial thresholds are completed", func(t *testing.T) {
+		os.WriteFile(serviceConfigName, []byte(`{"shtrihscanner": {"enabled": true, "fn_forecast": {"warning_days": 10}}}`), 0644)
+
+		// --- Act (Действие) ---
+		config := loadAndPrepareServiceConfig()
+
+		// --- Assert (Проверка) ---
+		want := shtrih.DefaultForecastThresholds()
+		want.WarningDays = 10
+		if config.Shtrih.FnForecast == nil || *config.Shtrih.FnForecast != want {
+			t.Errorf("Получены пороги %+v, ожидались %+v", config.Shtrih.FnForecast, want)
+		}
+		saved, _ := readSection()["fn_forecast"].(map[string]interface{})
+		if saved["warning_days"] != float64(10) || saved["critical_days"] != float64(want.CriticalDays) {
+			t.Errorf("В service.json сохранены пороги %v, ожидались %+v", saved, want)
+		}
+	})
+}
+
+// TestConnectionSettings_USBRoundTrip проверяет, что USB-идентификация порта
+// сохраняется в connect.json и восстанавливается при чтении.
+func TestConnectionSettings_USBRoundTrip(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	config := shtrih.Config{
+		ConnectionType: 0, ComName: "COM4", ComNumber: 4, BaudRate: 6, Password: 30,
+		USB: &shtrih.USBIdentity{VID: "0403", PID: "6001", SerialNumber: "A10K"},
+	}
+
+	// --- Act (Действие) ---
+	data, err := json.Marshal(convertConfigToSettings(config))
+	if err != nil {
+		t.Fatalf("Не удалось сериализовать настройки: %v", err)
+	}
+	var settings ConnectionSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		t.Fatalf("Не удалось разобрать настройки: %v", err)
+	}
+	configs := convertSettingsToConfigs([]ConnectionSettings{settings})
+
+	// --- Assert (Проверка) ---
+	if len(configs) != 1 || configs[0].USB == nil || *configs[0].USB != *config.USB {
+		t.Errorf("USB-идентификация не восстановлена: %s -> %+v", data, configs)
+	}
+}
+
+// TestConvertSettingsToConfigs_Password проверяет пароль из connect.json:
+// по умолчанию, явный и по ссылке на переменную окружения или файл.
+func TestConvertSettingsToConfigs_Password(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	t.Setenv("SHTRIH_TEST_PASSWORD", "17")
+	secretFile := filepath.Join(t.TempDir(), "kkt.secret")
+	if err := os.WriteFile(secretFile, []byte("19\n"), 0600); err != nil {
+		t.Fatalf("Не удалось создать файл секрета: %v", err)
+	}
+	base := ConnectionSettings{TypeConnect: 6, IP: "10.0.0.1", IPPort: "7778"}
+	withPassword := func(password int32, ref string) ConnectionSettings {
+		s := base
+		s.Password, s.PasswordRef = password, ref
+		return s
+	}
+	settings := []ConnectionSettings{
+		base,
+		withPassword(1, ""),
+		withPassword(1, "env:SHTRIH_TEST_PASSWORD"),
+		withPassword(0, "file:"+secretFile),
+		withPassword(0, "env:SHTRIH_TEST_MISSING"),
+	}
+
+	// --- Act (Действие) ---
+	configs := convertSettingsToConfigs(settings)
+
+	// --- Assert (Проверка) ---
+	var got []int32
+	for _, c := range configs {
+		got = append(got, c.Password)
+	}
+	if want := []int32{30, 1, 17, 19}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Пароли = %v, ожидалось %v (устройство с неразрешимой ссылкой пропускается)", got, want)
+	}
+	if s := convertConfigToSettings(configs[0]); s.Password != 0 {
+		t.Errorf("Пароль по умолчанию не должен сохраняться в connect.json, получено %d", s.Password)
+	}
+	if s := convertConfigToSettings(configs[2]); s.Password != 0 || s.PasswordRef != "" {
+		t.Errorf("Пароль не должен сохраняться в connect.json открытым текстом, получено %+v", s)
+	}
+}
+
+// TestPollOne_SearchPasswords проверяет, что ККТ, отклонившая пароль по
+// умолчанию, опрашивается с паролем из списка автопоиска.
+func TestPollOne_SearchPasswords(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	originalOptions, originalRetries := searchOptions, pollRetries
+	defer func() { searchOptions, pollRetries = originalOptions, originalRetries }()
+	searchOptions.Passwords, pollRetries = []int32{1, 29}, 0
+	var tried []int32
+	factory := func(c shtrih.Config) shtrih.Driver {
+		tried = append(tried, c.Password)
+		if c.Password != 29 {
+			return shtrih.NewMockDriver(nil, &shtrih.DeviceError{Code: 0x4F, Category: shtrih.CategoryPassword}, nil)
+		}
+		return shtrih.NewMockDriver(&shtrih.FiscalInfo{SerialNumber: "111"}, nil, nil)
+	}
+
+	// --- Act (Действие) ---
+	info := pollOne(context.Background(), shtrih.Config{ConnectionType: 6, IPAddress: "10.0.0.1", TCPPort: 7778, Password: 30}, factory)
+
+	// --- Assert (Проверка) ---
+	if info == nil || info.SerialNumber != "111" {
+		t.Errorf("ККТ не опрошена с паролем автопоиска, получено: %+v", info)
+	}
+	if want := []int32{30, 1, 29}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("Пароли = %v, ожидалось %v", tried, want)
+	}
+}
+
+// TestRelocateDevices проверяет привязку записей connect.json к заводским номерам
+// и поиск ККТ, которые сменили порт или IP-адрес.
+func TestRelocateDevices(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	originalSearch := searchDevices
+	defer func() { searchDevices = originalSearch }()
+	var searchOpts []shtrih.SearchOptions
+	searchDevices = func(ctx context.Context, opts shtrih.SearchOptions) ([]shtrih.Config, error) {
+		searchOpts = append(searchOpts, opts)
+		return []shtrih.Config{
+			{ConnectionType: 0, ComName: "COM5", BaudRate: 6, Password: 30},
+			{ConnectionType: 6, IPAddress: "10.0.0.5", TCPPort: 7778, Password: 30},
+			{ConnectionType: 6, IPAddress: "10.0.0.9", TCPPort: 7778, Password: 30},
+			{ConnectionType: 6, IPAddress: "10.0.0.10", TCPPort: 7778, Password: 30},
+		}, nil
+	}
+	serials := map[string]string{"COM5": "111", "10.0.0.5:7778": "333", "10.0.0.6:7778": "444", "10.0.0.9:7778": "222", "10.0.0.10:7778": "999"}
+	var mu sync.Mutex
+	calls := map[string]int{}
+	factory := func(c shtrih.Config) shtrih.Driver {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[deviceAddress(c)]++
+		if serial, ok := serials[deviceAddress(c)]; ok {
+			return shtrih.NewMockDriver(&shtrih.FiscalInfo{SerialNumber: serial}, nil, nil)
+		}
+		return shtrih.NewMockDriver(nil, &shtrih.DeviceError{Code: -1, Category: shtrih.CategoryTransport}, nil)
+	}
+	settings := []ConnectionSettings{
+		{TypeConnect: 0, ComPort: "COM3", ComBaudrate: "115200", SerialNumber: "111"},
+		{TypeConnect: 6, IP: "10.0.0.5", IPPort: "7778", SerialNumber: "222", PasswordRef: "env:KKT_PASSWORD"},
+		{TypeConnect: 6, IP: "10.0.0.6", IPPort: "7778"},
+		{TypeConnect: 0, ComPort: "COM7", ComBaudrate: "115200", SerialNumber: "555", USB: &shtrih.USBIdentity{VID: "1A86", PID: "7523", SerialNumber: "B2"}},
+	}
+	t.Setenv("KKT_PASSWORD", "30")
+
+	// --- Act (Действие) ---
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	state := &runState{LastSeen: map[string]time.Time{}}
+	polled, changed := relocateDevices(context.Background(), settings, pollDevices(context.Background(), convertSettingsToConfigs(settings), factory), factory, state, now)
+	// Повторный запуск вскоре после поиска: пропавшая ККТ 555 не ищется.
+	relocateDevices(context.Background(), settings, nil, factory, state, now.Add(10*time.Minute))
+
+	// --- Assert (Проверка) ---
+	if !changed {
+		t.Error("Ожидалось изменение настроек.")
+	}
+	want := []ConnectionSettings{
+		{TypeConnect: 0, ComPort: "COM5", ComBaudrate: "115200", SerialNumber: "111"},
+		{TypeConnect: 6, IP: "10.0.0.9", IPPort: "7778", SerialNumber: "222", PasswordRef: "env:KKT_PASSWORD"},
+		{TypeConnect: 6, IP: "10.0.0.6", IPPort: "7778", SerialNumber: "444"},
+		{TypeConnect: 0, ComPort: "COM7", ComBaudrate: "115200", SerialNumber: "555", USB: &shtrih.USBIdentity{VID: "1A86", PID: "7523", SerialNumber: "B2"}},
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("Настройки после поиска:\n%+v\nожидалось:\n%+v", settings, want)
+	}
+	var got []string
+	for _, pd := range polled {
+		got = append(got, pd.Info.SerialNumber)
+	}
+	if want := []string{"333", "444", "111", "222"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Опрошенные ККТ %v, ожидалось %v", got, want)
+	}
+	if calls["10.0.0.5:7778"] != 1 {
+		t.Errorf("Уже опрошенное устройство опрошено повторно: %d раз", calls["10.0.0.5:7778"])
+	}
+	if len(searchOpts) != 1 || !state.LastRelocation.Equal(now) {
+		t.Fatalf("Ожидался один поиск в %v, выполнено %d, время поиска %v", now, len(searchOpts), state.LastRelocation)
+	}
+	if known := searchOpts[0].KnownUSB; len(known) != 1 || known[0].SerialNumber != "B2" {
+		t.Errorf("Порт адаптера пропавшей ККТ должен проверяться первым, KnownUSB = %v", known)
+	}
+	if searchOpts[0].ExpectedDevices != 3 {
+		t.Errorf("Поиск должен ждать 3 пропавшие ККТ, ExpectedDevices = %d", searchOpts[0].ExpectedDevices)
+	}
+	skip := append([]string(nil), searchOpts[0].SkipAddresses...)
+	sort.Strings(skip)
+	if want := []string{"10.0.0.5:7778", "10.0.0.6:7778"}; !reflect.DeepEqual(skip, want) {
+		t.Errorf("Пропускаемые при поиске адреса %v, ожидалось %v", skip, want)
+	}
+}
+
+// TestRecoverDevices проверяет самовосстановление стационарного режима:
+// счетчик запусков без ответа, повторный поиск и удаление давно пропавших ККТ.
+func TestRecoverDevices(t *testing.T) {
+	originalSearch, originalRuns, originalRetire, originalState := searchDevices, rediscoverAfterRuns, retireAfter, stateFileName
+	defer func() {
+		searchDevices, rediscoverAfterRuns, retireAfter, stateFileName = originalSearch, originalRuns, originalRetire, originalState
+	}()
+	stateFileName = filepath.Join(t.TempDir(), "state.json")
+	rediscoverAfterRuns, retireAfter = 2, 0
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	serials := map[string]string{"COM5": "111", "10.0.0.1:7778": "1", "10.0.0.9:7778": "777"}
+	factory := func(c shtrih.Config) shtrih.Driver {
+		if serial, ok := serials[deviceAddress(c)]; ok {
+			return shtrih.NewMockDriver(&shtrih.FiscalInfo{SerialNumber: serial}, nil, nil)
+		}
+		return shtrih.NewMockDriver(nil, &shtrih.DeviceError{Code: -1, Category: shtrih.CategoryTransport}, nil)
+	}
+
+	t.Run("failed run is counted without search", func(t *testing.T) {
+		searchDevices = func(ctx context.Context, opts shtrih.SearchOptions) ([]shtrih.Config, error) {
+			t.Error("Поиск не должен выполняться до достижения порога.")
+			return nil, nil
+		}
+		state := loadRunState()
+		settings := []ConnectionSettings{{TypeConnect: 0, ComPort: "COM3", ComBaudrate: "115200"}}
+
+		_, polled, changed := recoverDevices(context.Background(), settings, nil, factory, state, now)
+
+		if state.FailedRuns != 1 || len(polled) != 0 || changed {
+			t.Errorf("Ожидался 1 запуск без ответа без изменений, получено: %d, %v, %v", state.FailedRuns, polled, changed)
+		}
+		state.save()
+		if loaded := loadRunState(); loaded.FailedRuns != 1 || !loaded.LastSeen["COM3"].Equal(now) {
+			t.Errorf("Состояние не сохранилось в файле: %+v", loaded)
+		}
+	})
+
+	t.Run("rediscovery merges found devices", func(t *testing.T) {
+		searchDevices = func(ctx context.Context, opts shtrih.SearchOptions) ([]shtrih.Config, error) {
+			return []shtrih.Config{
+				{ConnectionType: 0, ComName: "COM5", BaudRate: 6, Password: 30},
+				{ConnectionType: 6, IPAddress: "10.0.0.9", TCPPort: 7778, Password: 30},
+			}, nil
+		}
+		state := &runState{FailedRuns: 1, LastSeen: map[string]time.Time{}}
+		settings := []ConnectionSettings{{TypeConnect: 0, ComPort: "COM3", ComBaudrate: "115200", SerialNumber: "111"}}
+
+		settings, polled, changed := recoverDevices(context.Background(), settings, nil, factory, state, now)
+
+		want := []ConnectionSettings{
+			{TypeConnect: 0, ComPort: "COM5", ComBaudrate: "115200", SerialNumber: "111"},
+			{TypeConnect: 6, IP: "10.0.0.9", IPPort: "7778", SerialNumber: "777"},
+		}
+		if !changed || !reflect.DeepEqual(settings, want) {
+			t.Errorf("Настройки после поиска:\n%+v\nожидалось:\n%+v", settings, want)
+		}
+		if len(polled) != 2 || state.FailedRuns != 0 {
+			t.Errorf("Ожидалось 2 опрошенных устройства и сброс счетчика, получено: %d, %d", len(polled), state.FailedRuns)
+		}
+	})
+
+	t.Run("missing device is retired", func(t *testing.T) {
+		searchDevices = func(ctx context.Context, opts shtrih.SearchOptions) ([]shtrih.Config, error) { return nil, nil }
+		retireAfter = 48 * time.Hour
+		state := &runState{LastSeen: map[string]time.Time{"1": now.Add(-time.Hour), "2": now.Add(-72 * time.Hour), "3": now.Add(-24 * time.Hour)}}
+		settings := []ConnectionSettings{
+			{TypeConnect: 6, IP: "10.0.0.1", IPPort: "7778", SerialNumber: "1"},
+			{TypeConnect: 6, IP: "10.0.0.2", IPPort: "7778", SerialNumber: "2"},
+			{TypeConnect: 6, IP: "10.0.0.3", IPPort: "7778", SerialNumber: "3"},
+		}
+		polled := pollDevices(context.Background(), convertSettingsToConfigs(settings), factory)
+
+		settings, _, changed := recoverDevices(context.Background(), settings, polled, factory, state, now)
+
+		if !changed || len(settings) != 2 || settings[0].SerialNumber != "1" || settings[1].SerialNumber != "3" {
+			t.Errorf("Ожидалось удаление только ККТ 2, получено: %+v", settings)
+		}
+		if !state.LastSeen["1"].Equal(now) || !state.LastSeen["3"].Equal(now.Add(-24*time.Hour)) {
+			t.Errorf("Время ответа устройств обновлено неверно: %v", state.LastSeen)
+		}
+		if _, ok := state.LastSeen["2"]; ok {
+			t.Error("Удаленная ККТ должна быть удалена и из состояния.")
+		}
+	})
+}
+
+// TestWriteDeviceRecords_Sinks проверяет передачу данных в несколько приемников
+// из service.json: file без каталога пишет в outputDir.
+func TestWriteDeviceRecords_Sinks(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	tempDir := t.TempDir()
+	originalOutputDir, originalSinks := outputDir, sinkConfigs
+	defer func() { outputDir, sinkConfigs = originalOutputDir, originalSinks }()
+	outputDir = filepath.Join(tempDir, "date")
+	sinkConfigs = []sink.Config{{Type: sink.TypeFile}, {Type: sink.TypeHostDir, Dir: filepath.Join(tempDir, "share")}}
+	hostname, _ := os.Hostname()
+	polled := []PolledDevice{{Info: &shtrih.FiscalInfo{SerialNumber: "0012345678901234"}}}
+
+	// --- Act (Действие) ---
+	writeDeviceRecords(polled)
+
+	// --- Assert (Проверка) ---
+	for _, path := range []string{
+		filepath.Join(outputDir, "0012345678901234.json"),
+		filepath.Join(tempDir, "share", hostname, "0012345678901234.json"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Файл приемника не создан: %v", err)
+		}
+	}
+}
+
+// TestWriteDeviceRecords_HTTPSecretRef проверяет, что токен HTTP-приемника
+// берется по ссылке на секрет, а данные ККТ приходят на сервер.
+func TestWriteDeviceRecords_HTTPSecretRef(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	var mu sync.Mutex
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			http.Error(w, "нет доступа", http.StatusUnauthorized)
+			return
+		}
+		var data map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&data)
+		mu.Lock()
+		received = append(received, data)
+		mu.Unlock()
+	}))
+	defer server.Close()
+	t.Setenv("INVENTORY_TOKEN", "secret-token")
+	originalOutputDir, originalSinks := outputDir, sinkConfigs
+	defer func() { outputDir, sinkConfigs = originalOutputDir, originalSinks }()
+	outputDir = t.TempDir()
+	sinkConfigs = []sink.Config{{Type: sink.TypeHTTP, URL: server.URL, Auth: sink.AuthBearer, SecretRef: "env:INVENTORY_TOKEN"}}
+
+	// --- Act (Действие) ---
+	writeDeviceRecords([]PolledDevice{{Info: &shtrih.FiscalInfo{SerialNumber: "0012345678901234"}}})
+
+	// --- Assert (Проверка) ---
+	if len(received) != 1 || received[0]["serialNumber"] != "0012345678901234" || received[0]["hostname"] == nil {
+		t.Errorf("Сервер получил неверные данные: %v", received)
+	}
+}
+
+// TestWriteDeviceRecords_EmptyReplaysSpool проверяет, что очередь HTTP-приемника
+// отправляется и в запуске, где не ответило ни одно устройство.
+func TestWriteDeviceRecords_EmptyReplaysSpool(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { received++ }))
+	defer server.Close()
+	originalOutputDir, originalSinks := outputDir, sinkConfigs
+	defer func() { outputDir, sinkConfigs = originalOutputDir, originalSinks }()
+	outputDir = t.TempDir()
+	queue := t.TempDir()
+	sinkConfigs = []sink.Config{{Type: sink.TypeHTTP, URL: server.URL, SpoolDir: queue}}
+	entry := `{"serial_number": "0012345678901234", "attempts": 1, "next_attempt": "2026-01-01T00:00:00Z", "body": {"serialNumber": "0012345678901234"}}`
+	if err := os.WriteFile(filepath.Join(queue, "0012345678901234.json"), []byte(entry), 0644); err != nil {
+		t.Fatalf("Не удалось подготовить очередь: %v", err)
+	}
+
+	// --- Act (Действие) ---
+	writeDeviceRecords(nil)
+
+	// --- Assert (Проверка) ---
+	if received != 1 {
+		t.Errorf("Сервер получил %d запросов, ожидалась 1 запись из очереди", received)
+	}
+	if names, _ := filepath.Glob(filepath.Join(queue, "*.json")); len(names) != 0 {
+		t.Errorf("Очередь должна быть пуста, осталось: %v", names)
+	}
+}
+
+// TestRunDaemon проверяет расписание службы: задачи выполняются по своим
+// периодам, отмена контекста прерывает текущую задачу и останавливает службу,
+// а запуск новой версии останавливает службу без опроса.
+func TestRunDaemon(t *testing.T) {
+	originalPoll, originalDiscovery, originalUpdate := pollInterval, discoveryInterval, updateInterval
+	defer func() {
+		pollInterval, discoveryInterval, updateInterval = originalPoll, originalDiscovery, originalUpdate
+	}()
+	pollInterval, discoveryInterval, updateInterval = 20*time.Millisecond, 70*time.Millisecond, time.Hour
+
+	t.Run("tasks run on schedule until canceled", func(t *testing.T) {
+		// --- Arrange (Подготовка) ---
+		var polls, discoveries, updates int
+		var running, overlapped bool
+		track := func(counter *int) {
+			if running {
+				overlapped = true
+			}
+			running = true
+			*counter++
+			time.Sleep(time.Millisecond)
+			running = false
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupted := false
+		tasks := daemonTasks{
+			poll: func(ctx context.Context) {
+				track(&polls)
+				if polls == 8 {
+					// Сигнал завершения приходит во время опроса.
+					cancel()
+					interrupted = ctx.Err() != nil
+				}
+			},
+			discover: func(ctx context.Context) { track(&discoveries) },
+			update:   func() bool { track(&updates); return false },
+		}
+
+		// --- Act (Действие) ---
+		done := make(chan struct{})
+		go func() {
+			runDaemon(ctx, tasks)
+			close(done)
+		}()
+
+		// --- Assert (Проверка) ---
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Служба не остановилась после отмены контекста.")
+		}
+		if polls != 8 || !interrupted {
+			t.Errorf("Ожидалось 8 опросов и прерванный последний, получено: %d, %v", polls, interrupted)
+		}
+		if discoveries < 1 || discoveries >= polls {
+			t.Errorf("Автопоиск должен выполняться реже опроса, получено: %d автопоисков на %d опросов", discoveries, polls)
+		}
+		if updates != 1 {
+			t.Errorf("Ожидалась одна проверка обновлений при запуске, получено: %d", updates)
+		}
+		if overlapped {
+			t.Error("Задачи службы не должны выполняться одновременно.")
+		}
+	})
+
+	t.Run("new version stops the service", func(t *testing.T) {
+		// --- Arrange (Подготовка) ---
+		tasks := daemonTasks{
+			poll: func(ctx context.Context) {
+				t.Error("Опрос не должен выполняться после запуска новой версии.")
+			},
+			discover: func(ctx context.Context) {
+				t.Error("Автопоиск не должен выполняться после запуска новой версии.")
+			},
+			update: func() bool { return true },
+		}
+
+		// --- Act (Действие) ---
+		runDaemon(context.Background(), tasks)
+
+		// --- Assert (Проверка): служба вернула управление, задачи не вызывались. ---
+	})
+}
+
+// TestPollConfigured проверяет, что плановый опрос службы опрашивает только
+// устройства из connect.json и не ищет пропавшие ККТ.
+func TestPollConfigured(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	originalSearch, originalDriver, originalOutputDir, originalSinks := searchDevices, newDriver, outputDir, sinkConfigs
+	defer func() {
+		searchDevices, newDriver, outputDir, sinkConfigs = originalSearch, originalDriver, originalOutputDir, originalSinks
+	}()
+	searchDevices = func(ctx context.Context, opts shtrih.SearchOptions) ([]shtrih.Config, error) {
+		t.Error("Плановый опрос не должен выполнять поиск устройств.")
+		return nil, nil
+	}
+	newDriver = func(c shtrih.Config) shtrih.Driver {
+		if c.ComName == "COM3" {
+			return shtrih.NewMockDriver(&shtrih.FiscalInfo{SerialNumber: "111"}, nil, nil)
+		}
+		return shtrih.NewMockDriver(nil, &shtrih.DeviceError{Code: -1, Category: shtrih.CategoryTransport}, nil)
+	}
+	tempDir := t.TempDir()
+	originalWD, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("Не удалось сменить рабочую директорию: %v", err)
+	}
+	defer os.Chdir(originalWD)
+	outputDir, sinkConfigs = filepath.Join(tempDir, "date"), nil
+	settings := []ConnectionSettings{
+		{TypeConnect: 0, ComPort: "COM3", ComBaudrate: "115200", SerialNumber: "111"},
+		{TypeConnect: 0, ComPort: "COM4", ComBaudrate: "115200", SerialNumber: "222"},
+	}
+	saveShtrihSettings(settings)
+
+	// --- Act (Действие) ---
+	pollConfigured(context.Background())
+
+	// --- Assert (Проверка) ---
+	if _, err := os.Stat(filepath.Join(outputDir, "111.json")); err != nil {
+		t.Errorf("Данные ответившей ККТ не сохранены: %v", err)
+	}
+	if got := loadShtrihSettings(); !reflect.DeepEqual(got, settings) {
+		t.Errorf("Плановый опрос изменил '%s':\n%+v", configFileName, got)
+	}
+	if _, err := os.Stat(stateFileName); !os.IsNotExist(err) {
+		t.Errorf("Плановый опрос не должен менять файл состояния, ошибка Stat: %v", err)
+	}
+}
+
+// TestRunDiscoveryMode_Canceled проверяет, что прерванный автопоиск не
+// сохраняет пустой список устройств.
+func TestRunDiscoveryMode_Canceled(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	originalSearch := searchDevices
+	defer func() { searchDevices = originalSearch }()
+	searchDevices = func(ctx context.Context, opts shtrih.SearchOptions) ([]shtrih.Config, error) {
+		return nil, ctx.Err()
+	}
+	tempDir := t.TempDir()
+	originalWD, _ := os.Getwd()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("Не удалось сменить рабочую директорию: %v", err)
+	}
+	defer os.Chdir(originalWD)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// --- Act (Действие) ---
+	runDiscoveryMode(ctx)
+
+	// --- Assert (Проверка) ---
+	if _, err := os.Stat(configFileName); !os.IsNotExist(err) {
+		t.Errorf("Файл '%s' не должен создаваться после прерванного поиска, ошибка Stat: %v", configFileName, err)
+	}
+}
+
+// TestAPIServer проверяет HTTP API службы: список устройств с состоянием
+// опроса, данные ККТ, постановку опроса и автопоиска в очередь.
+func TestAPIServer(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	originalDriver, originalOutputDir, originalSinks, originalObserver := newDriver, outputDir, sinkConfigs, pollObserver
+	defer func() {
+		newDriver, outputDir, sinkConfigs, pollObserver = originalDriver, originalOutputDir, originalSinks, originalObserver
+	}()
+	outputDir, sinkConfigs = t.TempDir(), nil
+	newDriver = func(c shtrih.Config) shtrih.Driver {
+		return shtrih.NewMockDriver(&shtrih.FiscalInfo{SerialNumber: "333"}, nil, nil)
+	}
+	registry := newDeviceRegistry()
+	pollObserver = registry.record
+	transportErr := &shtrih.DeviceError{Code: -1, Category: shtrih.CategoryTransport}
+	registry.record(shtrih.Config{ConnectionType: 6, IPAddress: "10.0.0.1", TCPPort: 7778}, &shtrih.FiscalInfo{SerialNumber: "111", ModelName: "ШТРИХ-М-01Ф"}, nil, 2*time.Second)
+	registry.record(shtrih.Config{ConnectionType: 0, ComName: "COM5"}, nil, transportErr, time.Second)
+	registry.record(shtrih.Config{ConnectionType: 6, IPAddress: "10.0.0.9", TCPPort: 7778}, &shtrih.FiscalInfo{SerialNumber: "777"}, nil, time.Second)
+	registry.record(shtrih.Config{ConnectionType: 6, IPAddress: "10.0.0.5", TCPPort: 7778}, nil, transportErr, time.Second)
+	settings := []ConnectionSettings{
+		{TypeConnect: 0, ComPort: "COM5", ComBaudrate: "115200", SerialNumber: "222"},
+		{TypeConnect: 6, IP: "10.0.0.1", IPPort: "7778", SerialNumber: "111"},
+		{TypeConnect: 0, ComPort: "COM7", ComBaudrate: "115200", SerialNumber: "333"},
+	}
+	jobs := make(chan func(ctx context.Context), 1)
+	api := &apiServer{
+		registry: registry,
+		jobs:     jobs,
+		discover: func(ctx context.Context) {},
+		settings: func() []ConnectionSettings { return settings },
+	}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	request := func(method, path string) (int, []byte) {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set(apiRequestHeader, "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		var body json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	t.Run("GET /devices lists configured and discovered devices", func(t *testing.T) {
+		code, body := request(http.MethodGet, "/devices")
+
+		var devices []deviceStatus
+		json.Unmarshal(body, &devices)
+		var got []string
+		for _, d := range devices {
+			got = append(got, d.Address+" "+d.SerialNumber+" "+d.Status+" "+strconv.FormatBool(d.Configured))
+		}
+		want := []string{
+			"10.0.0.1:7778 111 ok true",
+			"10.0.0.9:7778 777 ok false",
+			"COM5 222 error true",
+			"COM7 333 unknown true",
+		}
+		if code != http.StatusOK || !reflect.DeepEqual(got, want) {
+			t.Errorf("Неверный список устройств (код %d):\nполучено: %q\nожидалось: %q", code, got, want)
+		}
+		if devices[2].LastError == "" || devices[0].DurationMs != 2000 {
+			t.Errorf("Не переданы ошибка или длительность опроса: %+v", devices)
+		}
+	})
+
+	t.Run("GET /devices/{serial} returns latest data", func(t *testing.T) {
+		code, body := request(http.MethodGet, "/devices/111")
+		var info shtrih.FiscalInfo
+		json.Unmarshal(body, &info)
+		if code != http.StatusOK || info.SerialNumber != "111" || info.ModelName != "ШТРИХ-М-01Ф" {
+			t.Errorf("Неверные данные ККТ (код %d): %s", code, body)
+		}
+		if code, _ := request(http.MethodGet, "/devices/999"); code != http.StatusNotFound {
+			t.Errorf("Для неизвестной ККТ ожидался код 404, получено: %d", code)
+		}
+	})
+
+	t.Run("POST /devices/{serial}/poll queues a poll", func(t *testing.T) {
+		if code, _ := request(http.MethodPost, "/devices/999/poll"); code != http.StatusNotFound {
+			t.Errorf("Для неизвестной ККТ ожидался код 404, получено: %d", code)
+		}
+		if code, _ := request(http.MethodPost, "/devices/333/poll"); code != http.StatusAccepted {
+			t.Fatalf("Ожидался код 202, получено: %d", code)
+		}
+		(<-jobs)(context.Background())
+
+		code, body := request(http.MethodGet, "/devices/333")
+		if code != http.StatusOK {
+			t.Errorf("После опроса ожидались данные ККТ 333, получено: %d %s", code, body)
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, "333.json")); err != nil {
+			t.Errorf("Данные опроса не переданы в приемник: %v", err)
+		}
+	})
+
+	t.Run("POST /discover is rejected when the queue is full", func(t *testing.T) {
+		first, _ := request(http.MethodPost, "/discover")
+		second, _ := request(http.MethodPost, "/discover")
+		<-jobs
+		if first != http.StatusAccepted || second != http.StatusServiceUnavailable {
+			t.Errorf("Ожидались коды 202 и 503, получено: %d и %d", first, second)
+		}
+	})
+
+	t.Run("GET /metrics returns Prometheus text", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/metrics")
+		if err != nil {
+			t.Fatalf("GET /metrics: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") ||
+			!strings.Contains(string(data), `shtrih_up{serial="111",model="ШТРИХ-М-01Ф",rnm=""} 1`) {
+			t.Errorf("Неверный ответ /metrics (код %d):\n%s", resp.StatusCode, data)
+		}
+	})
+
+	t.Run("wrong method or path", func(t *testing.T) {
+		if code, _ := request(http.MethodGet, "/devices/111/poll"); code != http.StatusMethodNotAllowed {
+			t.Errorf("Ожидался код 405, получено: %d", code)
+		}
+		if code, _ := request(http.MethodGet, "/metrics/x"); code != http.StatusNotFound {
+			t.Errorf("Ожидался код 404, получено: %d", code)
+		}
+	})
+
+	t.Run("foreign Host or POST without header is forbidden", func(t *testing.T) {
+		rebound, _ := http.NewRequest(http.MethodGet, server.URL+"/devices", nil)
+		rebound.Host = "evil.example:8765"
+		noHeader, _ := http.NewRequest(http.MethodPost, server.URL+"/discover", nil)
+		for _, req := range []*http.Request{rebound, noHeader} {
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusForbidden {
+				t.Errorf("%s %s (Host %q): ожидался код 403, получено: %d", req.Method, req.URL.Path, req.Host, resp.StatusCode)
+			}
+		}
+		if len(jobs) != 0 {
+			t.Errorf("Отклоненный запрос поставил задачу в очередь")
+		}
+	})
+}
+
+// TestIsLoopbackAddr проверяет, что API службы принимает только локальные адреса.
+func TestIsLoopbackAddr(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1:8765": true,
+		"localhost:8765": true,
+		"[::1]:8765":     true,
+		"0.0.0.0:8765":   false,
+		":8765":          false,
+		"10.0.0.1:8765":  false,
+		"127.0.0.1":      false,
+	}
+	for addr, want := range cases {
+		if got := isLoopbackAddr(addr); got != want {
+			t.Errorf("isLoopbackAddr(%q) = %v, ожидалось %v", addr, got, want)
+		}
+	}
+
+	hosts := map[string]bool{
+		"127.0.0.1:8765":     true,
+		"127.0.0.1":          true,
+		"localhost":          true,
+		"[::1]":              true,
+		"[::1]:8765":         true,
+		"evil.example:8765":  false,
+		"localhost.evil.com": false,
+		"":                   false,
+	}
+	for host, want := range hosts {
+		if got := isLoopbackHost(host); got != want {
+			t.Errorf("isLoopbackHost(%q) = %v, ожидалось %v", host, got, want)
+		}
+	}
+}
+
+// TestWriteMetrics проверяет метрики ККТ и автопоиска в формате Prometheus:
+// значения из последнего опроса, время последнего ответа из файла состояния
+// и сохранение в файл для textfile collector.
+func TestWriteMetrics(t *testing.T) {
+	// --- Arrange (Подготовка) ---
+	originalState := stateFileName
+	defer func() { stateFileName = originalState }()
+	stateFileName = filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	lastSeen := now.Add(-48 * time.Hour)
+	(&runState{LastSeen: map[string]time.Time{"222": lastSeen}}).save()
+
+	registry := newDeviceRegistry()
+	registry.now = func() time.Time { return now }
+	registry.record(shtrih.Config{ConnectionType: 6, IPAddress: "10.0.0.1", TCPPort: 7778}, &shtrih.FiscalInfo{
+		SerialNumber:     "111",
+		ModelName:        `ШТРИХ-М "01Ф"`,
+		RNM:              "0000000001012345",
+		SubscriptionInfo: "Подписка до 2 квартала 2026 года",
+		FnForecast:       &shtrih.FnForecast{DaysLeft: 42},
+		OfdStatus:        &shtrih.OfdExchangeStatus{UnsentCount: 3, FirstUnsentDate: "2026-03-09 12:00:00"},
+	}, nil, 1500*time.Millisecond)
+	registry.record(shtrih.Config{ConnectionType: 0, ComName: "COM5"}, nil, errors.New("нет связи"), 3*time.Second)
+	registry.recordDiscovery(2, 1, 90*time.Second)
+	settings := []ConnectionSettings{
+		{TypeConnect: 0, ComPort: "COM5", SerialNumber: "222"},
+		{TypeConnect: 0, ComPort: "COM7", SerialNumber: "333"},
+	}
+
+	// --- Act (Действие) ---
+	var out strings.Builder
+	err := writeMetrics(&out, metricsDevices(registry, settings), registry.lastDiscovery(), now)
+
+	// --- Assert (Проверка) ---
+	if err != nil {
+		t.Fatalf("writeMetrics() вернула ошибку: %v", err)
+	}
+	labels111 := `{serial="111",model="ШТРИХ-М \"01Ф\"",rnm="0000000001012345"}`
+	labels222 := `{serial="222",model="",rnm=""}`
+	expected := []string{
+		"# TYPE shtrih_up gauge",
+		"shtrih_up" + labels111 + " 1",
+		"shtrih_up" + labels222 + " 0",
+		"shtrih_poll_duration_seconds" + labels111 + " 1.5",
+		"shtrih_poll_duration_seconds" + labels222 + " 3",
+		"shtrih_last_success_timestamp_seconds" + labels111 + " " + strconv.FormatInt(now.Unix(), 10),
+		"shtrih_last_success_timestamp_seconds" + labels222 + " " + strconv.FormatInt(lastSeen.Unix(), 10),
+		"shtrih_fn_days_left" + labels111 + " 42",
+		"shtrih_ofd_unsent_documents" + labels111 + " 3",
+		"shtrih_ofd_oldest_unsent_age_seconds" + labels111 + " 86400",
+		"shtrih_license_expiry_timestamp_seconds" + labels111 + " " + strconv.FormatInt(time.Date(2026, 7, 1, 0, 0, 0, 0, time.Local).Unix(), 10),
+		"shtrih_discovery_devices_found 2",
+		"shtrih_discovery_devices_denied 1",
+		"shtrih_discovery_duration_seconds 90",
+	}
+	lines := strings.Split(out.String(), "\n")
+	for _, want := range expected {
+		found := false
+		for _, line := range lines {
+			found = found || line == want
+		}
+		if !found {
+			t.Errorf("В метриках нет строки %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `serial="333"`) {
+		t.Error("ККТ, которая еще не опрашивалась, не должна попадать в метрики.")
+	}
+
+	// --- Act (Действие): файл для textfile collector. ---
+	path := filepath.Join(t.TempDir(), "shtrih.prom")
+	writeMetricsTextfile(path, registry)
+
+	// --- Assert (Проверка) ---
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "shtrih_up"+labels111+" 1") {
+		t.Errorf("Файл метрик не сохранен или неполон (ошибка: %v):\n%s", err, data)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) != 0 {
+		t.Errorf("Временные файлы не удалены: %v", matches)
+	}
+}
@@ -0,0 +1,172 @@
+// Файл: metrics.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"shtrih-kkt/pkg/shtrih"
+)
+
+// metricSample - значение метрики с готовой строкой меток.
+type metricSample struct {
+	labels string
+	value  float64
+}
+
+// metricFamily - метрика Prometheus типа gauge.
+type metricFamily struct {
+	name    string
+	help    string
+	samples []metricSample
+}
+
+func (f *metricFamily) add(labels string, value float64) {
+	f.samples = append(f.samples, metricSample{labels, value})
+}
+
+// metricsDevices возвращает устройства для метрик: состояние из registry
+// (см. deviceRegistry.list) без еще не опрашивавшихся и без устройств с
+// неизвестным заводским номером. Время последнего успешного опроса, которого
+// нет в registry (служба перезапущена, ККТ не ответила в разовом запуске),
+// берется из файла состояния стационарного режима.
+func metricsDevices(registry *deviceRegistry, settings []ConnectionSettings) []deviceStatus {
+	state := loadRunState()
+	var devices []deviceStatus
+	for _, d := range registry.list(settings) {
+		if d.Status == pollStatusUnknown || d.SerialNumber == "" {
+			continue
+		}
+		if d.LastSuccess == nil {
+			if seen, ok := state.LastSeen[d.SerialNumber]; ok {
+				d.LastSuccess = &seen
+			} else if seen, ok := state.LastSeen[d.Address]; ok {
+				d.LastSuccess = &seen
+			}
+		}
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+// writeMetrics выводит метрики устройств и автопоиска в текстовом формате
+// Prometheus. Метрики устройств помечены заводским номером, моделью и РНМ
+// из последнего успешного опроса.
+func writeMetrics(w io.Writer, devices []deviceStatus, discovery discoveryStatus, now time.Time) error {
+	up := &metricFamily{name: "shtrih_up", help: "Последний опрос ККТ успешен (1) или завершился ошибкой (0)."}
+	duration := &metricFamily{name: "shtrih_poll_duration_seconds", help: "Длительность последнего опроса ККТ."}
+	lastSuccess := &metricFamily{name: "shtrih_last_success_timestamp_seconds", help: "Время последнего успешного опроса ККТ."}
+	fnDays := &metricFamily{name: "shtrih_fn_days_left", help: "Дней до окончания срока действия ФН (отрицательное - срок истек)."}
+	ofdUnsent := &metricFamily{name: "shtrih_ofd_unsent_documents", help: "Документы, не переданные в ОФД."}
+	ofdAge := &metricFamily{name: "shtrih_ofd_oldest_unsent_age_seconds", help: "Возраст первого документа, не переданного в ОФД (0 - очередь пуста)."}
+	license := &metricFamily{name: "shtrih_license_expiry_timestamp_seconds", help: "Окончание подписки ККТ: начало квартала, следующего за оплаченным."}
+
+	for _, d := range devices {
+		info := d.info
+		if info == nil {
+			info = &shtrih.FiscalInfo{SerialNumber: d.SerialNumber}
+		}
+		labels := metricLabels("serial", d.SerialNumber, "model", info.ModelName, "rnm", info.RNM)
+
+		if d.Status == pollStatusOK {
+			up.add(labels, 1)
+		} else {
+			up.add(labels, 0)
+		}
+		if d.LastPoll != nil {
+			duration.add(labels, float64(d.DurationMs)/1000)
+		}
+		if d.LastSuccess != nil {
+			lastSuccess.add(labels, float64(d.LastSuccess.Unix()))
+		}
+		if info.FnForecast != nil {
+			fnDays.add(labels, float64(info.FnForecast.DaysLeft))
+		}
+		if info.OfdStatus != nil {
+			ofdUnsent.add(labels, float64(info.OfdStatus.UnsentCount))
+			age := 0.0
+			if first, ok := info.OfdStatus.FirstUnsentTime(); ok && now.After(first) {
+				age = now.Sub(first).Seconds()
+			}
+			ofdAge.add(labels, age)
+		}
+		if expiry, ok := shtrih.LicenseExpiry(info.SubscriptionInfo); ok {
+			license.add(labels, float64(expiry.Unix()))
+		}
+	}
+
+	families := []*metricFamily{up, duration, lastSuccess, fnDays, ofdUnsent, ofdAge, license}
+	if !discovery.LastRun.IsZero() {
+		found := &metricFamily{name: "shtrih_discovery_devices_found", help: "ККТ, найденные последним автопоиском."}
+		denied := &metricFamily{name: "shtrih_discovery_devices_denied", help: "ККТ, отклонившие все пароли при последнем автопоиске."}
+		took := &metricFamily{name: "shtrih_discovery_duration_seconds", help: "Длительность последнего автопоиска."}
+		lastRun := &metricFamily{name: "shtrih_discovery_last_run_timestamp_seconds", help: "Время окончания последнего автопоиска."}
+		found.add("", float64(discovery.Found))
+		denied.add("", float64(discovery.Denied))
+		took.add("", discovery.Duration.Seconds())
+		lastRun.add("", float64(discovery.LastRun.Unix()))
+		families = append(families, found, denied, took, lastRun)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n", f.name, f.help, f.name)
+		for _, s := range f.samples {
+			if s.labels == "" {
+				fmt.Fprintf(bw, "%s %s\n", f.name, strconv.FormatFloat(s.value, 'f', -1, 64))
+			} else {
+				fmt.Fprintf(bw, "%s{%s} %s\n", f.name, s.labels, strconv.FormatFloat(s.value, 'f', -1, 64))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// metricLabels собирает строку меток из пар имя-значение с экранированием значений.
+func metricLabels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
+
+// writeMetricsTextfile сохраняет метрики в файл path для textfile collector
+// node_exporter. Файл заменяется целиком, чтобы коллектор не прочитал его
+// наполовину записанным.
+func writeMetricsTextfile(path string, registry *deviceRegistry) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		log.Printf("Не удалось сохранить метрики в '%s': %v", path, err)
+		return
+	}
+	// CreateTemp создает файл только для владельца, а коллектор может работать
+	// от другого пользователя.
+	err = os.Chmod(tmp.Name(), 0644)
+	if err == nil {
+		err = writeMetrics(tmp, metricsDevices(registry, loadShtrihSettings()), registry.lastDiscovery(), time.Now())
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Не удалось сохранить метрики в '%s': %v", path, err)
+		return
+	}
+	log.Printf("Метрики сохранены в '%s'.", path)
+}
@@ -0,0 +1,125 @@
+// Файл: pkg/shtrih/context.go
+package shtrih
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ContextDriver расширяет Driver методами, которые учитывают дедлайн и отмену
+// контекста. Реализуется всеми драйверами пакета. Для произвольного Driver
+// используйте функции ConnectContext и GetFiscalInfoContext пакета.
+type ContextDriver interface {
+	Driver
+	// ConnectContext устанавливает соединение с ККТ с учетом ctx.
+	ConnectContext(ctx context.Context) error
+	// GetFiscalInfoContext собирает информацию о ККТ с учетом ctx.
+	GetFiscalInfoContext(ctx context.Context) (*FiscalInfo, error)
+}
+
+var (
+	_ ContextDriver = (*comDriver)(nil)
+	_ ContextDriver = (*nativeDriver)(nil)
+	_ ContextDriver = (*mockDriver)(nil)
+)
+
+// Шаги операций, которые указываются в ошибке превышения времени ожидания.
+const (
+	StepConnect       = "подключение"
+	StepCollectInfo   = "сбор информации о ККТ"
+	StepBaseInfo      = "базовая информация"
+	StepFiscalInfo    = "данные фискализации"
+	StepFnInfo        = "данные ФН"
+	StepTables        = "чтение таблиц"
+	StepSearchCOM     = "поиск на COM-портах"
+	StepSearchNetwork = "поиск в сети"
+)
+
+// contextError формирует ошибку прерванной по контексту операции. Через
+// errors.Is такая ошибка сопоставляется с context.DeadlineExceeded или
+// context.Canceled, а также с категорией CategoryTransport.
+func contextError(step string, err error) *DeviceError {
+	e := newDeviceError(codeExchangeTimeout, fmt.Sprintf("превышено время ожидания (%s)", step))
+	if errors.Is(err, context.Canceled) {
+		e.Description = fmt.Sprintf("операция отменена (%s)", step)
+		e.Retryable = false
+	}
+	e.Step = step
+	e.Err = err
+	return e
+}
+
+// ConnectContext подключает драйвер с учетом ctx. Если драйвер не реализует
+// ContextDriver, Connect выполняется в отдельной горутине, а при отмене ctx
+// ожидание прекращается (сам вызов завершится в фоне).
+func ConnectContext(ctx context.Context, d Driver) error {
+	if cd, ok := d.(ContextDriver); ok {
+		return cd.ConnectContext(ctx)
+	}
+	done := make(chan error, 1)
+	go func() { done <- d.Connect() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return contextError(StepConnect, ctx.Err())
+	}
+}
+
+// GetFiscalInfoContext собирает информацию о ККТ с учетом ctx. Для драйверов,
+// не реализующих ContextDriver, действует так же, как ConnectContext.
+func GetFiscalInfoContext(ctx context.Context, d Driver) (*FiscalInfo, error) {
+	if cd, ok := d.(ContextDriver); ok {
+		return cd.GetFiscalInfoContext(ctx)
+	}
+	type result struct {
+		info *FiscalInfo
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		info, err := d.GetFiscalInfo()
+		done <- result{info, err}
+	}()
+	select {
+	case r := <-done:
+		return r.info, r.err
+	case <-ctx.Done():
+		return nil, contextError(StepCollectInfo, ctx.Err())
+	}
+}
+
+// infoStep - шаг сбора FiscalInfo.
+type infoStep struct {
+	name      string
+	errPrefix string
+	fn        func(*FiscalInfo) error
+}
+
+// fiscalInfoSteps возвращает шаги сбора FiscalInfo в порядке выполнения,
+// общие для COM- и нативного драйверов.
+func fiscalInfoSteps(base, fiscal, fn, tables func(*FiscalInfo) error) []infoStep {
+	return []infoStep{
+		{StepBaseInfo, "ошибка получения базовой информации об устройстве", base},
+		{StepFiscalInfo, "ошибка получения информации о фискализации", fiscal},
+		{StepFnInfo, "ошибка получения информации о ФН", fn},
+		{StepTables, "ошибка получения информации из таблиц", tables},
+	}
+}
+
+// collectFiscalInfo выполняет шаги по очереди, проверяя ctx перед каждым.
+// Перед началом шага вызывается onStep с его именем.
+func collectFiscalInfo(ctx context.Context, steps []infoStep, onStep func(string)) (*FiscalInfo, error) {
+	info := &FiscalInfo{}
+	for _, step := range steps {
+		onStep(step.name)
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(step.name, err)
+		}
+		if err := step.fn(info); err != nil {
+			return nil, fmt.Errorf("%s: %w", step.errPrefix, err)
+		}
+	}
+	return info, nil
+}
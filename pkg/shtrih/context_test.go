@@ -0,0 +1,153 @@
+// Тесты операций с учетом контекста
+package shtrih
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestNativeConnectContext_Deadline проверяет, что дедлайн прерывает ожидание
+// ответа от устройства, которое принимает соединение, но молчит.
+func TestNativeConnectContext_Deadline(t *testing.T) {
+	// Arrange: TCP-сервер, который принимает соединение и ничего не отвечает.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Подготовка теста провалилась: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	driver := NewNative(Config{ConnectionType: 6, IPAddress: "127.0.0.1", TCPPort: int32(addr.Port), Password: 30, Timeout: 5 * time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// Act
+	start := time.Now()
+	err = ConnectContext(ctx, driver)
+
+	// Assert
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ConnectContext() не прервался по дедлайну, прошло %v", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Ожидалась ошибка context.DeadlineExceeded, получено: %v", err)
+	}
+	var devErr *DeviceError
+	if !errors.As(err, &devErr) || devErr.Step != StepConnect {
+		t.Errorf("Ожидался шаг %q в ошибке, получено: %v", StepConnect, err)
+	}
+}
+
+// TestNativeGetFiscalInfoContext_Canceled проверяет, что отмененный контекст
+// прерывает сбор информации и разрывает соединение.
+func TestNativeGetFiscalInfoContext_Canceled(t *testing.T) {
+	// Arrange
+	_, addr := startTCPEmulator(t)
+	driver := NewNative(Config{ConnectionType: 6, IPAddress: "127.0.0.1", TCPPort: int32(addr.Port), Password: 30, Timeout: time.Second})
+	if err := driver.Connect(); err != nil {
+		t.Fatalf("Connect() вернул неожиданную ошибку: %v", err)
+	}
+	defer driver.Disconnect()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	_, err := GetFiscalInfoContext(ctx, driver)
+
+	// Assert
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Ожидалась ошибка context.Canceled, получено: %v", err)
+	}
+	if IsRetryable(err) {
+		t.Error("Отмененная операция не должна считаться повторяемой.")
+	}
+	var devErr *DeviceError
+	if !errors.As(err, &devErr) || devErr.Step != StepBaseInfo {
+		t.Errorf("Ожидался шаг %q в ошибке, получено: %v", StepBaseInfo, err)
+	}
+}
+
+// TestMockDriver_ContextDelay проверяет, что задержка мок-драйвера прерывается дедлайном.
+func TestMockDriver_ContextDelay(t *testing.T) {
+	// Arrange
+	driver := &mockDriver{MockData: getSampleFiscalInfo(), Delay: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err := driver.ConnectContext(ctx)
+
+	// Assert
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, CategoryTransport) {
+		t.Fatalf("Ожидалась ошибка превышения времени ожидания, получено: %v", err)
+	}
+	if driver.ConnectCalled {
+		t.Error("Connect() не должен вызываться после истечения дедлайна.")
+	}
+
+	// Act: без дедлайна задержка выдерживается и подключение выполняется.
+	driver.Delay = 10 * time.Millisecond
+	if err := driver.ConnectContext(context.Background()); err != nil {
+		t.Fatalf("ConnectContext() вернул неожиданную ошибку: %v", err)
+	}
+	if _, err := driver.GetFiscalInfoContext(context.Background()); err != nil {
+		t.Errorf("GetFiscalInfoContext() вернул неожиданную ошибку: %v", err)
+	}
+}
+
+// TestExchangeTimeout проверяет, что в свойство Timeout COM-драйвера передается
+// таймаут обмена из конфигурации, ограниченный оставшимся временем ctx.
+func TestExchangeTimeout(t *testing.T) {
+	long, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	short, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if got, ok := exchangeTimeout(long, 5*time.Second); !ok || got != 5*time.Second {
+		t.Errorf("При длинном дедлайне ожидался таймаут 5s, получено: %v, %v", got, ok)
+	}
+	if got, ok := exchangeTimeout(short, 5*time.Second); !ok || got > 50*time.Millisecond {
+		t.Errorf("Таймаут должен быть ограничен дедлайном 50ms, получено: %v, %v", got, ok)
+	}
+	if got, ok := exchangeTimeout(context.Background(), 5*time.Second); !ok || got != 5*time.Second {
+		t.Errorf("Без дедлайна ожидался таймаут 5s, получено: %v, %v", got, ok)
+	}
+	if _, ok := exchangeTimeout(long, 0); ok {
+		t.Error("Без таймаута в конфигурации свойство Timeout драйвера не должно меняться.")
+	}
+}
+
+// TestSearchDevicesContext_Canceled проверяет, что отмена прекращает поиск
+// и возвращает ошибку с этапом поиска.
+func TestSearchDevicesContext_Canceled(t *testing.T) {
+	// Arrange
+	origList, origCOM := listPorts, comProbeAvailable
+	defer func() { listPorts, comProbeAvailable = origList, origCOM }()
+	listPorts = namedPorts("COM97", "COM98")
+	comProbeAvailable = false
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	configs, err := SearchDevicesContext(ctx, 100*time.Millisecond, 100*time.Millisecond)
+
+	// Assert
+	if len(configs) != 0 {
+		t.Errorf("Ожидался пустой результат, получено: %+v", configs)
+	}
+	var devErr *DeviceError
+	if !errors.As(err, &devErr) || devErr.Step != StepSearchCOM {
+		t.Errorf("Ожидалась ошибка с шагом %q, получено: %v", StepSearchCOM, err)
+	}
+}
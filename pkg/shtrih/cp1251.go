@@ -0,0 +1,72 @@
+// Файл: pkg/shtrih/cp1251.go
+package shtrih
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// cp1251High содержит символы Unicode для байтов 0x80-0xBF кодировки Windows-1251.
+// Байты 0xC0-0xFF соответствуют непрерывному диапазону "А"-"я" и вычисляются.
+var cp1251High = [64]rune{
+	0x0402, 0x0403, 0x201A, 0x0453, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x20AC, 0x2030, 0x0409, 0x2039, 0x040A, 0x040C, 0x040B, 0x040F,
+	0x0452, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	utf8.RuneError, 0x2122, 0x0459, 0x203A, 0x045A, 0x045C, 0x045B, 0x045F,
+	0x00A0, 0x040E, 0x045E, 0x0408, 0x00A4, 0x0490, 0x00A6, 0x00A7,
+	0x0401, 0x00A9, 0x0404, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x0407,
+	0x00B0, 0x00B1, 0x0406, 0x0456, 0x0491, 0x00B5, 0x00B6, 0x00B7,
+	0x0451, 0x2116, 0x0454, 0x00BB, 0x0458, 0x0405, 0x0455, 0x0457,
+}
+
+// cp1251Reverse - обратная таблица для кодирования символов 0x80-0xBF.
+var cp1251Reverse = make(map[rune]byte, len(cp1251High))
+
+func init() {
+	for i, r := range cp1251High {
+		if r != utf8.RuneError {
+			cp1251Reverse[r] = byte(0x80 + i)
+		}
+	}
+}
+
+// decodeCP1251 преобразует строку в кодировке Windows-1251, которую возвращает ККТ,
+// в UTF-8. Строка обрезается по первому нулевому байту (поля таблиц дополняются нулями).
+func decodeCP1251(data []byte) string {
+	var sb strings.Builder
+	sb.Grow(len(data))
+	for _, b := range data {
+		switch {
+		case b == 0:
+			return sb.String()
+		case b < 0x80:
+			sb.WriteByte(b)
+		case b >= 0xC0:
+			sb.WriteRune(rune(b-0xC0) + 0x0410)
+		default:
+			sb.WriteRune(cp1251High[b-0x80])
+		}
+	}
+	return sb.String()
+}
+
+// encodeCP1251 преобразует UTF-8 строку в Windows-1251. Символы, которые
+// невозможно закодировать, заменяются на '?'.
+func encodeCP1251(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			out = append(out, byte(r))
+		case r >= 0x0410 && r <= 0x044F:
+			out = append(out, byte(r-0x0410+0xC0))
+		default:
+			if b, ok := cp1251Reverse[r]; ok {
+				out = append(out, b)
+			} else {
+				out = append(out, '?')
+			}
+		}
+	}
+	return out
+}
@@ -1,20 +1,21 @@
 // Package shtrih предоставляет интерфейс для взаимодействия с фискальными
-// регистраторами "Штрих-М" через нативный COM-драйвер.
+// регистраторами "Штрих-М" через нативный COM-драйвер либо напрямую
+// по протоколу "Штрих-М" (см. NewNative).
 package shtrih
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net"
-	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
-	"go.bug.st/serial"
 )
 
 // Config определяет параметры для подключения к ККТ.
@@ -33,6 +34,11 @@ type Config struct {
 	BaudRate int32 `json:"baudRate,omitempty"`
 	// Пароль для подключения (по умолчанию 30).
 	Password int32 `json:"-"`
+	// Таймаут ожидания ответа ККТ. Для нативного драйвера - таймаут
+	// служебного обмена ENQ/ACK; если не задан, используется значение по умолчанию.
+	Timeout time.Duration `json:"-"`
+	// USB-идентификация порта, если ККТ найдена на USB-COM порту.
+	USB *USBIdentity `json:"usb,omitempty"`
 }
 
 // FiscalInfo содержит агрегированную информацию о фискальном регистраторе.
@@ -54,6 +60,11 @@ type FiscalInfo struct {
 	AttributeExcise  bool   `json:"attribute_excise"`   // Признак торговли подакцизными товарами
 	AttributeMarked  bool   `json:"attribute_marked"`   // Признак торговли маркированными товарами
 	SubscriptionInfo string `json:"licenses,omitempty"` // Строка с лицензиями в расшифрованном виде
+
+	FnStatus   *FnStatus          `json:"fn_status,omitempty"`   // Фаза жизни, текущий документ и предупреждения ФН
+	OfdStatus  *OfdExchangeStatus `json:"ofd_status,omitempty"`  // Очередь документов для передачи в ОФД
+	FnMemory   *FnMemoryResource  `json:"fn_memory,omitempty"`   // Свободный ресурс памяти ФН (только nativeDriver)
+	FnForecast *FnForecast        `json:"fn_forecast,omitempty"` // Прогноз по замене ФН (см. ForecastFn)
 }
 
 // Driver определяет основной интерфейс для работы с ККТ.
@@ -68,49 +79,99 @@ type Driver interface {
 
 // comDriver является реализацией интерфейса Driver для работы через COM.
 type comDriver struct {
-	config    Config
+	config Config
+
+	// mu защищает sta и prev. Поля ниже обслуживаются только в потоке sta.
+	mu sync.Mutex
+	// sta - поток, которому принадлежит COM-объект драйвера; nil, если нет подключения.
+	sta *staWorker
+	// prev - остановленный поток, который еще выполняет прерванный вызов.
+	prev *staWorker
+
 	dispatch  *ole.IDispatch
 	connected bool
 }
 
 // New создает новый экземпляр драйвера с указанной конфигурацией.
+// Драйвер можно использовать из любых горутин: все вызовы COM-объекта
+// выполняются в отдельном потоке ОС, который создается при подключении.
 func New(config Config) Driver {
 	return &comDriver{config: config}
 }
 
 // Connect инициализирует COM-объект и устанавливает соединение с ККТ.
-// Важно: эта операция должна выполняться в заблокированном потоке ОС из-за
-// особенностей работы COM (Single-Threaded Apartment).
 func (d *comDriver) Connect() error {
-	if d.connected {
+	return d.ConnectContext(context.Background())
+}
+
+// ConnectContext устанавливает соединение с учетом ctx. Вызов метода COM-объекта
+// прервать нельзя: при отмене ctx ожидание прекращается, а поток драйвера
+// завершится, когда вызов вернется. Config.Timeout передается драйверу в
+// свойство Timeout, но не больше времени, оставшегося до дедлайна ctx.
+func (d *comDriver) ConnectContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(StepConnect, err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sta != nil {
 		return nil
 	}
-	runtime.LockOSThread()
-	// Инициализация COM-библиотеки для текущего потока.
+	// Поток от прерванного вызова еще владеет полями драйвера, ждем его.
+	if d.prev != nil {
+		select {
+		case <-d.prev.done:
+			d.prev = nil
+		case <-ctx.Done():
+			return contextError(StepConnect, ctx.Err())
+		}
+	}
+
+	w, err := startSTA(comInitialize, d.release)
+	if err != nil {
+		return fmt.Errorf("COM init failed: %w", err)
+	}
+	var connErr error
+	if err := w.call(ctx, func() { connErr = d.connect(ctx) }); err != nil {
+		if !w.stop() {
+			d.prev = w
+		}
+		return contextError(StepConnect, err)
+	}
+	if connErr != nil {
+		w.stop()
+		return connErr
+	}
+	d.sta = w
+	log.Println("Подключение к ККТ успешно установлено.")
+	return nil
+}
+
+// comInitialize инициализирует COM-библиотеку для текущего потока.
+func comInitialize() error {
 	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
 		if err := ole.CoInitialize(0); err != nil {
-			runtime.UnlockOSThread()
-			return fmt.Errorf("COM init failed: %w", err)
+			return err
 		}
 	}
+	return nil
+}
 
+// connect создает COM-объект и вызывает его метод Connect. Выполняется в потоке sta.
+func (d *comDriver) connect(ctx context.Context) error {
 	// Создание COM-объекта драйвера "Штрих-М".
 	unknown, err := oleutil.CreateObject("AddIn.DrvFR")
 	if err != nil {
-		ole.CoUninitialize()
-		runtime.UnlockOSThread()
 		return fmt.Errorf("create COM object failed: %w", err)
 	}
 
 	// Получение интерфейса IDispatch для взаимодействия с объектом.
 	d.dispatch, err = unknown.QueryInterface(ole.IID_IDispatch)
+	unknown.Release()
 	if err != nil {
-		unknown.Release()
-		ole.CoUninitialize()
-		runtime.UnlockOSThread()
+		d.dispatch = nil
 		return fmt.Errorf("query interface failed: %w", err)
 	}
-	unknown.Release()
 
 	// Установка свойств подключения в зависимости от типа.
 	oleutil.PutProperty(d.dispatch, "ConnectionType", d.config.ConnectionType)
@@ -124,56 +185,106 @@ func (d *comDriver) Connect() error {
 		oleutil.PutProperty(d.dispatch, "TCPPort", d.config.TCPPort)
 		oleutil.PutProperty(d.dispatch, "UseIPAddress", true)
 	}
+	if timeout, ok := exchangeTimeout(ctx, d.config.Timeout); ok {
+		oleutil.PutProperty(d.dispatch, "Timeout", timeout.Milliseconds())
+	}
 
 	// Вызов метода Connect самого COM-объекта.
 	if _, err := oleutil.CallMethod(d.dispatch, "Connect"); err != nil {
-		d.Disconnect()
 		return fmt.Errorf("connect call failed: %w", err)
 	}
 	// Проверка кода ошибки, возвращаемого драйвером.
 	if err := d.checkError(); err != nil {
-		d.Disconnect()
 		return fmt.Errorf("driver error on connect: %w", err)
 	}
-
 	d.connected = true
-	log.Println("Подключение к ККТ успешно установлено.")
 	return nil
 }
 
-// Disconnect разрывает соединение, освобождает COM-ресурсы и разблокирует поток ОС.
+// exchangeTimeout возвращает значение свойства Timeout драйвера: таймаут
+// обмена timeout, ограниченный временем до дедлайна ctx. Timeout драйвера
+// действует на каждый обмен, поэтому весь дедлайн в него не передается.
+// false означает, что таймаут не задан и остается значение драйвера.
+func exchangeTimeout(ctx context.Context, timeout time.Duration) (time.Duration, bool) {
+	if timeout <= 0 {
+		return 0, false
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout, true
+}
+
+// release разрывает соединение, освобождает COM-объект и деинициализирует COM.
+// Выполняется в потоке sta при его остановке.
+func (d *comDriver) release() {
+	if d.dispatch != nil {
+		if d.connected {
+			oleutil.CallMethod(d.dispatch, "Disconnect")
+		}
+		d.dispatch.Release()
+		d.dispatch = nil
+	}
+	d.connected = false
+	ole.CoUninitialize()
+}
+
+// Disconnect разрывает соединение, освобождает COM-ресурсы и останавливает поток драйвера.
 func (d *comDriver) Disconnect() error {
-	if !d.connected {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sta == nil {
 		return nil
 	}
-	oleutil.CallMethod(d.dispatch, "Disconnect")
-	d.dispatch.Release()
-	ole.CoUninitialize()
-	runtime.UnlockOSThread()
-	d.connected = false
+	if !d.sta.stop() {
+		d.prev = d.sta
+		log.Println("COM-драйвер занят прерванным вызовом, ресурсы будут освобождены после его завершения.")
+	}
+	d.sta = nil
 	log.Println("Соединение с ККТ разорвано.")
 	return nil
 }
 
-// GetFiscalInfo является orchestrator-методом, который последовательно вызывает
-// приватные методы для сбора различных частей информации о ККТ.
-func (d *comDriver) GetFiscalInfo() (*FiscalInfo, error) {
-	if !d.connected {
-		return nil, fmt.Errorf("драйвер не подключен")
+// do выполняет fn в потоке драйвера. При отмене ctx возвращается ошибка
+// с шагом, который вернет step.
+func (d *comDriver) do(ctx context.Context, step func() string, fn func() error) error {
+	d.mu.Lock()
+	w := d.sta
+	d.mu.Unlock()
+	if w == nil {
+		return fmt.Errorf("драйвер не подключен")
 	}
-	info := &FiscalInfo{}
 	var err error
-	if err = d.getBaseDeviceInfo(info); err != nil {
-		return nil, fmt.Errorf("ошибка получения базовой информации об устройстве: %w", err)
-	}
-	if err = d.getFiscalizationInfo(info); err != nil {
-		return nil, fmt.Errorf("ошибка получения информации о фискализации: %w", err)
-	}
-	if err = d.getFnInfo(info); err != nil {
-		return nil, fmt.Errorf("ошибка получения информации о ФН: %w", err)
+	if callErr := w.call(ctx, func() { err = fn() }); callErr != nil {
+		if ctx.Err() != nil {
+			return contextError(step(), callErr)
+		}
+		return callErr
 	}
-	if err = d.getInfoFromTables(info); err != nil {
-		return nil, fmt.Errorf("ошибка получения информации из таблиц: %w", err)
+	return err
+}
+
+// GetFiscalInfo является orchestrator-методом, который последовательно вызывает
+// приватные методы для сбора различных частей информации о ККТ.
+func (d *comDriver) GetFiscalInfo() (*FiscalInfo, error) {
+	return d.GetFiscalInfoContext(context.Background())
+}
+
+// GetFiscalInfoContext собирает информацию о ККТ, проверяя ctx перед каждым шагом.
+// Если ctx отменен во время вызова COM-объекта, возвращается ошибка с этим шагом.
+func (d *comDriver) GetFiscalInfoContext(ctx context.Context) (*FiscalInfo, error) {
+	var step atomic.Value
+	step.Store(StepBaseInfo)
+	var info *FiscalInfo
+	err := d.do(ctx, func() string { return step.Load().(string) }, func() error {
+		var err error
+		info, err = collectFiscalInfo(ctx, fiscalInfoSteps(d.getBaseDeviceInfo, d.getFiscalizationInfo, d.getFnInfo, d.getInfoFromTables), func(s string) { step.Store(s) })
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 	return info, nil
 }
@@ -254,6 +365,8 @@ func (d *comDriver) getFiscalizationInfo(info *FiscalInfo) error {
 }
 
 // getFnInfo собирает информацию непосредственно с фискального накопителя.
+// Ресурс памяти ФН (FF3Dh) DrvFR не возвращает, поэтому FnMemory остается
+// пустым, и прогноз строится только по сроку ФН и флагам предупреждения.
 func (d *comDriver) getFnInfo(info *FiscalInfo) error {
 	log.Println("Запрос данных ФН...")
 	oleutil.CallMethod(d.dispatch, "FNGetSerial")
@@ -280,9 +393,93 @@ func (d *comDriver) getFnInfo(info *FiscalInfo) error {
 	}
 	fnExec, _ := d.getPropertyString("FNImplementation")
 	info.FnExecution = strings.TrimSpace(fnExec)
+
+	if status, err := d.getFnStatus(); err != nil {
+		if errors.Is(err, CategoryTransport) {
+			return err
+		}
+		log.Printf("Предупреждение: статус ФН недоступен: %v", err)
+	} else {
+		info.FnStatus = status
+	}
+
+	if status, err := d.getOfdStatus(); err != nil {
+		if errors.Is(err, CategoryTransport) {
+			return err
+		}
+		log.Printf("Предупреждение: статус обмена с ОФД недоступен: %v", err)
+	} else {
+		info.OfdStatus = status
+	}
 	return nil
 }
 
+// getOfdStatus запрашивает статус информационного обмена с ОФД методом FNGetInfoExchangeStatus.
+func (d *comDriver) getOfdStatus() (*OfdExchangeStatus, error) {
+	if _, err := oleutil.CallMethod(d.dispatch, "FNGetInfoExchangeStatus"); err != nil {
+		return nil, err
+	}
+	if err := d.checkError(); err != nil {
+		return nil, err
+	}
+	flags, _ := d.getPropertyInt32("InfoExchangeStatus")
+	messageState, _ := d.getPropertyInt32("MessageState")
+	count, _ := d.getPropertyInt32("MessageCount")
+	docNumber, _ := d.getPropertyInt32("DocumentNumber")
+	status := &OfdExchangeStatus{
+		StatusFlags:       byte(flags),
+		ConnectionActive:  flags&ofdFlagConnected != 0,
+		MessagePending:    flags&ofdFlagMessagePending != 0,
+		WaitingReceipt:    flags&ofdFlagWaitingReceipt != 0,
+		ReadingMessage:    messageState != 0,
+		UnsentCount:       int(count),
+		FirstUnsentNumber: uint32(docNumber),
+	}
+
+	dateVar, err := d.getPropertyVariant("Date")
+	if err == nil {
+		defer dateVar.Clear()
+		timeStr, _ := d.getPropertyString("Time")
+		if date, ok := dateVar.Value().(time.Time); ok && !date.IsZero() && status.UnsentCount > 0 {
+			t, _ := time.Parse("15:04:05", timeStr)
+			status.FirstUnsentDate = time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, time.Local).Format("2006-01-02 15:04:05")
+		}
+	}
+	return status, nil
+}
+
+// getFnStatus запрашивает статус ФН методом FNGetStatus.
+func (d *comDriver) getFnStatus() (*FnStatus, error) {
+	if _, err := oleutil.CallMethod(d.dispatch, "FNGetStatus"); err != nil {
+		return nil, err
+	}
+	if err := d.checkError(); err != nil {
+		return nil, err
+	}
+	phase, _ := d.getPropertyInt32("FNLifeState")
+	flags, _ := d.getPropertyInt32("FNWarningFlags")
+	status := newFnStatus(FnPhase(phase), byte(flags))
+
+	docType, _ := d.getPropertyInt32("FNCurrentDocument")
+	status.OpenDocumentType = byte(docType)
+	status.DocumentOpen = docType != 0
+	session, _ := d.getPropertyInt32("FNSessionState")
+	status.ShiftOpen = session != 0
+	docNumber, _ := d.getPropertyInt32("DocumentNumber")
+	status.LastDocumentNumber = uint32(docNumber)
+
+	dateVar, err := d.getPropertyVariant("Date")
+	if err == nil {
+		defer dateVar.Clear()
+		timeStr, _ := d.getPropertyString("Time")
+		if date, ok := dateVar.Value().(time.Time); ok && !date.IsZero() {
+			t, _ := time.Parse("15:04:05", timeStr)
+			status.LastDocumentDate = time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, time.Local).Format("2006-01-02 15:04:05")
+		}
+	}
+	return status, nil
+}
+
 // getInfoFromTables читает данные из внутренних таблиц ККТ,
 // которые недоступны через высокоуровневые методы.
 func (d *comDriver) getInfoFromTables(info *FiscalInfo) error {
@@ -336,8 +533,107 @@ func (d *comDriver) readTableField(tableNum, rowNum, fieldNum int) (string, erro
 	return d.getPropertyString("ValueOfFieldString")
 }
 
+// GetTableStruct запрашивает структуру таблицы методом GetTableStruct.
+func (d *comDriver) GetTableStruct(table int) (*TableStruct, error) {
+	var ts *TableStruct
+	err := d.do(context.Background(), stepTables, func() error {
+		var err error
+		ts, err = d.getTableStruct(table)
+		return err
+	})
+	return ts, err
+}
+
+func (d *comDriver) getTableStruct(table int) (*TableStruct, error) {
+	oleutil.PutProperty(d.dispatch, "TableNumber", table)
+	if _, err := oleutil.CallMethod(d.dispatch, "GetTableStruct"); err != nil {
+		return nil, err
+	}
+	if err := d.checkError(); err != nil {
+		return nil, err
+	}
+	name, _ := d.getPropertyString("TableName")
+	rows, _ := d.getPropertyInt32("RowNumber")
+	fields, _ := d.getPropertyInt32("FieldNumber")
+	return &TableStruct{Number: table, Name: strings.TrimSpace(name), Rows: int(rows), Fields: int(fields)}, nil
+}
+
+// GetFieldStruct запрашивает структуру поля методом GetFieldStruct.
+// Свойство FieldType драйвера равно TRUE для строковых полей.
+func (d *comDriver) GetFieldStruct(table, field int) (*FieldStruct, error) {
+	var fs *FieldStruct
+	err := d.do(context.Background(), stepTables, func() error {
+		var err error
+		fs, err = d.getFieldStruct(table, field)
+		return err
+	})
+	return fs, err
+}
+
+func (d *comDriver) getFieldStruct(table, field int) (*FieldStruct, error) {
+	oleutil.PutProperty(d.dispatch, "TableNumber", table)
+	oleutil.PutProperty(d.dispatch, "FieldNumber", field)
+	if _, err := oleutil.CallMethod(d.dispatch, "GetFieldStruct"); err != nil {
+		return nil, err
+	}
+	if err := d.checkError(); err != nil {
+		return nil, err
+	}
+	fs := &FieldStruct{Table: table, Number: field, Type: FieldNumber}
+	name, _ := d.getPropertyString("FieldName")
+	fs.Name = strings.TrimSpace(name)
+	if typeVar, err := d.getPropertyVariant("FieldType"); err == nil {
+		if isString, ok := typeVar.Value().(bool); ok && isString {
+			fs.Type = FieldString
+		}
+		typeVar.Clear()
+	}
+	size, _ := d.getPropertyInt32("FieldSize")
+	fs.Size = int(size)
+	if fs.Type == FieldNumber {
+		minStr, _ := d.getPropertyString("MINValueOfField")
+		maxStr, _ := d.getPropertyString("MAXValueOfField")
+		fs.Min, _ = strconv.ParseUint(strings.TrimSpace(minStr), 10, 64)
+		fs.Max, _ = strconv.ParseUint(strings.TrimSpace(maxStr), 10, 64)
+	}
+	return fs, nil
+}
+
+// ReadTable читает поле таблицы методом ReadTable и возвращает значение
+// вместе со структурой поля.
+func (d *comDriver) ReadTable(table, row, field int) (*TableValue, error) {
+	var v *TableValue
+	err := d.do(context.Background(), stepTables, func() error {
+		var err error
+		v, err = d.readTable(table, row, field)
+		return err
+	})
+	return v, err
+}
+
+func (d *comDriver) readTable(table, row, field int) (*TableValue, error) {
+	fs, err := d.getFieldStruct(table, field)
+	if err != nil {
+		return nil, err
+	}
+	str, err := d.readTableField(table, row, field)
+	if err != nil {
+		return nil, err
+	}
+	v := &TableValue{Field: *fs, Row: row}
+	if fs.Type == FieldString {
+		v.Str = str
+	} else {
+		v.Int, _ = strconv.ParseUint(strings.TrimSpace(str), 10, 64)
+	}
+	return v, nil
+}
+
+// stepTables возвращает шаг для ошибок методов TableDriver.
+func stepTables() string { return StepTables }
+
 // checkError проверяет свойство ResultCode драйвера и, если оно не равно 0,
-// возвращает ошибку с текстовым описанием.
+// возвращает *DeviceError с кодом, категорией и описанием из драйвера.
 func (d *comDriver) checkError() error {
 	resultCode, err := d.getPropertyInt32("ResultCode")
 	if err != nil {
@@ -345,7 +641,7 @@ func (d *comDriver) checkError() error {
 	}
 	if resultCode != 0 {
 		description, _ := d.getPropertyString("ResultCodeDescription")
-		return fmt.Errorf("ошибка драйвера: [%d] %s", resultCode, description)
+		return newDeviceError(resultCode, description)
 	}
 	return nil
 }
@@ -408,174 +704,3 @@ func (d *comDriver) getPropertyInt32(propName string) (int32, error) {
 		return 0, fmt.Errorf("неожиданный тип для %s: %T", propName, v)
 	}
 }
-
-// SearchDevices выполняет двухэтапный поиск ККТ: сначала на COM-портах,
-// затем в стандартных для RNDIS IP-подсетях.
-func SearchDevices(comTimeout, tcpTimeout time.Duration) ([]Config, error) {
-	var foundDevices []Config
-
-	// Этап 1: Последовательный поиск на COM-портах.
-	log.Println("--- Начинаю поиск устройств на COM-портах ---")
-	ports, err := serial.GetPortsList()
-	if err != nil {
-		log.Printf("Не удалось получить список COM-портов: %v", err)
-	} else if len(ports) == 0 {
-		log.Println("В системе не найдено COM-портов.")
-	} else {
-		log.Printf("Найдены COM-порты: %v. Начинаю проверку...", ports)
-		for _, portName := range ports {
-			log.Printf("Проверяю порт %s...", portName)
-			config, err := findOnComPort(portName, comTimeout)
-			if err == nil {
-				foundDevices = append(foundDevices, *config)
-			}
-		}
-	}
-
-	// Этап 2: Параллельный поиск в RNDIS-сетях.
-	log.Println("--- Начинаю поиск устройств в RNDIS-сетях ---")
-	var wg sync.WaitGroup
-	foundChan := make(chan Config)
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		scanRNDISNetworks(tcpTimeout, foundChan)
-	}()
-
-	go func() {
-		wg.Wait()
-		close(foundChan)
-	}()
-
-	for config := range foundChan {
-		foundDevices = append(foundDevices, config)
-	}
-
-	log.Printf("--- Поиск завершен. Всего найдено устройств: %d ---", len(foundDevices))
-	return foundDevices, nil
-}
-
-// findOnComPort проверяет один COM-порт на наличие ККТ, перебирая
-// ограниченный набор скоростей для ускорения процесса.
-func findOnComPort(portName string, timeout time.Duration) (*Config, error) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	comNum, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(portName), "COM"))
-	if err != nil {
-		return nil, fmt.Errorf("некорректное имя порта: %s", portName)
-	}
-
-	// Ограниченный список скоростей для быстрой проверки.
-	baudRates := []int32{115200, 4800}
-	// Индексы скоростей, которые понимает драйвер.
-	baudRateIndexes := map[int32]int32{
-		115200: 6,
-		4800:   1,
-	}
-
-	for _, baud := range baudRates {
-		// Для каждой попытки на каждой скорости требуется полный цикл
-		// инициализации и деинициализации COM.
-		if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
-			if err := ole.CoInitialize(0); err != nil {
-				continue
-			}
-		}
-
-		unknown, err := oleutil.CreateObject("AddIn.DrvFR")
-		if err != nil {
-			ole.CoUninitialize()
-			continue
-		}
-		dispatch, err := unknown.QueryInterface(ole.IID_IDispatch)
-		if err != nil {
-			unknown.Release()
-			ole.CoUninitialize()
-			continue
-		}
-
-		// Настройка параметров для быстрой проверки с таймаутом.
-		oleutil.PutProperty(dispatch, "ConnectionType", 0)
-		oleutil.PutProperty(dispatch, "Password", 30)
-		oleutil.PutProperty(dispatch, "ComNumber", comNum)
-		oleutil.PutProperty(dispatch, "BaudRate", baudRateIndexes[baud])
-		oleutil.PutProperty(dispatch, "Timeout", timeout.Milliseconds())
-
-		// Попытка подключения и проверка кода ошибки драйвера.
-		_, connectErr := oleutil.CallMethod(dispatch, "Connect")
-		tempDriver := &comDriver{dispatch: dispatch}
-		checkErr := tempDriver.checkError()
-
-		// Освобождение ресурсов перед следующей итерацией или выходом.
-		dispatch.Release()
-		unknown.Release()
-		ole.CoUninitialize()
-
-		if connectErr == nil && checkErr == nil {
-			// Успех, устройство найдено.
-			log.Printf("!!! Устройство найдено на порту %s, скорость %d", portName, baud)
-			return &Config{
-				ConnectionType: 0,
-				ComName:        portName,
-				ComNumber:      int32(comNum),
-				BaudRate:       baudRateIndexes[baud],
-				Password:       30,
-			}, nil
-		}
-	}
-	return nil, fmt.Errorf("устройство не найдено на порту %s", portName)
-}
-
-// scanRNDISNetworks запускает параллельное сканирование стандартных подсетей
-// для RNDIS-устройств. Использует пул горутин для ограничения нагрузки.
-func scanRNDISNetworks(timeout time.Duration, foundChan chan<- Config) {
-	var wg sync.WaitGroup
-	ports := []int32{7778} // Стандартный порт для Штрих-М.
-	subnets := []string{"192.168.137.", "192.168.138."}
-
-	// Ограничиваем количество одновременных горутин.
-	const maxGoroutines = 50
-	guard := make(chan struct{}, maxGoroutines)
-
-	for _, subnet := range subnets {
-		for i := 1; i <= 254; i++ {
-			ip := subnet + strconv.Itoa(i)
-			wg.Add(1)
-			guard <- struct{}{} // Занимаем слот в пуле.
-			go func(ip string, port int32) {
-				defer wg.Done()
-				checkIP(ip, port, timeout, foundChan)
-				<-guard // Освобождаем слот.
-			}(ip, ports[0])
-		}
-	}
-	wg.Wait()
-}
-
-// checkIP выполняет двухэтапную проверку одного IP-адреса:
-// 1. Быстрая проверка доступности порта через net.DialTimeout.
-// 2. Полное подключение через драйвер для верификации, что это ККТ.
-func checkIP(ip string, port int32, timeout time.Duration, foundChan chan<- Config) {
-	address := fmt.Sprintf("%s:%d", ip, port)
-	conn, err := net.DialTimeout("tcp", address, timeout)
-	if err != nil {
-		return // Порт закрыт или хост недоступен.
-	}
-	conn.Close()
-
-	log.Printf("Найден открытый порт на %s. Проверяю совместимость...", address)
-	config := Config{
-		ConnectionType: 6,
-		IPAddress:      ip,
-		TCPPort:        port,
-		Password:       30,
-	}
-	driver := New(config)
-	if err := driver.Connect(); err == nil {
-		log.Printf("!!! Найдено и подтверждено устройство по TCP/IP: %s", address)
-		foundChan <- config
-		driver.Disconnect()
-	}
-}
@@ -0,0 +1,232 @@
+// Файл: pkg/shtrih/dump.go
+package shtrih
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DumpFormatVersion - версия формата снимка таблиц. Увеличивается при
+// несовместимых изменениях структуры JSON.
+const DumpFormatVersion = 1
+
+// maxTableNumber - наибольший номер таблицы, который запрашивается при снятии снимка.
+const maxTableNumber = 255
+
+// TableDump - снимок всех таблиц настроек ККТ.
+type TableDump struct {
+	FormatVersion int           `json:"format_version"`
+	SerialNumber  string        `json:"serialNumber"`
+	CreatedAt     string        `json:"created_at"`
+	Tables        []DumpedTable `json:"tables"`
+}
+
+// DumpedTable - таблица в снимке: структура и значения всех полей.
+type DumpedTable struct {
+	TableStruct
+	FieldValues []DumpedField `json:"field_values"`
+}
+
+// DumpedField - поле таблицы в снимке. Values содержит текстовые значения
+// по рядам, начиная с первого.
+type DumpedField struct {
+	FieldStruct
+	Values []string `json:"values"`
+}
+
+// Value возвращает значение поля из снимка.
+func (d *TableDump) Value(table, row, field int) (string, bool) {
+	for _, t := range d.Tables {
+		if t.Number != table {
+			continue
+		}
+		for _, f := range t.FieldValues {
+			if f.Number == field && row >= 1 && row <= len(f.Values) {
+				return f.Values[row-1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// DumpTables обходит все таблицы, ряды и поля подключенной ККТ и возвращает снимок.
+// Неопределенные таблицы пропускаются; ошибка связи прерывает обход.
+// Заводской номер берется из таблицы 18 (ряд 1, поле 1).
+func DumpTables(td TableDriver) (*TableDump, error) {
+	dump := &TableDump{
+		FormatVersion: DumpFormatVersion,
+		CreatedAt:     time.Now().Format("2006-01-02 15:04:05"),
+	}
+	for table := 1; table <= maxTableNumber; table++ {
+		ts, err := td.GetTableStruct(table)
+		if err != nil {
+			if errors.Is(err, CategoryTransport) {
+				return nil, err
+			}
+			continue
+		}
+		dt := DumpedTable{TableStruct: *ts}
+		for field := 1; field <= ts.Fields; field++ {
+			fs, err := td.GetFieldStruct(table, field)
+			if err != nil {
+				if errors.Is(err, CategoryTransport) {
+					return nil, err
+				}
+				log.Printf("Не удалось получить структуру поля %d таблицы %d: %v", field, table, err)
+				continue
+			}
+			df := DumpedField{FieldStruct: *fs, Values: make([]string, ts.Rows)}
+			for row := 1; row <= ts.Rows; row++ {
+				v, err := td.ReadTable(table, row, field)
+				if err != nil {
+					if errors.Is(err, CategoryTransport) {
+						return nil, err
+					}
+					log.Printf("Не удалось прочитать таблицу %d, ряд %d, поле %d: %v", table, row, field, err)
+					continue
+				}
+				df.Values[row-1] = v.String()
+			}
+			dt.FieldValues = append(dt.FieldValues, df)
+		}
+		dump.Tables = append(dump.Tables, dt)
+	}
+	if sn, ok := dump.Value(18, 1, 1); ok {
+		dump.SerialNumber = sn
+	}
+	log.Printf("Снимок таблиц ККТ %s: прочитано таблиц: %d", dump.SerialNumber, len(dump.Tables))
+	return dump, nil
+}
+
+// SaveDump сохраняет снимок в каталог dir в файл вида {ЗН_ККТ}_{дата-время}.json
+// и возвращает путь к созданному файлу.
+func SaveDump(dump *TableDump, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("не удалось создать каталог снимков '%s': %w", dir, err)
+	}
+	serial := dump.SerialNumber
+	if serial == "" {
+		serial = "unknown"
+	}
+	created, err := time.ParseInLocation("2006-01-02 15:04:05", dump.CreatedAt, time.Local)
+	if err != nil {
+		created = time.Now()
+	}
+	filePath := filepath.Join(dir, fmt.Sprintf("%s_%s.json", serial, created.Format("20060102-150405")))
+	data, err := json.MarshalIndent(dump, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("не удалось преобразовать снимок в JSON: %w", err)
+	}
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return "", fmt.Errorf("не удалось записать снимок '%s': %w", filePath, err)
+	}
+	return filePath, nil
+}
+
+// LoadDump читает снимок из файла и проверяет версию формата.
+func LoadDump(filePath string) (*TableDump, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать снимок '%s': %w", filePath, err)
+	}
+	var dump TableDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("не удалось распарсить снимок '%s': %w", filePath, err)
+	}
+	if dump.FormatVersion < 1 || dump.FormatVersion > DumpFormatVersion {
+		return nil, fmt.Errorf("неподдерживаемая версия формата снимка '%s': %d", filePath, dump.FormatVersion)
+	}
+	return &dump, nil
+}
+
+// ChangeKind - вид отличия между двумя снимками.
+type ChangeKind string
+
+const (
+	// ChangeModified - значение поля изменилось.
+	ChangeModified ChangeKind = "changed"
+	// ChangeAdded - поле или ряд есть только в новом снимке.
+	ChangeAdded ChangeKind = "added"
+	// ChangeRemoved - поле или ряд есть только в старом снимке.
+	ChangeRemoved ChangeKind = "removed"
+)
+
+// DumpChange описывает отличие значения одного поля.
+type DumpChange struct {
+	Kind      ChangeKind `json:"kind"`
+	Table     int        `json:"table"`
+	Row       int        `json:"row"`
+	Field     int        `json:"field"`
+	TableName string     `json:"table_name"`
+	FieldName string     `json:"field_name"`
+	Old       string     `json:"old,omitempty"`
+	New       string     `json:"new,omitempty"`
+}
+
+func (c DumpChange) String() string {
+	place := fmt.Sprintf("Т%d.Р%d.П%d %s / %s", c.Table, c.Row, c.Field, c.TableName, c.FieldName)
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s: %q", place, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s: %q", place, c.Old)
+	default:
+		return fmt.Sprintf("* %s: %q -> %q", place, c.Old, c.New)
+	}
+}
+
+// dumpEntry - значение поля снимка вместе с названиями для отчета.
+type dumpEntry struct {
+	tableName, fieldName, value string
+}
+
+// flatten раскладывает снимок в карту {таблица, ряд, поле} -> значение.
+func (d *TableDump) flatten() map[[3]int]dumpEntry {
+	entries := make(map[[3]int]dumpEntry)
+	for _, t := range d.Tables {
+		for _, f := range t.FieldValues {
+			for i, v := range f.Values {
+				entries[[3]int{t.Number, i + 1, f.Number}] = dumpEntry{t.Name, f.Name, v}
+			}
+		}
+	}
+	return entries
+}
+
+// DiffDumps сравнивает два снимка и возвращает отличия, упорядоченные
+// по номеру таблицы, ряда и поля.
+func DiffDumps(oldDump, newDump *TableDump) []DumpChange {
+	oldEntries, newEntries := oldDump.flatten(), newDump.flatten()
+	var changes []DumpChange
+	for key, o := range oldEntries {
+		n, ok := newEntries[key]
+		switch {
+		case !ok:
+			changes = append(changes, DumpChange{Kind: ChangeRemoved, Table: key[0], Row: key[1], Field: key[2], TableName: o.tableName, FieldName: o.fieldName, Old: o.value})
+		case n.value != o.value:
+			changes = append(changes, DumpChange{Kind: ChangeModified, Table: key[0], Row: key[1], Field: key[2], TableName: n.tableName, FieldName: n.fieldName, Old: o.value, New: n.value})
+		}
+	}
+	for key, n := range newEntries {
+		if _, ok := oldEntries[key]; !ok {
+			changes = append(changes, DumpChange{Kind: ChangeAdded, Table: key[0], Row: key[1], Field: key[2], TableName: n.tableName, FieldName: n.fieldName, New: n.value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		if a.Row != b.Row {
+			return a.Row < b.Row
+		}
+		return a.Field < b.Field
+	})
+	return changes
+}
@@ -0,0 +1,79 @@
+// Тесты снимков таблиц ККТ
+package shtrih
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestDumpTables_Emulator проверяет снятие снимка, сохранение и чтение его из файла.
+func TestDumpTables_Emulator(t *testing.T) {
+	_, addr := startTCPEmulator(t)
+	driver := NewNative(Config{ConnectionType: 6, IPAddress: "127.0.0.1", TCPPort: int32(addr.Port), Password: 30}).(TableDriver)
+	if err := driver.Connect(); err != nil {
+		t.Fatalf("Connect() вернул неожиданную ошибку: %v", err)
+	}
+	defer driver.Disconnect()
+
+	dump, err := DumpTables(driver)
+	if err != nil {
+		t.Fatalf("DumpTables() вернул неожиданную ошибку: %v", err)
+	}
+	if dump.SerialNumber != "0012345678901234" {
+		t.Errorf("Заводской номер в снимке = %q", dump.SerialNumber)
+	}
+	var numbers []int
+	for _, table := range dump.Tables {
+		numbers = append(numbers, table.Number)
+	}
+	if !reflect.DeepEqual(numbers, []int{1, 2, 17, 18}) {
+		t.Errorf("В снимок попали таблицы %v, ожидались [1 2 17 18]", numbers)
+	}
+	if v, ok := dump.Value(2, 2, 1); !ok || v != "30" {
+		t.Errorf("Value(2, 2, 1) = %q, %v; ожидалось \"30\"", v, ok)
+	}
+
+	path, err := SaveDump(dump, t.TempDir())
+	if err != nil {
+		t.Fatalf("SaveDump() вернул неожиданную ошибку: %v", err)
+	}
+	loaded, err := LoadDump(path)
+	if err != nil {
+		t.Fatalf("LoadDump() вернул неожиданную ошибку: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, dump) {
+		t.Error("Снимок, прочитанный из файла, отличается от сохраненного.")
+	}
+	if changes := DiffDumps(dump, loaded); len(changes) != 0 {
+		t.Errorf("Одинаковые снимки дали отличия: %v", changes)
+	}
+}
+
+// TestDiffDumps проверяет поиск измененных, добавленных и удаленных значений.
+func TestDiffDumps(t *testing.T) {
+	password := FieldStruct{Table: 2, Number: 1, Name: "Пароль", Size: 4}
+	name := FieldStruct{Table: 2, Number: 2, Name: "Имя", Type: FieldString, Size: 21}
+	oldDump := &TableDump{FormatVersion: DumpFormatVersion, Tables: []DumpedTable{{
+		TableStruct: TableStruct{Number: 2, Name: "Пароли", Rows: 2, Fields: 2},
+		FieldValues: []DumpedField{
+			{FieldStruct: password, Values: []string{"1", "30"}},
+			{FieldStruct: name, Values: []string{"Кассир 1", "Администратор"}},
+		},
+	}}}
+	newDump := &TableDump{FormatVersion: DumpFormatVersion, Tables: []DumpedTable{{
+		TableStruct: TableStruct{Number: 2, Name: "Пароли", Rows: 3, Fields: 1},
+		FieldValues: []DumpedField{
+			{FieldStruct: password, Values: []string{"1", "31", "2"}},
+		},
+	}}}
+
+	expected := []DumpChange{
+		{Kind: ChangeRemoved, Table: 2, Row: 1, Field: 2, TableName: "Пароли", FieldName: "Имя", Old: "Кассир 1"},
+		{Kind: ChangeModified, Table: 2, Row: 2, Field: 1, TableName: "Пароли", FieldName: "Пароль", Old: "30", New: "31"},
+		{Kind: ChangeRemoved, Table: 2, Row: 2, Field: 2, TableName: "Пароли", FieldName: "Имя", Old: "Администратор"},
+		{Kind: ChangeAdded, Table: 2, Row: 3, Field: 1, TableName: "Пароли", FieldName: "Пароль", New: "2"},
+	}
+	if changes := DiffDumps(oldDump, newDump); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("DiffDumps() =\n%v\nожидалось\n%v", changes, expected)
+	}
+}
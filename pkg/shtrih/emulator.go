@@ -0,0 +1,415 @@
+// Файл: pkg/shtrih/emulator.go
+package shtrih
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shtrih-kkt/pkg/shtrih/protocol"
+)
+
+// Коды ошибок, которые возвращает эмулятор.
+const (
+	emuErrBadParams      byte = 0x33 // Некорректные параметры в команде
+	emuErrUnsupported    byte = 0x37 // Команда не поддерживается в данной реализации ККТ
+	emuErrWrongPassword  byte = 0x4F // Неверный пароль
+	emuErrTableUndefined byte = 0x5D // Таблица не определена
+)
+
+// EmulatorState описывает состояние эмулируемой ККТ. Поля FiscalInfo встроены,
+// поэтому состояние загружается из того же JSON, что и эталонные данные ККТ
+// (testdata/canonical_kkt_data.json), дополненного служебными полями эмулятора.
+type EmulatorState struct {
+	FiscalInfo
+	// Password - системный пароль администратора (по умолчанию 30).
+	Password int32 `json:"password,omitempty"`
+	// ECRMode - режим ККТ, возвращаемый командой 0x11.
+	ECRMode byte `json:"ecr_mode,omitempty"`
+	// WorkModeEx - расширенные признаки режима работы из итогов фискализации.
+	WorkModeEx byte `json:"work_mode_ex,omitempty"`
+	// LicenseHex - лицензии в виде HEX-строки, как их возвращает ReadFeatureLicenses.
+	LicenseHex string `json:"license_hex,omitempty"`
+	// Tables - дополнительные таблицы и поля. Таблицы 17 и 18 строятся
+	// из FiscalInfo автоматически, поля из фикстуры имеют приоритет.
+	Tables []EmulatorTable `json:"tables,omitempty"`
+}
+
+// EmulatorTable описывает таблицу ККТ в фикстуре эмулятора.
+type EmulatorTable struct {
+	Number int             `json:"number"`
+	Name   string          `json:"name"`
+	Rows   int             `json:"rows"`
+	Fields []EmulatorField `json:"fields"`
+}
+
+// EmulatorField описывает поле таблицы. Values содержит значения по строкам,
+// начиная с первой: строки для типа "string", числа для типа "number".
+type EmulatorField struct {
+	Number int           `json:"number"`
+	Name   string        `json:"name"`
+	Type   string        `json:"type"`
+	Size   int           `json:"size"`
+	Min    int64         `json:"min,omitempty"`
+	Max    int64         `json:"max,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+}
+
+// LoadEmulatorState читает состояние эмулятора из JSON-фикстуры.
+func LoadEmulatorState(filePath string) (*EmulatorState, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать фикстуру эмулятора '%s': %w", filePath, err)
+	}
+	var state EmulatorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("не удалось распарсить фикстуру эмулятора '%s': %w", filePath, err)
+	}
+	return &state, nil
+}
+
+// Emulator отвечает на кадры протокола "Штрих-М" от имени ККТ. Используется
+// в тестах и демонстрациях вместо реального устройства: через TCP-сокет
+// или псевдотерминал (см. ServePTY).
+type Emulator struct {
+	mu     sync.Mutex
+	state  *EmulatorState
+	tables map[int]*EmulatorTable
+	// errors задает коды ошибок, которые будут возвращены на указанные команды.
+	errors map[uint16]byte
+}
+
+// NewEmulator создает эмулятор с указанным состоянием.
+func NewEmulator(state *EmulatorState) *Emulator {
+	if state.Password == 0 {
+		state.Password = 30
+	}
+	e := &Emulator{state: state, errors: make(map[uint16]byte)}
+	e.tables = buildEmulatorTables(state)
+	return e
+}
+
+// SetError заставляет эмулятор отвечать на команду cmd кодом ошибки code.
+// Нулевой код отменяет имитацию ошибки.
+func (e *Emulator) SetError(cmd uint16, code byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if code == 0 {
+		delete(e.errors, cmd)
+		return
+	}
+	e.errors[cmd] = code
+}
+
+// ListenTCP начинает принимать соединения на адресе addr (например, "127.0.0.1:0")
+// и обслуживает каждое в отдельной горутине. Для остановки закройте Listener.
+func (e *Emulator) ListenTCP(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Эмулятор ККТ %s слушает %s", e.state.SerialNumber, ln.Addr())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				e.Serve(conn)
+			}()
+		}
+	}()
+	return ln, nil
+}
+
+// Serve обслуживает один канал связи до его закрытия.
+func (e *Emulator) Serve(rw io.ReadWriter) error {
+	return protocol.Serve(rw, e.handle)
+}
+
+// handle выполняет команду под блокировкой состояния эмулятора.
+func (e *Emulator) handle(cmd uint16, args []byte) (byte, []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	data, code := e.execute(cmd, args)
+	return code, data
+}
+
+// execute разбирает аргументы команды, проверяет пароль и формирует данные ответа.
+func (e *Emulator) execute(cmd uint16, args []byte) ([]byte, byte) {
+	if code, ok := e.errors[cmd]; ok {
+		return nil, code
+	}
+	// Все команды, кроме 0xFC, начинаются с пароля.
+	if cmd != cmdGetDeviceMetrics {
+		if len(args) < 4 {
+			return nil, emuErrBadParams
+		}
+		if int32(binary.LittleEndian.Uint32(args)) != e.state.Password {
+			return nil, emuErrWrongPassword
+		}
+		args = args[4:]
+	}
+
+	s := e.state
+	switch cmd {
+	case cmdGetDeviceMetrics:
+		return append([]byte{0, 0, 1, 6, 0, 0}, encodeCP1251(s.ModelName)...), 0
+	case cmdGetECRStatus:
+		return e.ecrStatus(), 0
+	case cmdFNGetSerial:
+		return padded(s.FnSerial, 16, ' '), 0
+	case cmdFNGetExpirationTime:
+		t, _ := time.ParseInLocation("2006-01-02 15:04:05", s.FnEndDate, time.Local)
+		return []byte{byte(t.Year() % 100), byte(t.Month()), byte(t.Day())}, 0
+	case cmdFNGetFiscalizationTotal:
+		return e.fiscalizationTotal(), 0
+	case cmdFNGetImplementation:
+		return encodeCP1251(s.FnExecution), 0
+	case cmdFNGetStatus:
+		if s.FnStatus == nil {
+			return nil, emuErrUnsupported
+		}
+		return encodeFnStatus(s.FnStatus, s.FnSerial), 0
+	case cmdFNGetOfdStatus:
+		if s.OfdStatus == nil {
+			return nil, emuErrUnsupported
+		}
+		return encodeOfdStatus(s.OfdStatus), 0
+	case cmdFNGetMemoryResource:
+		if s.FnMemory == nil {
+			return nil, emuErrUnsupported
+		}
+		return append(leBytes(uint64(s.FnMemory.Archive5Years), 4), leBytes(uint64(s.FnMemory.Storage30Days), 4)...), 0
+	case cmdReadFeatureLicenses:
+		if s.LicenseHex == "" {
+			return nil, emuErrUnsupported
+		}
+		license, err := hex.DecodeString(s.LicenseHex)
+		if err != nil {
+			return nil, emuErrUnsupported
+		}
+		return license, 0
+	case cmdGetTableStruct:
+		if len(args) < 1 {
+			return nil, emuErrBadParams
+		}
+		t, ok := e.tables[int(args[0])]
+		if !ok {
+			return nil, emuErrTableUndefined
+		}
+		resp := padded(t.Name, 40, 0)
+		resp = append(resp, byte(t.Rows), byte(t.Rows>>8), byte(maxFieldNumber(t)))
+		return resp, 0
+	case cmdGetFieldStruct:
+		if len(args) < 2 {
+			return nil, emuErrBadParams
+		}
+		f, code := e.field(int(args[0]), int(args[1]))
+		if code != 0 {
+			return nil, code
+		}
+		resp := padded(f.Name, 40, 0)
+		if f.Type == "string" {
+			return append(resp, 1, byte(f.Size)), 0
+		}
+		resp = append(resp, 0, byte(f.Size))
+		resp = append(resp, leBytes(uint64(f.Min), f.Size)...)
+		return append(resp, leBytes(uint64(f.Max), f.Size)...), 0
+	case cmdReadTable:
+		if len(args) < 4 {
+			return nil, emuErrBadParams
+		}
+		row := int(binary.LittleEndian.Uint16(args[1:3]))
+		f, code := e.field(int(args[0]), int(args[3]))
+		if code != 0 {
+			return nil, code
+		}
+		if row < 1 || row > e.tables[int(args[0])].Rows {
+			return nil, emuErrBadParams
+		}
+		return encodeFieldValue(f, row), 0
+	default:
+		return nil, emuErrUnsupported
+	}
+}
+
+// ecrStatus формирует ответ на команду 0x11 (46 байт после кода ошибки).
+func (e *Emulator) ecrStatus() []byte {
+	resp := make([]byte, 46)
+	resp[0] = 30 // Порядковый номер оператора
+	if t, err := time.Parse("2006-01-02", e.state.SoftwareDate); err == nil {
+		resp[5], resp[6], resp[7] = byte(t.Day()), byte(t.Month()), byte(t.Year()%100)
+	}
+	resp[13] = e.state.ECRMode
+	now := time.Now()
+	resp[23], resp[24], resp[25] = byte(now.Day()), byte(now.Month()), byte(now.Year()%100)
+	resp[26], resp[27], resp[28] = byte(now.Hour()), byte(now.Minute()), byte(now.Second())
+	if sn, err := strconv.ParseUint(e.state.SerialNumber, 10, 64); err == nil {
+		binary.LittleEndian.PutUint32(resp[30:34], uint32(sn))
+	}
+	return resp
+}
+
+// fiscalizationTotal формирует ответ на запрос итогов фискализации.
+func (e *Emulator) fiscalizationTotal() []byte {
+	s := e.state
+	resp := make([]byte, 0, 49)
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s.RegistrationDate, time.Local); err == nil {
+		resp = append(resp, byte(t.Year()%100), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute()))
+	} else {
+		resp = append(resp, 0, 0, 0, 0, 0)
+	}
+	resp = append(resp, padded(s.Inn, 12, ' ')...)
+	resp = append(resp, padded(s.RNM, 20, ' ')...)
+	resp = append(resp, 0x01, 0x00)      // Система налогообложения, режим работы
+	resp = append(resp, 1, 0, 0, 0)      // Номер ФД
+	resp = append(resp, 0, 0, 0, 0)      // Фискальный признак
+	return append(resp, 0, s.WorkModeEx) // Причина перерегистрации, расширенный режим
+}
+
+// field находит описание поля таблицы или возвращает код ошибки.
+func (e *Emulator) field(tableNum, fieldNum int) (*EmulatorField, byte) {
+	t, ok := e.tables[tableNum]
+	if !ok {
+		return nil, emuErrTableUndefined
+	}
+	for i := range t.Fields {
+		if t.Fields[i].Number == fieldNum {
+			return &t.Fields[i], 0
+		}
+	}
+	return nil, emuErrBadParams
+}
+
+// encodeFieldValue кодирует значение поля для строки row так, как его передает ККТ.
+func encodeFieldValue(f *EmulatorField, row int) []byte {
+	var value interface{}
+	if row-1 < len(f.Values) {
+		value = f.Values[row-1]
+	}
+	if f.Type == "string" {
+		s, _ := value.(string)
+		return padded(s, f.Size, 0)
+	}
+	var n int64
+	switch v := value.(type) {
+	case float64:
+		n = int64(v)
+	case int:
+		n = int64(v)
+	case int64:
+		n = v
+	case string:
+		n, _ = strconv.ParseInt(v, 10, 64)
+	}
+	return leBytes(uint64(n), f.Size)
+}
+
+// buildEmulatorTables строит таблицы 17 и 18 из FiscalInfo и накладывает
+// поверх них таблицы из фикстуры.
+func buildEmulatorTables(s *EmulatorState) map[int]*EmulatorTable {
+	ffdCode := 0
+	switch s.FfdVersion {
+	case "105":
+		ffdCode = 2
+	case "120":
+		ffdCode = 4
+	}
+	tables := map[int]*EmulatorTable{
+		17: {Number: 17, Name: "Региональные настройки", Rows: 1},
+		18: {Number: 18, Name: "Fiscal storage", Rows: 1},
+	}
+	for i := 1; i <= 17; i++ {
+		f := EmulatorField{Number: i, Name: fmt.Sprintf("Параметр %d", i), Type: "number", Size: 1, Max: 255, Values: []interface{}{0}}
+		if i == 17 {
+			f.Name, f.Values = "Формат ФД", []interface{}{ffdCode}
+		}
+		tables[17].Fields = append(tables[17].Fields, f)
+	}
+	known := map[int]EmulatorField{
+		1:  {Name: "Заводской номер ККТ", Values: []interface{}{s.SerialNumber}},
+		7:  {Name: "Пользователь", Values: []interface{}{s.OrganizationName}},
+		9:  {Name: "Адрес расчетов", Values: []interface{}{s.Address}},
+		10: {Name: "Наименование ОФД", Values: []interface{}{s.OfdName}},
+	}
+	for i := 1; i <= 10; i++ {
+		f, ok := known[i]
+		if !ok {
+			f = EmulatorField{Name: fmt.Sprintf("Параметр %d", i)}
+		}
+		f.Number, f.Type, f.Size = i, "string", 64
+		tables[18].Fields = append(tables[18].Fields, f)
+	}
+
+	for _, t := range s.Tables {
+		existing, ok := tables[t.Number]
+		if !ok {
+			t := t
+			tables[t.Number] = &t
+			continue
+		}
+		if t.Name != "" {
+			existing.Name = t.Name
+		}
+		if t.Rows > existing.Rows {
+			existing.Rows = t.Rows
+		}
+		for _, f := range t.Fields {
+			replaced := false
+			for i := range existing.Fields {
+				if existing.Fields[i].Number == f.Number {
+					existing.Fields[i], replaced = f, true
+				}
+			}
+			if !replaced {
+				existing.Fields = append(existing.Fields, f)
+			}
+		}
+	}
+	return tables
+}
+
+// padded кодирует строку в Windows-1251 и дополняет ее до size байт символом pad.
+func padded(s string, size int, pad byte) []byte {
+	b := encodeCP1251(strings.TrimSpace(s))
+	if len(b) > size {
+		return b[:size]
+	}
+	for len(b) < size {
+		b = append(b, pad)
+	}
+	return b
+}
+
+// leBytes кодирует число в порядке little-endian длиной size байт.
+func leBytes(v uint64, size int) []byte {
+	b := make([]byte, size)
+	for i := 0; i < size && i < 8; i++ {
+		b[i] = byte(v >> (8 * i))
+	}
+	return b
+}
+
+// maxFieldNumber возвращает наибольший номер поля таблицы, который
+// сообщается как количество полей в ответе на запрос структуры таблицы.
+func maxFieldNumber(t *EmulatorTable) int {
+	maxNum := 0
+	for _, f := range t.Fields {
+		if f.Number > maxNum {
+			maxNum = f.Number
+		}
+	}
+	return maxNum
+}
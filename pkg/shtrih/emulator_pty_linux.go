@@ -0,0 +1,32 @@
+// Файл: pkg/shtrih/emulator_pty_linux.go
+package shtrih
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ServePTY создает пару псевдотерминалов и обслуживает ведущую сторону.
+// Возвращает имя ведомого устройства (например, /dev/pts/3), которое можно
+// открыть как обычный последовательный порт, и Closer для остановки эмулятора.
+func (e *Emulator) ServePTY() (string, io.Closer, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("не удалось открыть /dev/ptmx: %w", err)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		return "", nil, fmt.Errorf("не удалось разблокировать псевдотерминал: %w", errno)
+	}
+	var ptyNum uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&ptyNum))); errno != 0 {
+		master.Close()
+		return "", nil, fmt.Errorf("не удалось получить номер псевдотерминала: %w", errno)
+	}
+	go e.Serve(master)
+	return fmt.Sprintf("/dev/pts/%d", ptyNum), master, nil
+}
@@ -0,0 +1,142 @@
+// Сквозные тесты поиска и опроса через псевдотерминал
+package shtrih
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startPTYEmulator запускает эмулятор на псевдотерминале и возвращает имя порта.
+func startPTYEmulator(t *testing.T) string {
+	t.Helper()
+	state, err := LoadEmulatorState("testdata/canonical_kkt_data.json")
+	if err != nil {
+		t.Fatalf("Подготовка теста провалилась: %v", err)
+	}
+	portName, closer, err := NewEmulator(state).ServePTY()
+	if err != nil {
+		t.Skipf("Псевдотерминалы недоступны: %v", err)
+	}
+	t.Cleanup(func() { closer.Close() })
+	return portName
+}
+
+// TestEmulator_NativeDriverSerial проверяет опрос через последовательный порт.
+func TestEmulator_NativeDriverSerial(t *testing.T) {
+	portName := startPTYEmulator(t)
+
+	driver := NewNative(Config{ConnectionType: 0, ComName: portName, BaudRate: 6, Password: 30})
+	if err := driver.Connect(); err != nil {
+		t.Fatalf("Connect() вернул неожиданную ошибку: %v", err)
+	}
+	defer driver.Disconnect()
+
+	info, err := driver.GetFiscalInfo()
+	if err != nil {
+		t.Fatalf("GetFiscalInfo() вернул неожиданную ошибку: %v", err)
+	}
+	if info.FnSerial != "9960440300112233" || info.OrganizationName != "ООО Ромашка" {
+		t.Errorf("Данные прочитаны неверно: %+v", info)
+	}
+}
+
+// TestSearchDevices_Emulator проверяет оба этапа поиска: последовательный порт
+// (псевдотерминал) и TCP (эмулятор на 127.0.0.1).
+func TestSearchDevices_Emulator(t *testing.T) {
+	portName := startPTYEmulator(t)
+	_, addr := startTCPEmulator(t)
+
+	origList, origIfaces, origPort, origCOM := listPorts, listInterfaces, rndisPort, comProbeAvailable
+	defer func() {
+		listPorts, listInterfaces, rndisPort, comProbeAvailable = origList, origIfaces, origPort, origCOM
+	}()
+	listPorts = namedPorts(portName)
+	listInterfaces = loopbackAsUSB
+	rndisPort = int32(addr.Port)
+	comProbeAvailable = false
+
+	configs, err := SearchDevices(200*time.Millisecond, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("SearchDevices() вернул неожиданную ошибку: %v", err)
+	}
+
+	var foundSerial, foundTCP bool
+	for _, c := range configs {
+		switch {
+		case c.ConnectionType == 0 && c.ComName == portName:
+			foundSerial = true
+		case c.ConnectionType == 6 && net.ParseIP(c.IPAddress).Equal(net.ParseIP("127.0.0.1")):
+			foundTCP = true
+		}
+	}
+	if !foundSerial || !foundTCP {
+		t.Errorf("Найдены не все устройства эмулятора: %+v", configs)
+	}
+}
+
+// TestSearchDevicesWithOptions_Parallel проверяет параллельную проверку COM-портов
+// и досрочное завершение поиска, когда найдено ожидаемое число устройств.
+func TestSearchDevicesWithOptions_Parallel(t *testing.T) {
+	origList, origIfaces, origPort, origCOM := listPorts, listInterfaces, rndisPort, comProbeAvailable
+	defer func() {
+		listPorts, listInterfaces, rndisPort, comProbeAvailable = origList, origIfaces, origPort, origCOM
+	}()
+	comProbeAvailable = false
+
+	// setup запускает три эмулятора на псевдотерминалах и один по TCP.
+	setup := func(t *testing.T) []string {
+		ports := []string{startPTYEmulator(t), startPTYEmulator(t), startPTYEmulator(t)}
+		_, addr := startTCPEmulator(t)
+		listPorts = namedPorts(ports...)
+		listInterfaces = loopbackAsUSB
+		rndisPort = int32(addr.Port)
+		return ports
+	}
+	opts := DefaultSearchOptions()
+	opts.ComParallelism = 3
+
+	t.Run("all ports in order", func(t *testing.T) {
+		ports := setup(t)
+		configs, err := SearchDevicesWithOptions(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("SearchDevicesWithOptions() вернул неожиданную ошибку: %v", err)
+		}
+		if len(configs) < len(ports) {
+			t.Fatalf("Найдены не все устройства: %+v", configs)
+		}
+		for i, name := range ports {
+			if configs[i].ComName != name {
+				t.Errorf("Порядок результатов не совпадает с порядком портов: %d - %q, ожидался %q", i, configs[i].ComName, name)
+			}
+		}
+	})
+
+	t.Run("stops after expected devices", func(t *testing.T) {
+		setup(t)
+		opts.ExpectedDevices = 2
+		configs, err := SearchDevicesWithOptions(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("SearchDevicesWithOptions() вернул неожиданную ошибку: %v", err)
+		}
+		if len(configs) != 2 {
+			t.Fatalf("Ожидалось 2 устройства, получено: %+v", configs)
+		}
+		for _, c := range configs {
+			if c.ConnectionType != 0 {
+				t.Errorf("Поиск в сети должен быть пропущен, найдено: %+v", c)
+			}
+		}
+	})
+}
+
+// loopbackAsUSB имитирует хост с единственным USB-интерфейсом в подсети 127.0.0.0/24,
+// где работает TCP-эмулятор.
+func loopbackAsUSB() ([]hostInterface, error) {
+	return []hostInterface{{
+		Name:  "usb0",
+		Flags: net.FlagUp,
+		Nets:  []*net.IPNet{{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(24, 32)}},
+	}}, nil
+}
@@ -0,0 +1,220 @@
+// Сквозные тесты нативного драйвера на эмуляторе ККТ
+package shtrih
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// startTCPEmulator запускает эмулятор с эталонной фикстурой на случайном порту.
+func startTCPEmulator(t *testing.T) (*Emulator, *net.TCPAddr) {
+	t.Helper()
+	state, err := LoadEmulatorState("testdata/canonical_kkt_data.json")
+	if err != nil {
+		t.Fatalf("Подготовка теста провалилась: %v", err)
+	}
+	emu := NewEmulator(state)
+	ln, err := emu.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Не удалось запустить эмулятор: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return emu, ln.Addr().(*net.TCPAddr)
+}
+
+// TestEmulator_NativeDriverTCP проверяет, что нативный драйвер по TCP собирает
+// с эмулятора в точности эталонные данные.
+func TestEmulator_NativeDriverTCP(t *testing.T) {
+	_, addr := startTCPEmulator(t)
+	expected, err := loadMockDataFromFile("testdata/canonical_kkt_data.json")
+	if err != nil {
+		t.Fatalf("Подготовка теста провалилась: %v", err)
+	}
+
+	driver := NewNative(Config{ConnectionType: 6, IPAddress: "127.0.0.1", TCPPort: int32(addr.Port), Password: 30})
+	if err := driver.Connect(); err != nil {
+		t.Fatalf("Connect() вернул неожиданную ошибку: %v", err)
+	}
+	defer driver.Disconnect()
+
+	info, err := driver.GetFiscalInfo()
+	if err != nil {
+		t.Fatalf("GetFiscalInfo() вернул неожиданную ошибку: %v", err)
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("Данные с эмулятора не совпадают с эталоном.\nПолучено: %+v\nОжидалось: %+v", info, expected)
+	}
+}
+
+// TestEmulator_WrongPassword проверяет, что подключение с неверным паролем отклоняется.
+func TestEmulator_WrongPassword(t *testing.T) {
+	_, addr := startTCPEmulator(t)
+
+	driver := NewNative(Config{ConnectionType: 6, IPAddress: "127.0.0.1", TCPPort: int32(addr.Port), Password: 1})
+	err := driver.Connect()
+	if err == nil {
+		driver.Disconnect()
+		t.Fatal("Connect() не вернул ошибку при неверном пароле.")
+	}
+	if !errors.Is(err, CategoryPassword) {
+		t.Errorf("Ожидалась ошибка категории %q, получено: %v", CategoryPassword, err)
+	}
+}
+
+// TestEmulator_LicenseUnsupported проверяет, что отсутствие команды чтения
+// лицензий не мешает сбору остальных данных.
+func TestEmulator_LicenseUnsupported(t *testing.T) {
+	emu, addr := startTCPEmulator(t)
+	emu.SetError(cmdReadFeatureLicenses, emuErrUnsupported)
+
+	driver := NewNative(Config{ConnectionType: 6, IPAddress: "127.0.0.1", TCPPort: int32(addr.Port), Password: 30})
+	if err := driver.Connect(); err != nil {
+		t.Fatalf("Connect() вернул неожиданную ошибку: %v", err)
+	}
+	defer driver.Disconnect()
+
+	info, err := driver.GetFiscalInfo()
+	if err != nil {
+		t.Fatalf("GetFiscalInfo() вернул неожиданную ошибку: %v", err)
+	}
+	if info.SubscriptionInfo != "" {
+		t.Errorf("Ожидалась пустая информация о лицензиях, получено %q", info.SubscriptionInfo)
+	}
+	if info.SerialNumber != "0012345678901234" {
+		t.Errorf("Заводской номер прочитан неверно: %q", info.SerialNumber)
+	}
+}
+
+// TestCheckIP_Emulator проверяет подтверждение ККТ на открытом TCP-порту.
+func TestCheckIP_Emulator(t *testing.T) {
+	_, addr := startTCPEmulator(t)
+
+	opts := DefaultSearchOptions()
+	opts.TCPPort, opts.TCPTimeout = int32(addr.Port), time.Second
+	foundChan := make(chan probeResult, 1)
+	checkIP(context.Background(), "127.0.0.1", opts, foundChan)
+
+	select {
+	case result := <-foundChan:
+		config := result.config
+		if result.denied || config.ConnectionType != 6 || config.IPAddress != "127.0.0.1" || config.TCPPort != int32(addr.Port) {
+			t.Errorf("checkIP() вернул неверную конфигурацию: %+v", result)
+		}
+	default:
+		t.Fatal("checkIP() не подтвердил устройство на эмуляторе.")
+	}
+}
+
+// TestCheckIP_Passwords проверяет перебор паролей и отдельный результат
+// для ККТ, которая отклонила все пароли.
+func TestCheckIP_Passwords(t *testing.T) {
+	emu, addr := startTCPEmulator(t)
+	emu.state.Password = 15
+
+	cases := []struct {
+		name         string
+		passwords    []int32
+		wantDenied   bool
+		wantPassword int32
+	}{
+		{"пароль из списка принят", []int32{29, 15}, false, 15},
+		{"все пароли отклонены", []int32{29}, true, 30},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultSearchOptions()
+			opts.TCPPort, opts.TCPTimeout, opts.Passwords = int32(addr.Port), time.Second, tc.passwords
+			foundChan := make(chan probeResult, 1)
+
+			checkIP(context.Background(), "127.0.0.1", opts, foundChan)
+
+			select {
+			case result := <-foundChan:
+				if result.denied != tc.wantDenied || result.config.Password != tc.wantPassword {
+					t.Errorf("checkIP() = %+v, ожидалось denied=%v, пароль %d", result, tc.wantDenied, tc.wantPassword)
+				}
+			default:
+				t.Fatal("checkIP() не сообщил об устройстве на эмуляторе.")
+			}
+		})
+	}
+}
+
+// TestSearchDevicesWithOptions_AuthError проверяет, что ККТ, отклонившая пароль,
+// не считается найденной и возвращается в ошибке SearchAuthError.
+func TestSearchDevicesWithOptions_AuthError(t *testing.T) {
+	// Arrange
+	origList := listPorts
+	defer func() { listPorts = origList }()
+	listPorts = namedPorts()
+	emu, addr := startTCPEmulator(t)
+	emu.state.Password = 15
+	opts := DefaultSearchOptions()
+	opts.Subnets, opts.TCPPort, opts.TCPTimeout = []string{"127.0.0.0/30"}, int32(addr.Port), time.Second
+
+	// Act
+	configs, err := SearchDevicesWithOptions(context.Background(), opts)
+
+	// Assert
+	if len(configs) != 0 {
+		t.Errorf("ККТ с другим паролем не должна считаться найденной: %+v", configs)
+	}
+	var authErr *SearchAuthError
+	if !errors.As(err, &authErr) || !errors.Is(err, CategoryPassword) {
+		t.Fatalf("Ожидалась ошибка SearchAuthError, получено: %v", err)
+	}
+	if len(authErr.Devices) != 1 || authErr.Devices[0].IPAddress != "127.0.0.1" {
+		t.Errorf("В ошибке ожидалась ККТ 127.0.0.1, получено: %+v", authErr.Devices)
+	}
+}
+
+// TestEmulator_TableAPI проверяет чтение структуры и значений таблиц через TableDriver.
+func TestEmulator_TableAPI(t *testing.T) {
+	_, addr := startTCPEmulator(t)
+
+	driver := NewNative(Config{ConnectionType: 6, IPAddress: "127.0.0.1", TCPPort: int32(addr.Port), Password: 30}).(TableDriver)
+	if err := driver.Connect(); err != nil {
+		t.Fatalf("Connect() вернул неожиданную ошибку: %v", err)
+	}
+	defer driver.Disconnect()
+
+	ts, err := driver.GetTableStruct(2)
+	if err != nil {
+		t.Fatalf("GetTableStruct() вернул неожиданную ошибку: %v", err)
+	}
+	if expected := (TableStruct{Number: 2, Name: "Пароли кассиров и администраторов", Rows: 2, Fields: 2}); *ts != expected {
+		t.Errorf("GetTableStruct(2) = %+v, ожидается %+v", *ts, expected)
+	}
+
+	fs, err := driver.GetFieldStruct(2, 1)
+	if err != nil {
+		t.Fatalf("GetFieldStruct() вернул неожиданную ошибку: %v", err)
+	}
+	if expected := (FieldStruct{Table: 2, Number: 1, Name: "Пароль", Type: FieldNumber, Size: 4, Min: 0, Max: 99999999}); *fs != expected {
+		t.Errorf("GetFieldStruct(2, 1) = %+v, ожидается %+v", *fs, expected)
+	}
+
+	number, err := driver.ReadTable(2, 2, 1)
+	if err != nil {
+		t.Fatalf("ReadTable() вернул неожиданную ошибку: %v", err)
+	}
+	if number.IsString() || number.Int != 30 {
+		t.Errorf("ReadTable(2, 2, 1) = %+v, ожидалось число 30", number)
+	}
+
+	str, err := driver.ReadTable(2, 1, 2)
+	if err != nil {
+		t.Fatalf("ReadTable() вернул неожиданную ошибку: %v", err)
+	}
+	if !str.IsString() || str.String() != "Кассир 1" {
+		t.Errorf("ReadTable(2, 1, 2) = %+v, ожидалась строка \"Кассир 1\"", str)
+	}
+
+	if _, err := driver.GetTableStruct(99); !errors.Is(err, CategoryUnsupported) {
+		t.Errorf("Для несуществующей таблицы ожидалась ошибка категории %q, получено: %v", CategoryUnsupported, err)
+	}
+}
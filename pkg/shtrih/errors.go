@@ -0,0 +1,342 @@
+// Файл: pkg/shtrih/errors.go
+package shtrih
+
+import (
+	"errors"
+	"fmt"
+
+	"shtrih-kkt/pkg/shtrih/protocol"
+)
+
+// ErrorCategory классифицирует ошибки ККТ по тому, как на них следует реагировать.
+// Категория сама реализует error, поэтому ее можно использовать как цель
+// errors.Is: errors.Is(err, shtrih.CategoryPassword).
+type ErrorCategory string
+
+// Категории ошибок драйвера.
+const (
+	// CategoryTransport - нет связи с ККТ, порт занят или недоступен.
+	CategoryTransport ErrorCategory = "transport"
+	// CategoryDeviceState - ККТ в состоянии, не допускающем операцию (открыт чек, смена > 24 ч и т.п.).
+	CategoryDeviceState ErrorCategory = "device_state"
+	// CategoryFN - ошибки фискального накопителя.
+	CategoryFN ErrorCategory = "fn"
+	// CategoryOFD - ошибки обмена с ОФД.
+	CategoryOFD ErrorCategory = "ofd"
+	// CategoryPassword - неверный пароль оператора или администратора.
+	CategoryPassword ErrorCategory = "password"
+	// CategoryUnsupported - команда, таблица или параметр не поддерживаются моделью ККТ.
+	CategoryUnsupported ErrorCategory = "unsupported"
+	// CategoryDevice - прочие ошибки ККТ (параметры команды, оборудование, ПО).
+	CategoryDevice ErrorCategory = "device"
+)
+
+func (c ErrorCategory) Error() string {
+	return string(c)
+}
+
+// DeviceError описывает ошибку, возвращенную ККТ или COM-драйвером.
+// Положительные коды приходят от ККТ, отрицательные - ошибки связи драйвера.
+type DeviceError struct {
+	Code        int32         // Код ошибки (ResultCode драйвера или байт ошибки протокола)
+	Description string        // Текстовое описание
+	Category    ErrorCategory // Категория ошибки
+	Retryable   bool          // Повтор операции может завершиться успешно
+	Command     uint16        // Команда протокола, если известна
+	Step        string        // Шаг операции, прерванный по контексту
+	Err         error         // Исходная ошибка транспорта, если есть
+}
+
+func (e *DeviceError) Error() string {
+	msg := fmt.Sprintf("ошибка ККТ: [%d] %s", e.Code, e.Description)
+	if e.Command != 0 {
+		msg += fmt.Sprintf(" (команда 0x%X)", e.Command)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// Is сопоставляет ошибку с категорией или с *DeviceError с тем же кодом.
+func (e *DeviceError) Is(target error) bool {
+	switch t := target.(type) {
+	case ErrorCategory:
+		return e.Category == t
+	case *DeviceError:
+		return t != nil && e.Code == t.Code
+	}
+	return false
+}
+
+// errorInfo - запись каталога кодов ошибок.
+type errorInfo struct {
+	description string
+	category    ErrorCategory
+	retryable   bool
+}
+
+// Коды ошибок связи COM-драйвера (ResultCode < 0), используемые также нативным драйвером.
+const (
+	codeNoConnection    int32 = -1
+	codePortUnavailable int32 = -2
+	codePortBusy        int32 = -3
+	codeExchangeTimeout int32 = -10
+	codeServerConnect   int32 = -12
+)
+
+// errorCatalog содержит коды ошибок ККТ "Штрих-М" и ФН по документации на протокол.
+var errorCatalog = map[int32]errorInfo{
+	// Ошибки COM-драйвера.
+	-1:  {"Нет связи", CategoryTransport, true},
+	-2:  {"Порт недоступен", CategoryTransport, true},
+	-3:  {"COM-порт занят другим приложением", CategoryTransport, true},
+	-4:  {"Некорректный дескриптор порта", CategoryTransport, false},
+	-5:  {"Недопустимый параметр порта", CategoryTransport, false},
+	-6:  {"Ошибка установки параметров порта", CategoryTransport, false},
+	-7:  {"Неверная скорость обмена", CategoryTransport, false},
+	-8:  {"Ошибка обмена: неверная контрольная сумма", CategoryTransport, true},
+	-9:  {"Ошибка обмена: неверный ответ устройства", CategoryTransport, true},
+	-10: {"Ошибка обмена: превышен таймаут", CategoryTransport, true},
+	-11: {"Ошибка создания сокета", CategoryTransport, true},
+	-12: {"Ошибка подключения к серверу ККТ", CategoryTransport, true},
+	-13: {"Сервер ККТ недоступен", CategoryTransport, true},
+
+	// Ошибки ФН (0x01-0x30). Коды без отдельного описания в протоколе
+	// относятся к ФН и передают ошибку, полученную ККТ от накопителя.
+	0x01: {"ФН: неизвестная команда, неверный формат посылки или неизвестные параметры", CategoryUnsupported, false},
+	0x02: {"Неверное состояние ФН", CategoryFN, false},
+	0x03: {"Ошибка ФН", CategoryFN, false},
+	0x04: {"Ошибка КС", CategoryFN, false},
+	0x05: {"Закончен срок эксплуатации ФН", CategoryFN, false},
+	0x06: {"Архив ФН переполнен", CategoryFN, false},
+	0x07: {"Неверные дата и/или время", CategoryDeviceState, false},
+	0x08: {"Нет запрошенных данных", CategoryFN, false},
+	0x09: {"Некорректное значение параметров команды", CategoryDevice, false},
+	0x0A: {"ФН: некорректная команда", CategoryUnsupported, false},
+	0x0B: {"ФН: неразрешенные реквизиты", CategoryFN, false},
+	0x0C: {"ФН: дублирование данных", CategoryFN, false},
+	0x0D: {"ФН: отсутствуют данные, необходимые для корректного учета", CategoryFN, false},
+	0x0E: {"ФН: количество позиций в документе превысило допустимый предел", CategoryFN, false},
+	0x0F: {"Ошибка ФН", CategoryFN, false},
+	0x10: {"Превышение размеров TLV данных", CategoryDevice, false},
+	0x11: {"Нет транспортного соединения с ОФД", CategoryOFD, true},
+	0x12: {"Исчерпан ресурс КС", CategoryFN, false},
+	0x13: {"Ошибка ФН", CategoryFN, false},
+	0x14: {"Исчерпан ресурс хранения ФН", CategoryFN, false},
+	0x15: {"Исчерпан ресурс ожидания передачи сообщения в ОФД", CategoryOFD, false},
+	0x16: {"Продолжительность смены более 24 часов", CategoryDeviceState, false},
+	0x17: {"Неверная разница во времени между двумя операциями", CategoryDeviceState, false},
+	0x18: {"ФН: некорректный реквизит, переданный ККТ", CategoryFN, false},
+	0x19: {"ФН: некорректный реквизит с признаком продажи подакцизного товара", CategoryFN, false},
+	0x1A: {"Ошибка ФН", CategoryFN, false},
+	0x1B: {"Ошибка ФН", CategoryFN, false},
+	0x1C: {"Ошибка ФН", CategoryFN, false},
+	0x1D: {"Ошибка ФН", CategoryFN, false},
+	0x1E: {"Ошибка ФН", CategoryFN, false},
+	0x1F: {"Ошибка ФН", CategoryFN, false},
+	0x20: {"Сообщение от ОФД не может быть принято", CategoryOFD, false},
+	0x21: {"Ошибка ФН", CategoryFN, false},
+	0x22: {"Ошибка ФН", CategoryFN, false},
+	0x23: {"Ошибка ФН", CategoryFN, false},
+	0x24: {"Ошибка ФН", CategoryFN, false},
+	0x25: {"Ошибка ФН", CategoryFN, false},
+	0x26: {"Ошибка ФН", CategoryFN, false},
+	0x27: {"Ошибка ФН", CategoryFN, false},
+	0x28: {"Ошибка ФН", CategoryFN, false},
+	0x29: {"Ошибка ФН", CategoryFN, false},
+	0x2A: {"Ошибка ФН", CategoryFN, false},
+	0x2B: {"Ошибка ФН", CategoryFN, false},
+	0x2C: {"Ошибка ФН", CategoryFN, false},
+	0x2D: {"Ошибка ФН", CategoryFN, false},
+	0x2E: {"Ошибка ФН", CategoryFN, false},
+	0x2F: {"Таймаут обмена с ФН", CategoryFN, true},
+	0x30: {"ФН не отвечает", CategoryFN, true},
+
+	// Ошибки ККТ.
+	0x33: {"Некорректные параметры в команде", CategoryDevice, false},
+	0x34: {"Нет данных", CategoryDevice, false},
+	0x35: {"Некорректный параметр при данных настройках", CategoryDevice, false},
+	0x36: {"Некорректные параметры в команде для данной реализации ККТ", CategoryUnsupported, false},
+	0x37: {"Команда не поддерживается в данной реализации ККТ", CategoryUnsupported, false},
+	0x38: {"Ошибка в ПЗУ", CategoryDevice, false},
+	0x39: {"Внутренняя ошибка ПО ККТ", CategoryDevice, false},
+	0x3A: {"Переполнение накопления по надбавкам в смене", CategoryDeviceState, false},
+	0x3B: {"Переполнение накопления в смене", CategoryDeviceState, false},
+	0x3C: {"Смена открыта - операция невозможна", CategoryDeviceState, false},
+	0x3D: {"Смена не открыта - операция невозможна", CategoryDeviceState, false},
+	0x3E: {"Переполнение накопления по секциям в смене", CategoryDeviceState, false},
+	0x3F: {"Переполнение накопления по скидкам в смене", CategoryDeviceState, false},
+	0x40: {"Переполнение диапазона скидок", CategoryDevice, false},
+	0x41: {"Переполнение диапазона оплаты наличными", CategoryDevice, false},
+	0x42: {"Переполнение диапазона оплаты типом 2", CategoryDevice, false},
+	0x43: {"Переполнение диапазона оплаты типом 3", CategoryDevice, false},
+	0x44: {"Переполнение диапазона оплаты типом 4", CategoryDevice, false},
+	0x45: {"Сумма всех типов оплаты меньше итога чека", CategoryDeviceState, false},
+	0x46: {"Не хватает наличности в кассе", CategoryDeviceState, false},
+	0x47: {"Переполнение накопления по налогам в смене", CategoryDeviceState, false},
+	0x48: {"Переполнение итога чека", CategoryDeviceState, false},
+	0x49: {"Операция невозможна в открытом чеке данного типа", CategoryDeviceState, false},
+	0x4A: {"Открыт чек - операция невозможна", CategoryDeviceState, false},
+	0x4B: {"Буфер чека переполнен", CategoryDeviceState, false},
+	0x4C: {"Переполнение накопления по обороту налогов в смене", CategoryDeviceState, false},
+	0x4D: {"Вносимая безналичной оплатой сумма больше суммы чека", CategoryDeviceState, false},
+	0x4E: {"Смена превысила 24 часа", CategoryDeviceState, false},
+	0x4F: {"Неверный пароль", CategoryPassword, false},
+	0x50: {"Идет печать результатов выполнения предыдущей команды", CategoryDeviceState, true},
+	0x51: {"Переполнение накоплений наличными в смене", CategoryDeviceState, false},
+	0x52: {"Переполнение накоплений по типу оплаты 2 в смене", CategoryDeviceState, false},
+	0x53: {"Переполнение накоплений по типу оплаты 3 в смене", CategoryDeviceState, false},
+	0x54: {"Переполнение накоплений по типу оплаты 4 в смене", CategoryDeviceState, false},
+	0x55: {"Чек закрыт - операция невозможна", CategoryDeviceState, false},
+	0x56: {"Нет документа для повтора", CategoryDeviceState, false},
+	0x57: {"Количество закрытых смен не совпадает с ФН", CategoryDeviceState, false},
+	0x58: {"Ожидание команды продолжения печати", CategoryDeviceState, false},
+	0x59: {"Документ открыт другим оператором", CategoryDeviceState, false},
+	0x5A: {"Скидка превышает накопления в чеке", CategoryDeviceState, false},
+	0x5B: {"Переполнение диапазона надбавок", CategoryDevice, false},
+	0x5C: {"Понижено напряжение 24 В", CategoryDevice, false},
+	0x5D: {"Таблица не определена", CategoryUnsupported, false},
+	0x5E: {"Неверная операция", CategoryDevice, false},
+	0x5F: {"Отрицательный итог чека", CategoryDeviceState, false},
+	0x60: {"Переполнение при умножении", CategoryDevice, false},
+	0x61: {"Переполнение диапазона цены", CategoryDevice, false},
+	0x62: {"Переполнение диапазона количества", CategoryDevice, false},
+	0x63: {"Переполнение диапазона отдела", CategoryDevice, false},
+	0x64: {"ФП отсутствует", CategoryDevice, false},
+	0x65: {"Не хватает денег в секции", CategoryDeviceState, false},
+	0x66: {"Переполнение денег в секции", CategoryDeviceState, false},
+	0x67: {"Ошибка связи с ФП", CategoryDevice, true},
+	0x68: {"Не хватает денег по обороту налогов", CategoryDeviceState, false},
+	0x69: {"Переполнение денег по обороту налогов", CategoryDeviceState, false},
+	0x6A: {"Ошибка питания в момент ответа по I2C", CategoryDevice, true},
+	0x6B: {"Нет чековой ленты", CategoryDeviceState, false},
+	0x6C: {"Нет контрольной ленты", CategoryDeviceState, false},
+	0x6D: {"Не хватает денег по налогу", CategoryDeviceState, false},
+	0x6E: {"Переполнение денег по налогу", CategoryDeviceState, false},
+	0x6F: {"Переполнение по выплате в смене", CategoryDeviceState, false},
+	0x70: {"Переполнение ФП", CategoryDevice, false},
+	0x71: {"Ошибка отрезчика", CategoryDevice, false},
+	0x72: {"Команда не поддерживается в данном подрежиме", CategoryDeviceState, true},
+	0x73: {"Команда не поддерживается в данном режиме", CategoryDeviceState, false},
+	0x74: {"Ошибка ОЗУ", CategoryDevice, false},
+	0x75: {"Ошибка питания", CategoryDevice, true},
+	0x76: {"Ошибка принтера: нет импульсов с тахогенератора", CategoryDevice, false},
+	0x77: {"Ошибка принтера: нет сигнала с датчиков", CategoryDevice, false},
+	0x78: {"Замена ПО", CategoryDeviceState, false},
+	0x79: {"Замена ФП", CategoryDeviceState, false},
+	0x7A: {"Поле не редактируется", CategoryDevice, false},
+	0x7B: {"Ошибка оборудования", CategoryDevice, false},
+	0x7C: {"Не совпадает дата", CategoryDeviceState, false},
+	0x7D: {"Неверный формат даты", CategoryDevice, false},
+	0x7E: {"Неверное значение в поле длины", CategoryDevice, false},
+	0x7F: {"Переполнение диапазона итога чека", CategoryDevice, false},
+	0x80: {"Ошибка связи с ФП", CategoryDevice, true},
+	0x81: {"Ошибка связи с ФП", CategoryDevice, true},
+	0x82: {"Ошибка связи с ФП", CategoryDevice, true},
+	0x83: {"Ошибка связи с ФП", CategoryDevice, true},
+	0x84: {"Переполнение наличности", CategoryDeviceState, false},
+	0x85: {"Переполнение по продажам в смене", CategoryDeviceState, false},
+	0x86: {"Переполнение по покупкам в смене", CategoryDeviceState, false},
+	0x87: {"Переполнение по возвратам продаж в смене", CategoryDeviceState, false},
+	0x88: {"Переполнение по возвратам покупок в смене", CategoryDeviceState, false},
+	0x89: {"Переполнение по внесению в смене", CategoryDeviceState, false},
+	0x8A: {"Переполнение по надбавкам в чеке", CategoryDeviceState, false},
+	0x8B: {"Переполнение по скидкам в чеке", CategoryDeviceState, false},
+	0x8C: {"Отрицательный итог надбавки в чеке", CategoryDeviceState, false},
+	0x8D: {"Отрицательный итог скидки в чеке", CategoryDeviceState, false},
+	0x8E: {"Нулевой итог чека", CategoryDeviceState, false},
+	0x8F: {"Касса не фискализирована", CategoryDeviceState, false},
+	0x90: {"Поле превышает размер, установленный в настройках", CategoryDevice, false},
+	0x91: {"Выход за границу поля печати при данных настройках шрифта", CategoryDevice, false},
+	0x92: {"Наложение полей", CategoryDevice, false},
+	0x93: {"Восстановление ОЗУ прошло успешно", CategoryDeviceState, false},
+	0x94: {"Исчерпан лимит операций в чеке", CategoryDeviceState, false},
+	0x95: {"Неизвестная ошибка ЭКЛЗ", CategoryDevice, false},
+	0x96: {"Выполните суточный отчет с гашением", CategoryDeviceState, false},
+	0x9B: {"Некорректное действие", CategoryDevice, false},
+	0x9C: {"Товар не найден по коду в базе товаров", CategoryDevice, false},
+	0x9D: {"Неверные данные в записи о товаре в базе товаров", CategoryDevice, false},
+	0x9E: {"Неверный размер файла базы или регистров товаров", CategoryDevice, false},
+	0xA0: {"Ошибка связи с ЭКЛЗ", CategoryDevice, true},
+	0xA1: {"ЭКЛЗ отсутствует", CategoryDevice, false},
+	0xA2: {"ЭКЛЗ: некорректный формат или параметр команды", CategoryDevice, false},
+	0xA3: {"Некорректное состояние ЭКЛЗ", CategoryDeviceState, false},
+	0xA4: {"Авария ЭКЛЗ", CategoryDevice, false},
+	0xA5: {"Авария КС в составе ЭКЛЗ", CategoryDevice, false},
+	0xA6: {"Исчерпан временной ресурс ЭКЛЗ", CategoryDevice, false},
+	0xA7: {"ЭКЛЗ переполнена", CategoryDevice, false},
+	0xA8: {"ЭКЛЗ: неверные дата и время", CategoryDeviceState, false},
+	0xA9: {"ЭКЛЗ: нет запрошенных данных", CategoryDevice, false},
+	0xAA: {"Переполнение ЭКЛЗ (отрицательный итог документа)", CategoryDevice, false},
+	0xB0: {"ЭКЛЗ: переполнение в параметре количество", CategoryDevice, false},
+	0xB1: {"ЭКЛЗ: переполнение в параметре сумма", CategoryDevice, false},
+	0xB2: {"ЭКЛЗ: уже активизирована", CategoryDeviceState, false},
+	0xC0: {"Контроль даты и времени (подтвердите дату и время)", CategoryDeviceState, false},
+	0xC1: {"ЭКЛЗ: суточный отчет с гашением прервать нельзя", CategoryDeviceState, false},
+	0xC2: {"Превышение напряжения в блоке питания", CategoryDevice, false},
+	0xC3: {"Несовпадение итогов чека и ЭКЛЗ", CategoryDeviceState, false},
+	0xC4: {"Несовпадение номеров смен", CategoryDeviceState, false},
+	0xC5: {"Буфер подкладного документа пуст", CategoryDeviceState, false},
+	0xC6: {"Подкладной документ отсутствует", CategoryDeviceState, false},
+	0xC7: {"Поле не редактируется в данном режиме", CategoryDeviceState, false},
+	0xC8: {"Нет импульсов от таходатчика", CategoryDevice, false},
+	0xC9: {"Перегрев печатающей головки", CategoryDevice, true},
+	0xCA: {"Температура вне условий эксплуатации", CategoryDevice, false},
+	0xCB: {"Неверный подытог чека", CategoryDeviceState, false},
+	0xCE: {"Исчерпан лимит минимального свободного объема ОЗУ или ПЗУ", CategoryDevice, false},
+	0xCF: {"Неверная дата (часы сброшены, установите дату)", CategoryDeviceState, false},
+	0xD0: {"Отчет операционного журнала не распечатан", CategoryDeviceState, false},
+	0xD1: {"Нет данных в буфере", CategoryDevice, false},
+	0xD5: {"Критическая ошибка при загрузке ERRxx", CategoryDevice, false},
+	0xE0: {"Ошибка связи с купюроприемником", CategoryDevice, true},
+	0xE1: {"Купюроприемник занят", CategoryDeviceState, true},
+	0xE2: {"Итог чека не соответствует итогу купюроприемника", CategoryDeviceState, false},
+	0xE3: {"Ошибка купюроприемника", CategoryDevice, false},
+	0xE4: {"Итог купюроприемника не нулевой", CategoryDeviceState, false},
+}
+
+// newDeviceError создает ошибку по коду из каталога. Если драйвер передал
+// собственное описание, оно имеет приоритет над описанием из каталога.
+// Неизвестные коды получают категорию CategoryTransport для отрицательных
+// значений и CategoryDevice для остальных.
+func newDeviceError(code int32, description string) *DeviceError {
+	info, ok := errorCatalog[code]
+	if !ok {
+		info = errorInfo{description: "Неизвестная ошибка", category: CategoryDevice}
+		if code < 0 {
+			info.category = CategoryTransport
+		}
+	}
+	if description == "" {
+		description = info.description
+	}
+	return &DeviceError{
+		Code:        code,
+		Description: description,
+		Category:    info.category,
+		Retryable:   info.retryable,
+	}
+}
+
+// linkError оборачивает ошибку канала связи нативного драйвера в DeviceError.
+func linkError(cmd uint16, err error) *DeviceError {
+	code := codeNoConnection
+	if errors.Is(err, protocol.ErrTimeout) {
+		code = codeExchangeTimeout
+	}
+	e := newDeviceError(code, "")
+	e.Command = cmd
+	e.Err = err
+	return e
+}
+
+// IsRetryable сообщает, имеет ли смысл повторить операцию, завершившуюся ошибкой err.
+func IsRetryable(err error) bool {
+	var de *DeviceError
+	return errors.As(err, &de) && de.Retryable
+}
@@ -0,0 +1,98 @@
+// Тесты каталога ошибок ККТ
+package shtrih
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"shtrih-kkt/pkg/shtrih/protocol"
+)
+
+// TestDeviceError_Catalog проверяет категории и признак повтора для типовых кодов.
+func TestDeviceError_Catalog(t *testing.T) {
+	tests := []struct {
+		code      int32
+		category  ErrorCategory
+		retryable bool
+	}{
+		{-1, CategoryTransport, true},
+		{-3, CategoryTransport, true},
+		{0x02, CategoryFN, false},
+		{0x11, CategoryOFD, true},
+		{0x13, CategoryFN, false},
+		{0x2A, CategoryFN, false},
+		{0x37, CategoryUnsupported, false},
+		{0x4A, CategoryDeviceState, false},
+		{0x4F, CategoryPassword, false},
+		{0x50, CategoryDeviceState, true},
+		{0x6B, CategoryDeviceState, false},
+		{0x80, CategoryDevice, true},
+		{0xEE, CategoryDevice, false},
+		{-100, CategoryTransport, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("код %d", tt.code), func(t *testing.T) {
+			e := newDeviceError(tt.code, "")
+			if e.Category != tt.category || e.Retryable != tt.retryable {
+				t.Errorf("newDeviceError(%d) = {%s, %v}, ожидается {%s, %v}", tt.code, e.Category, e.Retryable, tt.category, tt.retryable)
+			}
+			if e.Description == "" {
+				t.Error("Описание ошибки не заполнено.")
+			}
+		})
+	}
+}
+
+// TestErrorCatalog_Coverage проверяет, что каталог покрывает диапазоны кодов
+// из таблицы ошибок протокола, и документированные коды не считаются
+// "Неизвестной ошибкой".
+func TestErrorCatalog_Coverage(t *testing.T) {
+	documented := [][2]int32{
+		{-13, -1},    // Ошибки связи COM-драйвера
+		{0x01, 0x30}, // Ошибки ФН
+		{0x33, 0x96}, // Ошибки ККТ
+		{0x9B, 0x9E}, // База товаров
+		{0xA0, 0xAA}, // ЭКЛЗ
+		{0xB0, 0xB2}, // ЭКЛЗ
+		{0xC0, 0xCB}, // Оборудование и документы
+		{0xE0, 0xE4}, // Купюроприемник
+	}
+	for _, r := range documented {
+		for code := r[0]; code <= r[1]; code++ {
+			if _, ok := errorCatalog[code]; !ok {
+				t.Errorf("Код %d (0x%02X) отсутствует в каталоге ошибок.", code, code)
+			}
+		}
+	}
+}
+
+// TestDeviceError_IsAs проверяет работу errors.Is и errors.As через обертки.
+func TestDeviceError_IsAs(t *testing.T) {
+	err := fmt.Errorf("ошибка получения информации о ФН: %w", newDeviceError(0x4F, "Неверный пароль"))
+
+	if !errors.Is(err, CategoryPassword) {
+		t.Error("errors.Is не распознал категорию ошибки.")
+	}
+	if errors.Is(err, CategoryTransport) {
+		t.Error("errors.Is сопоставил ошибку с чужой категорией.")
+	}
+	if !errors.Is(err, &DeviceError{Code: 0x4F}) {
+		t.Error("errors.Is не сопоставил ошибку по коду.")
+	}
+	var de *DeviceError
+	if !errors.As(err, &de) || de.Code != 0x4F {
+		t.Errorf("errors.As не извлек DeviceError: %v", de)
+	}
+	if IsRetryable(err) {
+		t.Error("Ошибка пароля не должна считаться повторяемой.")
+	}
+}
+
+// TestLinkError проверяет, что исходная ошибка канала доступна через Unwrap.
+func TestLinkError(t *testing.T) {
+	err := linkError(cmdGetECRStatus, protocol.ErrNoConnection)
+	if !errors.Is(err, protocol.ErrNoConnection) || !errors.Is(err, CategoryTransport) || !IsRetryable(err) {
+		t.Errorf("linkError() = %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Файл: pkg/shtrih/fnstatus.go
+package shtrih
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FnPhase - фаза жизни ФН. Значения накопительные: каждая следующая
+// фаза добавляет старший бит к предыдущей.
+type FnPhase byte
+
+// Фазы жизни ФН.
+const (
+	FnPhaseSetup       FnPhase = 0x00 // Настройка
+	FnPhaseReady       FnPhase = 0x01 // Готовность к фискализации
+	FnPhaseFiscal      FnPhase = 0x03 // Фискальный режим
+	FnPhasePostFiscal  FnPhase = 0x07 // Постфискальный режим, передача ФД в ОФД
+	FnPhaseArchiveRead FnPhase = 0x0F // Чтение данных из архива ФН
+)
+
+// fnStatusResponseLen - длина ответа на команду FF01h после кода ошибки.
+const fnStatusResponseLen = 30
+
+func (p FnPhase) String() string {
+	switch p {
+	case FnPhaseSetup:
+		return "настройка"
+	case FnPhaseReady:
+		return "готовность к фискализации"
+	case FnPhaseFiscal:
+		return "фискальный режим"
+	case FnPhasePostFiscal:
+		return "постфискальный режим"
+	case FnPhaseArchiveRead:
+		return "чтение данных из архива"
+	default:
+		return fmt.Sprintf("неизвестная фаза (0x%02X)", byte(p))
+	}
+}
+
+// Биты флагов предупреждения ФН.
+const (
+	fnWarnUrgentReplacement = 0x01 // Срочная замена КС (до окончания срока 3 дня)
+	fnWarnResourceExhausted = 0x02 // Исчерпание ресурса КС (до окончания срока 30 дней)
+	fnWarnMemoryFull        = 0x04 // Архив ФН заполнен на 90%
+	fnWarnOFDTimeout        = 0x08 // Превышено время ожидания ответа ОФД
+	fnWarnCriticalError     = 0x80 // Критическая ошибка ФН
+)
+
+// FnWarnings - расшифрованные флаги предупреждения ФН.
+type FnWarnings struct {
+	UrgentReplacement bool `json:"urgent_replacement"` // Срочная замена ФН
+	ResourceExhausted bool `json:"resource_exhausted"` // Исчерпание ресурса ФН
+	MemoryFull        bool `json:"memory_full_90"`     // Память ФН заполнена на 90%
+	OFDTimeout        bool `json:"ofd_timeout"`        // Превышено время ожидания ответа ОФД
+	CriticalError     bool `json:"critical_error"`     // Критическая ошибка ФН
+}
+
+// FnStatus - состояние ФН по команде FF01h "Запрос статуса ФН".
+type FnStatus struct {
+	Phase              FnPhase    `json:"phase"`              // Фаза жизни ФН
+	PhaseName          string     `json:"phase_name"`         // Фаза жизни ФН в текстовом виде
+	LastDocumentNumber uint32     `json:"last_fd_number"`     // Номер последнего ФД
+	LastDocumentDate   string     `json:"last_fd_datetime"`   // Дата и время последнего ФД
+	ShiftOpen          bool       `json:"shift_open"`         // Смена открыта
+	DocumentOpen       bool       `json:"document_open"`      // Открыт документ
+	OpenDocumentType   byte       `json:"open_document_type"` // Тип открытого документа (0 - нет)
+	WarningFlags       byte       `json:"warning_flags"`      // Флаги предупреждения как есть
+	Warnings           FnWarnings `json:"warnings"`           // Расшифрованные флаги предупреждения
+}
+
+// newFnStatus заполняет производные поля статуса: название фазы и расшифровку флагов.
+func newFnStatus(phase FnPhase, flags byte) *FnStatus {
+	return &FnStatus{
+		Phase:        phase,
+		PhaseName:    phase.String(),
+		WarningFlags: flags,
+		Warnings: FnWarnings{
+			UrgentReplacement: flags&fnWarnUrgentReplacement != 0,
+			ResourceExhausted: flags&fnWarnResourceExhausted != 0,
+			MemoryFull:        flags&fnWarnMemoryFull != 0,
+			OFDTimeout:        flags&fnWarnOFDTimeout != 0,
+			CriticalError:     flags&fnWarnCriticalError != 0,
+		},
+	}
+}
+
+// parseFnStatus разбирает ответ на команду FF01h: фаза (1), текущий документ (1),
+// данные документа (1), состояние смены (1), флаги предупреждения (1),
+// дата и время последнего ФД (5, ГГ ММ ДД чч мм), номер ФН (16), номер последнего ФД (4).
+func parseFnStatus(resp []byte) (*FnStatus, error) {
+	if len(resp) < fnStatusResponseLen {
+		return nil, fmt.Errorf("слишком короткий ответ на запрос статуса ФН: %d байт", len(resp))
+	}
+	status := newFnStatus(FnPhase(resp[0]), resp[4])
+	status.OpenDocumentType = resp[1]
+	status.DocumentOpen = resp[1] != 0
+	status.ShiftOpen = resp[3] != 0
+	if t, ok := parseDateTimeYMDHM(resp[5:10]); ok {
+		status.LastDocumentDate = t.Format("2006-01-02 15:04:05")
+	}
+	status.LastDocumentNumber = binary.LittleEndian.Uint32(resp[26:30])
+	return status, nil
+}
+
+// encodeFnStatus формирует ответ на команду FF01h (используется эмулятором).
+func encodeFnStatus(s *FnStatus, fnSerial string) []byte {
+	resp := make([]byte, fnStatusResponseLen)
+	resp[0] = byte(s.Phase)
+	resp[1] = s.OpenDocumentType
+	if s.DocumentOpen && resp[1] == 0 {
+		resp[1] = 0x04 // Кассовый чек
+	}
+	if s.ShiftOpen {
+		resp[3] = 1
+	}
+	resp[4] = s.WarningFlags
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s.LastDocumentDate, time.Local); err == nil {
+		resp[5], resp[6], resp[7], resp[8], resp[9] = byte(t.Year()%100), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute())
+	}
+	copy(resp[10:26], padded(strings.TrimSpace(fnSerial), 16, ' '))
+	binary.LittleEndian.PutUint32(resp[26:30], s.LastDocumentNumber)
+	return resp
+}
@@ -0,0 +1,137 @@
+// Файл: pkg/shtrih/forecast.go
+package shtrih
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// fnMemoryResponseLen - длина ответа на команду FF3Dh после кода ошибки.
+const fnMemoryResponseLen = 8
+
+// FnMemoryResource - свободный ресурс памяти ФН по команде FF3Dh
+// "Запрос ресурса свободной памяти ФН". Значения передаются как их сообщает ФН.
+type FnMemoryResource struct {
+	Archive5Years uint32 `json:"archive_5y_free"`     // Ресурс данных 5-летнего хранения
+	Storage30Days uint32 `json:"storage_30d_free_kb"` // Ресурс данных 30-дневного хранения, КБ
+}
+
+// parseFnMemory разбирает ответ на команду FF3Dh: ресурс 5-летнего хранения (4)
+// и ресурс 30-дневного хранения (4).
+func parseFnMemory(resp []byte) (*FnMemoryResource, error) {
+	if len(resp) < fnMemoryResponseLen {
+		return nil, fmt.Errorf("слишком короткий ответ на запрос ресурса памяти ФН: %d байт", len(resp))
+	}
+	return &FnMemoryResource{
+		Archive5Years: binary.LittleEndian.Uint32(resp[0:4]),
+		Storage30Days: binary.LittleEndian.Uint32(resp[4:8]),
+	}, nil
+}
+
+// Severity - уровень важности прогноза по ФН.
+type Severity string
+
+// Уровни важности.
+const (
+	SeverityOK       Severity = "ok"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// rank возвращает порядок уровня для сравнения.
+func (s Severity) rank() int {
+	switch s {
+	case SeverityCritical:
+		return 2
+	case SeverityWarning:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ForecastThresholds - пороги прогноза в днях.
+type ForecastThresholds struct {
+	// WarningDays - за сколько дней до окончания срока ФН выдавать предупреждение.
+	WarningDays int `json:"warning_days"`
+	// CriticalDays - за сколько дней до окончания срока ФН считать ситуацию критической.
+	CriticalDays int `json:"critical_days"`
+	// OfdWarningDays - возраст очереди ОФД (дней), с которого выдается предупреждение.
+	OfdWarningDays int `json:"ofd_warning_days"`
+	// OfdCriticalDays - возраст очереди ОФД (дней), с которого ситуация критическая.
+	OfdCriticalDays int `json:"ofd_critical_days"`
+}
+
+// DefaultForecastThresholds возвращает пороги по умолчанию.
+func DefaultForecastThresholds() ForecastThresholds {
+	return ForecastThresholds{WarningDays: 30, CriticalDays: 7, OfdWarningDays: 7, OfdCriticalDays: 25}
+}
+
+// FnForecast - прогноз по замене ФН.
+type FnForecast struct {
+	DaysLeft         int      `json:"days_left"`          // Дней до окончания срока ФН (отрицательное - срок истек)
+	MemoryAlmostFull bool     `json:"memory_almost_full"` // ФН сообщил о заполнении памяти на 90%
+	Severity         Severity `json:"severity"`           // Итоговый уровень важности
+	Reasons          []string `json:"reasons,omitempty"`  // Причины предупреждения
+}
+
+// raise повышает уровень прогноза и добавляет причину.
+func (f *FnForecast) raise(s Severity, reason string) {
+	if s.rank() > f.Severity.rank() {
+		f.Severity = s
+	}
+	f.Reasons = append(f.Reasons, reason)
+}
+
+// ForecastFn рассчитывает прогноз по ФН на момент now: дни до окончания срока,
+// заполнение памяти (сам ресурс памяти - в FiscalInfo.FnMemory) и уровень важности с учетом флагов предупреждения ФН и
+// возраста очереди документов для ОФД. Возвращает nil, если дата окончания
+// срока ФН неизвестна.
+func ForecastFn(info *FiscalInfo, now time.Time, th ForecastThresholds) *FnForecast {
+	end, err := time.ParseInLocation("2006-01-02 15:04:05", info.FnEndDate, time.Local)
+	if err != nil {
+		return nil
+	}
+	f := &FnForecast{
+		DaysLeft: int(math.Floor(end.Sub(now).Hours() / 24)),
+		Severity: SeverityOK,
+	}
+
+	switch {
+	case f.DaysLeft < 0:
+		f.raise(SeverityCritical, "срок действия ФН истек")
+	case f.DaysLeft <= th.CriticalDays:
+		f.raise(SeverityCritical, fmt.Sprintf("до окончания срока ФН осталось %d дн.", f.DaysLeft))
+	case f.DaysLeft <= th.WarningDays:
+		f.raise(SeverityWarning, fmt.Sprintf("до окончания срока ФН осталось %d дн.", f.DaysLeft))
+	}
+
+	if s := info.FnStatus; s != nil {
+		f.MemoryAlmostFull = s.Warnings.MemoryFull
+		if s.Warnings.CriticalError {
+			f.raise(SeverityCritical, "критическая ошибка ФН")
+		}
+		if s.Warnings.UrgentReplacement {
+			f.raise(SeverityCritical, "ФН требует срочной замены")
+		}
+		if s.Warnings.ResourceExhausted {
+			f.raise(SeverityWarning, "ресурс ФН исчерпывается")
+		}
+		if s.Warnings.MemoryFull {
+			f.raise(SeverityWarning, "память ФН заполнена на 90%")
+		}
+	}
+
+	if o := info.OfdStatus; o != nil && o.UnsentCount > 0 {
+		days := o.UnsentDays(now)
+		switch {
+		case days >= th.OfdCriticalDays:
+			f.raise(SeverityCritical, fmt.Sprintf("документы не передаются в ОФД %d дн.", days))
+		case days >= th.OfdWarningDays:
+			f.raise(SeverityWarning, fmt.Sprintf("документы не передаются в ОФД %d дн.", days))
+		}
+	}
+	return f
+}
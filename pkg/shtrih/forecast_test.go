@@ -0,0 +1,90 @@
+// Тесты прогноза по замене ФН
+package shtrih
+
+import (
+	"testing"
+	"time"
+)
+
+// TestForecastFn проверяет расчет оставшихся дней и уровня важности.
+func TestForecastFn(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+	th := DefaultForecastThresholds()
+
+	tests := []struct {
+		name     string
+		info     FiscalInfo
+		daysLeft int
+		severity Severity
+	}{
+		{
+			name:     "Срок далеко",
+			info:     FiscalInfo{FnEndDate: "2027-03-15 00:00:00"},
+			daysLeft: 149,
+			severity: SeverityOK,
+		},
+		{
+			name:     "Меньше месяца",
+			info:     FiscalInfo{FnEndDate: "2026-11-10 00:00:00"},
+			daysLeft: 24,
+			severity: SeverityWarning,
+		},
+		{
+			name:     "Меньше недели",
+			info:     FiscalInfo{FnEndDate: "2026-10-20 00:00:00"},
+			daysLeft: 3,
+			severity: SeverityCritical,
+		},
+		{
+			name:     "Срок истек",
+			info:     FiscalInfo{FnEndDate: "2026-10-01 00:00:00"},
+			daysLeft: -16,
+			severity: SeverityCritical,
+		},
+		{
+			name: "Память заполнена на 90%",
+			info: FiscalInfo{
+				FnEndDate: "2027-03-15 00:00:00",
+				FnStatus:  newFnStatus(FnPhaseFiscal, fnWarnMemoryFull),
+			},
+			daysLeft: 149,
+			severity: SeverityWarning,
+		},
+		{
+			name: "Срочная замена",
+			info: FiscalInfo{
+				FnEndDate: "2027-03-15 00:00:00",
+				FnStatus:  newFnStatus(FnPhaseFiscal, fnWarnUrgentReplacement),
+			},
+			daysLeft: 149,
+			severity: SeverityCritical,
+		},
+		{
+			name: "Очередь ОФД 26 дней",
+			info: FiscalInfo{
+				FnEndDate: "2027-03-15 00:00:00",
+				OfdStatus: &OfdExchangeStatus{UnsentCount: 40, FirstUnsentDate: "2026-09-20 10:00:00"},
+			},
+			daysLeft: 149,
+			severity: SeverityCritical,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := ForecastFn(&tt.info, now, th)
+			if f == nil {
+				t.Fatal("ForecastFn() вернул nil.")
+			}
+			if f.DaysLeft != tt.daysLeft || f.Severity != tt.severity {
+				t.Errorf("ForecastFn() = {%d, %s, %v}, ожидается {%d, %s}", f.DaysLeft, f.Severity, f.Reasons, tt.daysLeft, tt.severity)
+			}
+			if f.Severity != SeverityOK && len(f.Reasons) == 0 {
+				t.Error("Не указана причина предупреждения.")
+			}
+		})
+	}
+
+	if f := ForecastFn(&FiscalInfo{}, now, th); f != nil {
+		t.Errorf("Без даты окончания срока ФН ожидался nil, получено %+v", f)
+	}
+}
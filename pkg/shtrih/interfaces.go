@@ -0,0 +1,141 @@
+// Файл: pkg/shtrih/interfaces.go
+package shtrih
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Ограничения при построении списка подсетей по интерфейсам хоста.
+const (
+	// defaultMaxHosts - сколько адресов всего проверяется в найденных подсетях.
+	defaultMaxHosts = 1024
+	// maxAttachedPrefix - подсети крупнее /24 на обычных интерфейсах не сканируются,
+	// на USB-интерфейсах сужаются до /24 вокруг адреса хоста.
+	maxAttachedPrefix = 24
+)
+
+// hostInterface - сетевой интерфейс хоста и его IPv4-подсети.
+type hostInterface struct {
+	Name  string
+	Flags net.Flags
+	Nets  []*net.IPNet
+}
+
+// listInterfaces возвращает интерфейсы хоста. Вынесена в переменную пакета,
+// чтобы тесты могли подставить свою конфигурацию сети.
+var listInterfaces = systemInterfaces
+
+func systemInterfaces() ([]hostInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var result []hostInterface
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		hi := hostInterface{Name: ifi.Name, Flags: ifi.Flags}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				hi.Nets = append(hi.Nets, ipNet)
+			}
+		}
+		result = append(result, hi)
+	}
+	return result, nil
+}
+
+// usbInterfaceMarkers - части имен, по которым интерфейс считается RNDIS/USB-Ethernet
+// (Windows: "Remote NDIS", Linux: usb0, enx<MAC> и CDC NCM/ECM).
+var usbInterfaceMarkers = []string{"rndis", "remote ndis", "usb", "ncm", "ecm"}
+
+// isUSBInterface сообщает, похож ли интерфейс на RNDIS/USB-Ethernet адаптер ККТ:
+// по имени или по тому, что его подсеть - одна из стандартных подсетей RNDIS.
+func isUSBInterface(hi hostInterface, subnet string) bool {
+	name := strings.ToLower(hi.Name)
+	if strings.HasPrefix(name, "enx") {
+		return true
+	}
+	for _, marker := range usbInterfaceMarkers {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	for _, s := range rndisSubnets {
+		if s == subnet {
+			return true
+		}
+	}
+	return false
+}
+
+// subnetCandidate - подсеть для сканирования, найденная на интерфейсе хоста.
+type subnetCandidate struct {
+	cidr  string
+	hosts int
+	usb   bool
+}
+
+// localSubnets строит список подсетей для поиска по интерфейсам хоста: подсети
+// RNDIS/USB-адаптеров идут первыми, затем прочие непосредственно подключенные
+// подсети не крупнее /24. Loopback, выключенные интерфейсы и соединения точка-точка
+// пропускаются. Подсети добавляются, пока общее число адресов не превысит maxHosts.
+func localSubnets(maxHosts int) []string {
+	if maxHosts <= 0 {
+		maxHosts = defaultMaxHosts
+	}
+	ifaces, err := listInterfaces()
+	if err != nil {
+		log.Printf("Не удалось получить список сетевых интерфейсов: %v", err)
+		return nil
+	}
+
+	var candidates []subnetCandidate
+	seen := make(map[string]bool)
+	for _, hi := range ifaces {
+		if hi.Flags&net.FlagUp == 0 || hi.Flags&net.FlagLoopback != 0 || hi.Flags&net.FlagPointToPoint != 0 {
+			continue
+		}
+		for _, ipNet := range hi.Nets {
+			ones, bits := ipNet.Mask.Size()
+			if bits != 32 || ones > 30 {
+				continue
+			}
+			mask := ipNet.Mask
+			subnet := (&net.IPNet{IP: ipNet.IP.Mask(mask), Mask: mask}).String()
+			usb := isUSBInterface(hi, subnet)
+			if ones < maxAttachedPrefix {
+				if !usb {
+					log.Printf("Подсеть %s интерфейса '%s' слишком велика для автопоиска и пропущена.", subnet, hi.Name)
+					continue
+				}
+				mask = net.CIDRMask(maxAttachedPrefix, 32)
+				ones = maxAttachedPrefix
+				subnet = (&net.IPNet{IP: ipNet.IP.Mask(mask), Mask: mask}).String()
+			}
+			if seen[subnet] {
+				continue
+			}
+			seen[subnet] = true
+			candidates = append(candidates, subnetCandidate{cidr: subnet, hosts: 1<<uint(32-ones) - 2, usb: usb})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].usb && !candidates[j].usb })
+
+	var subnets []string
+	total := 0
+	for _, c := range candidates {
+		if total+c.hosts > maxHosts {
+			log.Printf("Подсеть %s пропущена: превышен лимит адресов для автопоиска (%d).", c.cidr, maxHosts)
+			continue
+		}
+		total += c.hosts
+		subnets = append(subnets, c.cidr)
+	}
+	return subnets
+}
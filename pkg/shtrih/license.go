@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 )
 
 // licenseInfo хранит информацию о квартале и годе для конкретного суффикса лицензии.
@@ -100,3 +101,14 @@ func decodeLicense(hex string) string {
 
 	return ""
 }
+
+// LicenseExpiry возвращает момент окончания подписки, расшифрованной
+// decodeLicense ("Подписка до 2 квартала 2026 года"): начало квартала,
+// следующего за оплаченным, по местному времени. Для других строк возвращает false.
+func LicenseExpiry(subscription string) (time.Time, bool) {
+	var quarter, year int
+	if _, err := fmt.Sscanf(subscription, "Подписка до %d квартала %d года", &quarter, &year); err != nil || quarter < 1 || quarter > 4 {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(quarter*3+1), 1, 0, 0, 0, 0, time.Local), true
+}
@@ -4,6 +4,7 @@ package shtrih
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestDecodeLicense(t *testing.T) {
@@ -264,3 +265,24 @@ func TestDecodeLicenseCaseInsensitive(t *testing.T) {
 		})
 	}
 }
+
+func TestLicenseExpiry(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Time
+		ok       bool
+	}{
+		{"Подписка до 1 квартала 2022 года", time.Date(2022, 4, 1, 0, 0, 0, 0, time.Local), true},
+		{"Подписка до 4 квартала 2026 года", time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local), true},
+		{decodeLicense("FFFFFFFFFFFFFFFFFFFFFF3F" + strings.Repeat("0", 40)), time.Date(2027, 7, 1, 0, 0, 0, 0, time.Local), true},
+		{"Подписка до 5 квартала 2026 года", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		result, ok := LicenseExpiry(tt.input)
+		if ok != tt.ok || !result.Equal(tt.expected) {
+			t.Errorf("LicenseExpiry(%q) = %v, %v, ожидается %v, %v", tt.input, result, ok, tt.expected, tt.ok)
+		}
+	}
+}
@@ -2,8 +2,10 @@
 package shtrih
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 )
 
 // mockDriver представляет собой имитацию реального драйвера для целей тестирования.
@@ -15,6 +17,10 @@ type mockDriver struct {
 	ConnectErr error
 	// GetFiscalInfoErr - ошибка, которую вернет метод GetFiscalInfo, если она задана.
 	GetFiscalInfoErr error
+	// MockTables - значения полей таблиц для методов TableDriver, ключ - {таблица, ряд, поле}.
+	MockTables map[[3]int]TableValue
+	// Delay - задержка ответа ConnectContext и GetFiscalInfoContext, имитирует медленное устройство.
+	Delay time.Duration
 
 	// Внутренние флаги для проверки вызовов в тестах.
 	connected           bool
@@ -33,6 +39,12 @@ func NewMockDriver(data *FiscalInfo, connectErr, getInfoErr error) Driver {
 	}
 }
 
+// NewMockTableDriver создает мок-драйвер, который дополнительно отдает
+// значения полей таблиц через интерфейс TableDriver.
+func NewMockTableDriver(data *FiscalInfo, tables map[[3]int]TableValue) TableDriver {
+	return &mockDriver{MockData: data, MockTables: tables}
+}
+
 // Connect имитирует подключение к ККТ.
 func (m *mockDriver) Connect() error {
 	m.ConnectCalled = true
@@ -83,3 +95,77 @@ func (m *mockDriver) GetFiscalInfo() (*FiscalInfo, error) {
 
 	return m.MockData, nil
 }
+
+// ConnectContext имитирует подключение, которое занимает Delay.
+func (m *mockDriver) ConnectContext(ctx context.Context) error {
+	if err := m.wait(ctx, StepConnect); err != nil {
+		return err
+	}
+	return m.Connect()
+}
+
+// GetFiscalInfoContext имитирует получение фискальных данных, которое занимает Delay.
+func (m *mockDriver) GetFiscalInfoContext(ctx context.Context) (*FiscalInfo, error) {
+	if err := m.wait(ctx, StepCollectInfo); err != nil {
+		return nil, err
+	}
+	return m.GetFiscalInfo()
+}
+
+// wait выдерживает задержку Delay или возвращает ошибку шага step при отмене ctx.
+func (m *mockDriver) wait(ctx context.Context, step string) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(step, err)
+	}
+	timer := time.NewTimer(m.Delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return contextError(step, ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// GetTableStruct имитирует запрос структуры таблицы по данным MockTables.
+func (m *mockDriver) GetTableStruct(table int) (*TableStruct, error) {
+	ts := &TableStruct{Number: table}
+	for key := range m.MockTables {
+		if key[0] != table {
+			continue
+		}
+		if key[1] > ts.Rows {
+			ts.Rows = key[1]
+		}
+		if key[2] > ts.Fields {
+			ts.Fields = key[2]
+		}
+	}
+	if ts.Rows == 0 {
+		return nil, newDeviceError(0x5D, "")
+	}
+	return ts, nil
+}
+
+// GetFieldStruct имитирует запрос структуры поля по данным MockTables.
+func (m *mockDriver) GetFieldStruct(table, field int) (*FieldStruct, error) {
+	for key, v := range m.MockTables {
+		if key[0] == table && key[2] == field {
+			fs := v.Field
+			return &fs, nil
+		}
+	}
+	return nil, newDeviceError(0x33, "")
+}
+
+// ReadTable имитирует чтение поля таблицы из MockTables.
+func (m *mockDriver) ReadTable(table, row, field int) (*TableValue, error) {
+	if !m.connected {
+		return nil, fmt.Errorf("мок-драйвер: не подключен")
+	}
+	v, ok := m.MockTables[[3]int{table, row, field}]
+	if !ok {
+		return nil, newDeviceError(0x33, "")
+	}
+	return &v, nil
+}
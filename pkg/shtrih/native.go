@@ -0,0 +1,537 @@
+// Файл: pkg/shtrih/native.go
+package shtrih
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"shtrih-kkt/pkg/shtrih/protocol"
+
+	"go.bug.st/serial"
+)
+
+// Коды команд протокола "Штрих-М", используемые нативным драйвером.
+const (
+	cmdGetECRStatus            uint16 = 0x11   // Запрос состояния ККТ
+	cmdReadTable               uint16 = 0x1E   // Чтение поля таблицы
+	cmdGetTableStruct          uint16 = 0x2D   // Запрос структуры таблицы
+	cmdGetFieldStruct          uint16 = 0x2E   // Запрос структуры поля
+	cmdGetDeviceMetrics        uint16 = 0xFC   // Получить тип устройства
+	cmdFNGetStatus             uint16 = 0xFF01 // Запрос статуса ФН
+	cmdFNGetSerial             uint16 = 0xFF02 // Запрос номера ФН
+	cmdFNGetExpirationTime     uint16 = 0xFF03 // Запрос срока действия ФН
+	cmdFNGetFiscalizationTotal uint16 = 0xFF09 // Запрос итогов последней фискализации
+	cmdFNGetImplementation     uint16 = 0xFF35 // Запрос исполнения ФН
+	cmdFNGetOfdStatus          uint16 = 0xFF39 // Статус информационного обмена с ОФД
+	cmdFNGetMemoryResource     uint16 = 0xFF3D // Запрос ресурса свободной памяти ФН
+	cmdReadFeatureLicenses     uint16 = 0xFF6B // Чтение лицензий
+)
+
+// port описывает канал связи с ККТ: последовательный порт или TCP-сокет.
+type port interface {
+	protocol.Port
+	Close() error
+}
+
+// nativeDriverName записывается в FiscalInfo.InstalledDriver вместо версии COM-драйвера.
+const nativeDriverName = "native"
+
+// baudRates сопоставляет индекс скорости драйвера (Config.BaudRate) со скоростью порта.
+var baudRates = []int{2400, 4800, 9600, 19200, 38400, 57600, 115200}
+
+// nativeDriver является реализацией интерфейса Driver, которая работает
+// с ККТ напрямую по протоколу "Штрих-М" без COM-драйвера. Командный уровень
+// не зависит от транспорта: последовательный порт или TCP.
+type nativeDriver struct {
+	config    Config
+	port      port
+	conn      *protocol.Conn
+	fields    map[[2]int]*FieldStruct
+	connected bool
+	// ctx - контекст выполняемой операции (см. watch), nil вне операции.
+	ctx context.Context
+}
+
+// NewNative создает драйвер, работающий по протоколу "Штрих-М" напрямую
+// через последовательный порт (ConnectionType 0) или TCP (ConnectionType 6).
+// Не требует установленного COM-драйвера и работает на любой ОС и архитектуре.
+func NewNative(config Config) Driver {
+	return &nativeDriver{config: config}
+}
+
+// Connect открывает порт и проверяет связь запросом состояния ККТ.
+func (d *nativeDriver) Connect() error {
+	return d.ConnectContext(context.Background())
+}
+
+// ConnectContext открывает порт и проверяет связь с учетом ctx. При отмене ctx
+// порт закрывается, что прерывает ожидание ответа ККТ.
+func (d *nativeDriver) ConnectContext(ctx context.Context) error {
+	if d.connected {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return contextError(StepConnect, err)
+	}
+	p, err := d.openPort(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return contextError(StepConnect, ctx.Err())
+		}
+		return err
+	}
+	d.port = p
+	d.conn = protocol.NewConn(p, protocol.Options{ByteTimeout: d.config.Timeout})
+	d.fields = make(map[[2]int]*FieldStruct)
+
+	step := StepConnect
+	if err := d.watch(ctx, &step, func() error {
+		_, err := d.command(cmdGetECRStatus)
+		return err
+	}); err != nil {
+		p.Close()
+		return fmt.Errorf("driver error on connect: %w", err)
+	}
+
+	d.connected = true
+	log.Println("Подключение к ККТ успешно установлено (нативный протокол).")
+	return nil
+}
+
+// openPort открывает канал связи в соответствии с типом подключения.
+func (d *nativeDriver) openPort(ctx context.Context) (port, error) {
+	switch d.config.ConnectionType {
+	case 0:
+		idx := int(d.config.BaudRate)
+		if idx < 0 || idx >= len(baudRates) {
+			return nil, fmt.Errorf("некорректный индекс скорости: %d", d.config.BaudRate)
+		}
+		p, err := serial.Open(d.config.ComName, &serial.Mode{BaudRate: baudRates[idx]})
+		if err != nil {
+			return nil, serialPortError(d.config.ComName, err)
+		}
+		return p, nil
+	case 6:
+		p, err := dialTCP(ctx, d.config.IPAddress, d.config.TCPPort, d.config.Timeout)
+		if err != nil {
+			e := newDeviceError(codeServerConnect, "")
+			e.Err = err
+			return nil, e
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("тип подключения %d не поддерживается нативным драйвером", d.config.ConnectionType)
+	}
+}
+
+// watch выполняет fn, закрывая порт при отмене ctx: так прерывается ожидание
+// ответа ККТ внутри команды. Если ctx отменен, соединение считается разорванным
+// и возвращается ошибка с именем шага, который выполнялся в этот момент.
+func (d *nativeDriver) watch(ctx context.Context, step *string, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(*step, err)
+	}
+	d.ctx = ctx
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func(p port) {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			p.Close()
+		case <-stop:
+		}
+	}(d.port)
+
+	err := fn()
+	close(stop)
+	<-done
+	d.ctx = nil
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if d.connected {
+			d.port.Close()
+			d.connected = false
+		}
+		return contextError(*step, ctxErr)
+	}
+	return err
+}
+
+// serialPortError переводит ошибку открытия последовательного порта в DeviceError
+// с кодом, который вернул бы COM-драйвер в той же ситуации.
+func serialPortError(name string, err error) error {
+	code := codePortUnavailable
+	var portErr *serial.PortError
+	if errors.As(err, &portErr) && portErr.Code() == serial.PortBusy {
+		code = codePortBusy
+	}
+	e := newDeviceError(code, "")
+	e.Err = fmt.Errorf("open serial port %s failed: %w", name, err)
+	return e
+}
+
+// Disconnect закрывает порт.
+func (d *nativeDriver) Disconnect() error {
+	if !d.connected {
+		return nil
+	}
+	d.port.Close()
+	d.connected = false
+	log.Println("Соединение с ККТ разорвано.")
+	return nil
+}
+
+// GetFiscalInfo собирает ту же информацию, что и comDriver, используя команды протокола.
+func (d *nativeDriver) GetFiscalInfo() (*FiscalInfo, error) {
+	return d.GetFiscalInfoContext(context.Background())
+}
+
+// GetFiscalInfoContext собирает информацию о ККТ с учетом ctx. При отмене ctx
+// во время шага соединение разрывается, и для продолжения работы нужен Connect.
+func (d *nativeDriver) GetFiscalInfoContext(ctx context.Context) (*FiscalInfo, error) {
+	if !d.connected {
+		return nil, fmt.Errorf("драйвер не подключен")
+	}
+	var info *FiscalInfo
+	step := StepBaseInfo
+	err := d.watch(ctx, &step, func() error {
+		var err error
+		info, err = collectFiscalInfo(ctx, fiscalInfoSteps(d.getBaseDeviceInfo, d.getFiscalizationInfo, d.getFnInfo, d.getInfoFromTables), func(s string) { step = s })
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.InstalledDriver = nativeDriverName
+	return info, nil
+}
+
+// command выполняет команду, передавая пароль в первых четырех байтах данных.
+func (d *nativeDriver) command(cmd uint16, args ...byte) ([]byte, error) {
+	data := make([]byte, 4, 4+len(args))
+	binary.LittleEndian.PutUint32(data, uint32(d.config.Password))
+	return d.exchange(cmd, append(data, args...))
+}
+
+// exchange выполняет обмен и при обрыве связи на транспортах с поддержкой
+// переподключения (TCP) восстанавливает соединение и повторяет команду один раз.
+// Команды, используемые драйвером, только читают данные, поэтому повтор безопасен.
+// При отмене контекста операции переподключение не выполняется.
+// Ненулевой код ошибки ККТ и обрыв связи возвращаются как *DeviceError.
+func (d *nativeDriver) exchange(cmd uint16, data []byte) ([]byte, error) {
+	resp, err := d.conn.Exchange(cmd, data)
+	if err != nil && isLinkError(err) {
+		r, ok := d.port.(reconnector)
+		if !ok || (d.ctx != nil && d.ctx.Err() != nil) {
+			return nil, linkError(cmd, err)
+		}
+		log.Printf("Потеряна связь с ККТ (%v). Переподключаюсь...", err)
+		if rerr := r.Reconnect(); rerr != nil {
+			return nil, linkError(cmd, fmt.Errorf("%v; переподключение не удалось: %w", err, rerr))
+		}
+		resp, err = d.conn.Exchange(cmd, data)
+	}
+	if err != nil {
+		if isLinkError(err) {
+			return nil, linkError(cmd, err)
+		}
+		return nil, err
+	}
+	if resp.Code != 0 {
+		e := newDeviceError(int32(resp.Code), "")
+		e.Command = cmd
+		return nil, e
+	}
+	return resp.Data, nil
+}
+
+// getBaseDeviceInfo собирает модель ККТ, дату прошивки и лицензии.
+func (d *nativeDriver) getBaseDeviceInfo(info *FiscalInfo) error {
+	// Команда 0xFC выполняется без пароля.
+	metrics, err := d.exchange(cmdGetDeviceMetrics, nil)
+	if err != nil {
+		return err
+	}
+	if len(metrics) > 6 {
+		info.ModelName = strings.TrimSpace(decodeCP1251(metrics[6:]))
+	}
+
+	status, err := d.command(cmdGetECRStatus)
+	if err != nil {
+		return err
+	}
+	if len(status) >= 8 {
+		if softDate, ok := parseDateDMY(status[5:8]); ok {
+			info.SoftwareDate = softDate.Format("2006-01-02")
+		}
+	}
+
+	// Лицензии поддерживаются не всеми прошивками, поэтому ошибка не критична.
+	licenses, err := d.command(cmdReadFeatureLicenses)
+	if err != nil {
+		log.Printf("Предупреждение: команда ReadFeatureLicenses не выполнена, информация о лицензиях недоступна.")
+		return nil
+	}
+	hexLicense := fmt.Sprintf("%X", licenses)
+	info.SubscriptionInfo = decodeLicense(hexLicense)
+	if info.SubscriptionInfo != "" {
+		log.Printf("Информация о лицензии успешно расшифрована: %s", info.SubscriptionInfo)
+	} else if hexLicense != "" {
+		log.Printf("Не удалось распознать формат полученной лицензии: %s", hexLicense)
+	}
+	return nil
+}
+
+// getFiscalizationInfo разбирает ответ на запрос итогов последней фискализации:
+// дата и время (5), ИНН (12), РНМ (20), системы налогообложения (1), режим работы (1),
+// номер ФД (4), ФП (4). Прошивки с ФФД 1.2 дополнительно передают код причины
+// перерегистрации (1) и расширенные признаки режима работы (1).
+func (d *nativeDriver) getFiscalizationInfo(info *FiscalInfo) error {
+	log.Println("Запрос данных последней фискализации (FNGetFiscalizationResult)...")
+	resp, err := d.command(cmdFNGetFiscalizationTotal)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 47 {
+		return fmt.Errorf("слишком короткий ответ на запрос итогов фискализации: %d байт", len(resp))
+	}
+	if regDate, ok := parseDateTimeYMDHM(resp[0:5]); ok {
+		info.RegistrationDate = regDate.Format("2006-01-02 15:04:05")
+	}
+	info.Inn = strings.TrimSpace(decodeCP1251(resp[5:17]))
+	info.RNM = strings.TrimSpace(decodeCP1251(resp[17:37]))
+
+	if len(resp) >= 49 {
+		workModeEx := resp[48]
+		info.AttributeMarked = (workModeEx & 0x10) != 0 // Бит 4 - признак торговли маркированными товарами
+		info.AttributeExcise = (workModeEx & 0x01) != 0 // Бит 0 - признак торговли подакцизными товарами
+	}
+	return nil
+}
+
+// getFnInfo собирает серийный номер, срок действия, исполнение, статус ФН,
+// состояние обмена с ОФД и ресурс памяти ФН.
+func (d *nativeDriver) getFnInfo(info *FiscalInfo) error {
+	log.Println("Запрос данных ФН...")
+	serialResp, err := d.command(cmdFNGetSerial)
+	if err != nil {
+		return err
+	}
+	info.FnSerial = strings.TrimSpace(decodeCP1251(serialResp))
+
+	expResp, err := d.command(cmdFNGetExpirationTime)
+	if err != nil {
+		return err
+	}
+	if len(expResp) >= 3 {
+		if fnEndDate, ok := parseDateYMD(expResp[0:3]); ok {
+			info.FnEndDate = fnEndDate.Format("2006-01-02 15:04:05")
+		}
+	}
+
+	implResp, err := d.command(cmdFNGetImplementation)
+	if err != nil {
+		return err
+	}
+	info.FnExecution = strings.TrimSpace(decodeCP1251(implResp))
+
+	statusResp, err := d.command(cmdFNGetStatus)
+	if err == nil {
+		info.FnStatus, err = parseFnStatus(statusResp)
+	}
+	if err != nil {
+		if errors.Is(err, CategoryTransport) {
+			return err
+		}
+		log.Printf("Предупреждение: статус ФН недоступен: %v", err)
+	}
+
+	ofdResp, err := d.command(cmdFNGetOfdStatus)
+	if err == nil {
+		info.OfdStatus, err = parseOfdStatus(ofdResp)
+	}
+	if err != nil {
+		if errors.Is(err, CategoryTransport) {
+			return err
+		}
+		log.Printf("Предупреждение: статус обмена с ОФД недоступен: %v", err)
+	}
+
+	// Ресурс памяти поддерживается не всеми ФН и прошивками.
+	memResp, err := d.command(cmdFNGetMemoryResource)
+	if err == nil {
+		info.FnMemory, err = parseFnMemory(memResp)
+	}
+	if err != nil {
+		if errors.Is(err, CategoryTransport) {
+			return err
+		}
+		log.Printf("Ресурс памяти ФН недоступен: %v", err)
+	}
+	return nil
+}
+
+// getInfoFromTables читает те же поля таблиц, что и comDriver.
+func (d *nativeDriver) getInfoFromTables(info *FiscalInfo) error {
+	log.Println("Чтение данных из таблиц ККТ...")
+	if sn, err := d.readTableField(18, 1, 1); err == nil {
+		info.SerialNumber = strings.TrimSpace(sn)
+	}
+	if orgName, err := d.readTableField(18, 1, 7); err == nil {
+		info.OrganizationName = strings.TrimSpace(orgName)
+	}
+	if ofdName, err := d.readTableField(18, 1, 10); err == nil {
+		info.OfdName = strings.TrimSpace(ofdName)
+	}
+	if address, err := d.readTableField(18, 1, 9); err == nil {
+		info.Address = strings.TrimSpace(address)
+	}
+
+	// Версия ФФД хранится в виде кода: 2 - "1.05", 4 - "1.2"
+	ffdValueStr, err := d.readTableField(17, 1, 17)
+	if err != nil {
+		info.FfdVersion = "не определена"
+	} else {
+		ffdValue, _ := strconv.Atoi(strings.TrimSpace(ffdValueStr))
+		switch ffdValue {
+		case 2:
+			info.FfdVersion = "105"
+		case 4:
+			info.FfdVersion = "120"
+		default:
+			info.FfdVersion = fmt.Sprintf("неизвестный код (%d)", ffdValue)
+		}
+	}
+	return nil
+}
+
+// GetTableStruct запрашивает структуру таблицы (команда 0x2D).
+// Ответ: название (40), число рядов (2), число полей (1).
+func (d *nativeDriver) GetTableStruct(table int) (*TableStruct, error) {
+	if !d.connected {
+		return nil, fmt.Errorf("драйвер не подключен")
+	}
+	resp, err := d.command(cmdGetTableStruct, byte(table))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 43 {
+		return nil, fmt.Errorf("слишком короткий ответ на запрос структуры таблицы: %d байт", len(resp))
+	}
+	return &TableStruct{
+		Number: table,
+		Name:   strings.TrimSpace(decodeCP1251(resp[0:40])),
+		Rows:   int(binary.LittleEndian.Uint16(resp[40:42])),
+		Fields: int(resp[42]),
+	}, nil
+}
+
+// GetFieldStruct запрашивает структуру поля (команда 0x2E) и кэширует ее на время
+// соединения. Ответ: название (40), тип (1: 0 - число, 1 - строка), размер (1),
+// для чисел - минимальное и максимальное значения по size байт.
+func (d *nativeDriver) GetFieldStruct(table, field int) (*FieldStruct, error) {
+	if !d.connected {
+		return nil, fmt.Errorf("драйвер не подключен")
+	}
+	key := [2]int{table, field}
+	if fs, ok := d.fields[key]; ok {
+		return fs, nil
+	}
+	resp, err := d.command(cmdGetFieldStruct, byte(table), byte(field))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 42 {
+		return nil, fmt.Errorf("слишком короткий ответ на запрос структуры поля: %d байт", len(resp))
+	}
+	fs := &FieldStruct{
+		Table:  table,
+		Number: field,
+		Name:   strings.TrimSpace(decodeCP1251(resp[0:40])),
+		Type:   FieldType(resp[40]),
+		Size:   int(resp[41]),
+	}
+	if fs.Type == FieldNumber && len(resp) >= 42+2*fs.Size {
+		fs.Min = leUint(resp[42:], fs.Size)
+		fs.Max = leUint(resp[42+fs.Size:], fs.Size)
+	}
+	d.fields[key] = fs
+	return fs, nil
+}
+
+// ReadTable читает поле таблицы (команда 0x1E) и разбирает значение по его структуре.
+func (d *nativeDriver) ReadTable(table, row, field int) (*TableValue, error) {
+	if !d.connected {
+		return nil, fmt.Errorf("драйвер не подключен")
+	}
+	fs, err := d.GetFieldStruct(table, field)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.command(cmdReadTable, byte(table), byte(row), byte(row>>8), byte(field))
+	if err != nil {
+		return nil, err
+	}
+	v := &TableValue{Field: *fs, Row: row}
+	if fs.Type == FieldString {
+		v.Str = decodeCP1251(resp)
+	} else {
+		v.Int = leUint(resp, fs.Size)
+	}
+	return v, nil
+}
+
+// readTableField читает поле таблицы и возвращает его значение в виде строки,
+// как это делает свойство ValueOfFieldString COM-драйвера.
+func (d *nativeDriver) readTableField(tableNum, rowNum, fieldNum int) (string, error) {
+	v, err := d.ReadTable(tableNum, rowNum, fieldNum)
+	if err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
+
+// leUint читает беззнаковое целое в порядке little-endian длиной не более size байт.
+func leUint(data []byte, size int) uint64 {
+	if size > len(data) {
+		size = len(data)
+	}
+	if size > 8 {
+		size = 8
+	}
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(data[i])
+	}
+	return v
+}
+
+// parseDateDMY разбирает дату в формате ДД ММ ГГ (команды ККТ).
+func parseDateDMY(b []byte) (time.Time, bool) {
+	return makeDate(int(b[2]), int(b[1]), int(b[0]), 0, 0)
+}
+
+// parseDateYMD разбирает дату в формате ГГ ММ ДД (команды ФН).
+func parseDateYMD(b []byte) (time.Time, bool) {
+	return makeDate(int(b[0]), int(b[1]), int(b[2]), 0, 0)
+}
+
+// parseDateTimeYMDHM разбирает дату и время в формате ГГ ММ ДД ЧЧ ММ (команды ФН).
+func parseDateTimeYMDHM(b []byte) (time.Time, bool) {
+	return makeDate(int(b[0]), int(b[1]), int(b[2]), int(b[3]), int(b[4]))
+}
+
+// makeDate собирает дату из двухзначного года и проверяет ее корректность.
+func makeDate(year, month, day, hour, minute int) (time.Time, bool) {
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 {
+		return time.Time{}, false
+	}
+	return time.Date(2000+year, time.Month(month), day, hour, minute, 0, 0, time.Local), true
+}
@@ -0,0 +1,118 @@
+// Тесты вспомогательных функций нативного протокола
+package shtrih
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCP1251RoundTrip проверяет перекодировку строк ККТ.
+func TestCP1251RoundTrip(t *testing.T) {
+	original := "ООО \"Ромашка\", ул. Ёлочная №5"
+	encoded := encodeCP1251(original)
+	if decoded := decodeCP1251(append(encoded, 0, 0, 0)); decoded != original {
+		t.Errorf("decodeCP1251(encodeCP1251(%q)) = %q", original, decoded)
+	}
+}
+
+// TestParseDates проверяет разбор дат в форматах ККТ и ФН.
+func TestParseDates(t *testing.T) {
+	if d, ok := parseDateDMY([]byte{20, 11, 23}); !ok || d.Format("2006-01-02") != "2023-11-20" {
+		t.Errorf("parseDateDMY() = %v, %v", d, ok)
+	}
+	if d, ok := parseDateTimeYMDHM([]byte{24, 3, 15, 10, 30}); !ok || d.Format("2006-01-02 15:04") != "2024-03-15 10:30" {
+		t.Errorf("parseDateTimeYMDHM() = %v, %v", d, ok)
+	}
+	if _, ok := parseDateYMD([]byte{0, 0, 0}); ok {
+		t.Error("parseDateYMD() принял нулевую дату.")
+	}
+}
+
+// TestParseFnStatus проверяет разбор ответа FF01h и расшифровку флагов предупреждения.
+func TestParseFnStatus(t *testing.T) {
+	resp := []byte{
+		0x07,              // Постфискальный режим
+		0x04,              // Открыт кассовый чек
+		0x01,              // Есть данные документа
+		0x00,              // Смена закрыта
+		0x0B,              // Срочная замена, исчерпание ресурса, таймаут ОФД
+		24, 3, 15, 10, 30, // Дата и время последнего ФД
+	}
+	resp = append(resp, []byte("9960440300112233")...)
+	resp = append(resp, 0x06, 0x06, 0x00, 0x00)
+
+	status, err := parseFnStatus(resp)
+	if err != nil {
+		t.Fatalf("parseFnStatus() вернул неожиданную ошибку: %v", err)
+	}
+	expected := FnStatus{
+		Phase:              FnPhasePostFiscal,
+		PhaseName:          "постфискальный режим",
+		LastDocumentNumber: 1542,
+		LastDocumentDate:   "2024-03-15 10:30:00",
+		DocumentOpen:       true,
+		OpenDocumentType:   0x04,
+		WarningFlags:       0x0B,
+		Warnings:           FnWarnings{UrgentReplacement: true, ResourceExhausted: true, OFDTimeout: true},
+	}
+	if *status != expected {
+		t.Errorf("parseFnStatus() = %+v, ожидается %+v", *status, expected)
+	}
+	if _, err := parseFnStatus(resp[:10]); err == nil {
+		t.Error("parseFnStatus() принял усеченный ответ.")
+	}
+}
+
+// TestFnPhase_String проверяет названия фаз по кодам из таблицы протокола ФН.
+func TestFnPhase_String(t *testing.T) {
+	tests := map[byte]string{
+		0x00: "настройка",
+		0x01: "готовность к фискализации",
+		0x03: "фискальный режим",
+		0x07: "постфискальный режим",
+		0x0F: "чтение данных из архива",
+		0x1F: "неизвестная фаза (0x1F)",
+	}
+	for code, expected := range tests {
+		if name := FnPhase(code).String(); name != expected {
+			t.Errorf("FnPhase(0x%02X).String() = %q, ожидается %q", code, name, expected)
+		}
+	}
+}
+
+// TestParseOfdStatus проверяет разбор ответа FF39h и расчет возраста очереди.
+func TestParseOfdStatus(t *testing.T) {
+	resp := []byte{
+		0x05,       // Соединение установлено, ожидание квитанции
+		0x00,       // Сообщение не читается
+		0x03, 0x00, // Три документа в очереди
+		0x05, 0x06, 0x00, 0x00, // Номер первого документа
+		24, 3, 15, 10, 30, // Дата и время первого документа
+	}
+	status, err := parseOfdStatus(resp)
+	if err != nil {
+		t.Fatalf("parseOfdStatus() вернул неожиданную ошибку: %v", err)
+	}
+	expected := OfdExchangeStatus{
+		StatusFlags:       0x05,
+		ConnectionActive:  true,
+		WaitingReceipt:    true,
+		UnsentCount:       3,
+		FirstUnsentNumber: 1541,
+		FirstUnsentDate:   "2024-03-15 10:30:00",
+	}
+	if *status != expected {
+		t.Errorf("parseOfdStatus() = %+v, ожидается %+v", *status, expected)
+	}
+
+	now := time.Date(2024, 4, 14, 11, 0, 0, 0, time.Local)
+	if days := status.UnsentDays(now); days != 30 {
+		t.Errorf("UnsentDays() = %d, ожидается 30", days)
+	}
+	if days := (&OfdExchangeStatus{}).UnsentDays(now); days != 0 {
+		t.Errorf("UnsentDays() для пустой очереди = %d, ожидается 0", days)
+	}
+	if first, ok := status.FirstUnsentTime(); !ok || !first.Equal(time.Date(2024, 3, 15, 10, 30, 0, 0, time.Local)) {
+		t.Errorf("FirstUnsentTime() = %v, %v, ожидается 2024-03-15 10:30:00", first, ok)
+	}
+}
@@ -0,0 +1,95 @@
+// Файл: pkg/shtrih/ofd.go
+package shtrih
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// OfdBlockingDays - через сколько дней после первого непереданного документа
+// ФН перестает формировать фискальные документы и ККТ блокируется.
+const OfdBlockingDays = 30
+
+// ofdStatusResponseLen - длина ответа на команду FF39h после кода ошибки.
+const ofdStatusResponseLen = 13
+
+// Биты статуса информационного обмена с ОФД.
+const (
+	ofdFlagConnected      = 0x01 // Транспортное соединение установлено
+	ofdFlagMessagePending = 0x02 // Есть сообщение для передачи в ОФД
+	ofdFlagWaitingReceipt = 0x04 // Ожидание квитанции от ОФД
+)
+
+// OfdExchangeStatus - состояние обмена ФН с ОФД по команде FF39h
+// "Получить статус информационного обмена".
+type OfdExchangeStatus struct {
+	StatusFlags       byte   `json:"status_flags"`          // Статус обмена как есть
+	ConnectionActive  bool   `json:"connection_active"`     // Транспортное соединение с ОФД установлено
+	MessagePending    bool   `json:"message_pending"`       // Есть сообщение для передачи в ОФД
+	WaitingReceipt    bool   `json:"waiting_receipt"`       // Ожидается квитанция от ОФД
+	ReadingMessage    bool   `json:"reading_message"`       // Идет чтение сообщения для ОФД
+	UnsentCount       int    `json:"unsent_count"`          // Количество непереданных документов
+	FirstUnsentNumber uint32 `json:"first_unsent_number"`   // Номер первого непереданного документа
+	FirstUnsentDate   string `json:"first_unsent_datetime"` // Дата и время первого непереданного документа
+}
+
+// UnsentDays возвращает, сколько полных дней первый непереданный документ
+// ожидает отправки на момент now. Если очередь пуста, возвращает 0.
+func (s *OfdExchangeStatus) UnsentDays(now time.Time) int {
+	first, ok := s.FirstUnsentTime()
+	if !ok || now.Before(first) {
+		return 0
+	}
+	return int(now.Sub(first).Hours() / 24)
+}
+
+// FirstUnsentTime возвращает дату и время первого непереданного документа.
+// Если очередь пуста или дата не разобрана, возвращает false.
+func (s *OfdExchangeStatus) FirstUnsentTime() (time.Time, bool) {
+	if s.UnsentCount == 0 || s.FirstUnsentDate == "" {
+		return time.Time{}, false
+	}
+	first, err := time.ParseInLocation("2006-01-02 15:04:05", s.FirstUnsentDate, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return first, true
+}
+
+// parseOfdStatus разбирает ответ на команду FF39h: статус обмена (1), состояние
+// чтения сообщения (1), количество сообщений (2), номер первого документа (4),
+// дата и время первого документа (5, ГГ ММ ДД чч мм).
+func parseOfdStatus(resp []byte) (*OfdExchangeStatus, error) {
+	if len(resp) < ofdStatusResponseLen {
+		return nil, fmt.Errorf("слишком короткий ответ на запрос статуса обмена с ОФД: %d байт", len(resp))
+	}
+	status := &OfdExchangeStatus{
+		StatusFlags:       resp[0],
+		ConnectionActive:  resp[0]&ofdFlagConnected != 0,
+		MessagePending:    resp[0]&ofdFlagMessagePending != 0,
+		WaitingReceipt:    resp[0]&ofdFlagWaitingReceipt != 0,
+		ReadingMessage:    resp[1] != 0,
+		UnsentCount:       int(binary.LittleEndian.Uint16(resp[2:4])),
+		FirstUnsentNumber: binary.LittleEndian.Uint32(resp[4:8]),
+	}
+	if t, ok := parseDateTimeYMDHM(resp[8:13]); ok && status.UnsentCount > 0 {
+		status.FirstUnsentDate = t.Format("2006-01-02 15:04:05")
+	}
+	return status, nil
+}
+
+// encodeOfdStatus формирует ответ на команду FF39h (используется эмулятором).
+func encodeOfdStatus(s *OfdExchangeStatus) []byte {
+	resp := make([]byte, ofdStatusResponseLen)
+	resp[0] = s.StatusFlags
+	if s.ReadingMessage {
+		resp[1] = 1
+	}
+	binary.LittleEndian.PutUint16(resp[2:4], uint16(s.UnsentCount))
+	binary.LittleEndian.PutUint32(resp[4:8], s.FirstUnsentNumber)
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s.FirstUnsentDate, time.Local); err == nil {
+		resp[8], resp[9], resp[10], resp[11], resp[12] = byte(t.Year()%100), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute())
+	}
+	return resp
+}
@@ -0,0 +1,212 @@
+// Файл: pkg/shtrih/protocol/conn.go
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNoConnection возвращается, если устройство не ответило на ENQ
+	// или не подтвердило прием кадра за заданное число попыток.
+	ErrNoConnection = errors.New("нет связи с ККТ")
+	// ErrTimeout возвращается при истечении таймаута чтения.
+	ErrTimeout = errors.New("таймаут ожидания ответа")
+)
+
+// Port описывает канал связи с ККТ. Семантика чтения повторяет go.bug.st/serial:
+// по истечении таймаута Read возвращает 0 байт без ошибки.
+type Port interface {
+	io.ReadWriter
+	// SetReadTimeout задает таймаут для последующих операций чтения.
+	SetReadTimeout(t time.Duration) error
+}
+
+// maxNoiseBytes - сколько посторонних байтов пропускается в ожидании STX.
+const maxNoiseBytes = 1024
+
+// Options задает параметры обмена.
+type Options struct {
+	// ByteTimeout - ожидание ответа на ENQ, подтверждения кадра и байтов внутри кадра.
+	ByteTimeout time.Duration
+	// AnswerTimeout - ожидание начала ответа на выполняемую команду.
+	AnswerTimeout time.Duration
+	// Retries - число повторов ENQ, передачи кадра и приема ответа с ошибкой LRC.
+	Retries int
+}
+
+// DefaultOptions возвращает параметры обмена по умолчанию.
+func DefaultOptions() Options {
+	return Options{
+		ByteTimeout:   100 * time.Millisecond,
+		AnswerTimeout: 5 * time.Second,
+		Retries:       10,
+	}
+}
+
+// withDefaults подставляет значения по умолчанию вместо незаданных.
+func (o Options) withDefaults() Options {
+	def := DefaultOptions()
+	if o.ByteTimeout <= 0 {
+		o.ByteTimeout = def.ByteTimeout
+	}
+	if o.AnswerTimeout <= 0 {
+		o.AnswerTimeout = def.AnswerTimeout
+	}
+	if o.Retries <= 0 {
+		o.Retries = def.Retries
+	}
+	return o
+}
+
+// Conn реализует сторону хоста: процедуру ENQ, передачу кадра с ожиданием ACK
+// и прием ответа с подтверждением или запросом повтора через NAK.
+type Conn struct {
+	port Port
+	opts Options
+}
+
+// NewConn создает канальный уровень поверх порта.
+func NewConn(p Port, opts Options) *Conn {
+	return &Conn{port: p, opts: opts.withDefaults()}
+}
+
+// readFull читает ровно len(buf) байт. Таймаут применяется к каждому чтению.
+func (c *Conn) readFull(buf []byte, timeout time.Duration) error {
+	if err := c.port.SetReadTimeout(timeout); err != nil {
+		return err
+	}
+	for read := 0; read < len(buf); {
+		n, err := c.port.Read(buf[read:])
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrTimeout
+		}
+		read += n
+	}
+	return nil
+}
+
+// readByte читает один байт с указанным таймаутом.
+func (c *Conn) readByte(timeout time.Duration) (byte, error) {
+	var buf [1]byte
+	if err := c.readFull(buf[:], timeout); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// timedReader позволяет использовать ReadBody с таймаутом на каждый байт.
+type timedReader struct {
+	c       *Conn
+	timeout time.Duration
+}
+
+func (r timedReader) Read(p []byte) (int, error) {
+	if err := r.c.readFull(p, r.timeout); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// receive ожидает кадр ответа, подтверждает его и при ошибке контрольной
+// суммы запрашивает повторную передачу через NAK.
+func (c *Conn) receive(timeout time.Duration) ([]byte, error) {
+	for attempt := 0; attempt < c.opts.Retries; attempt++ {
+		if err := c.skipToSTX(timeout); err != nil {
+			return nil, err
+		}
+		body, err := ReadBody(timedReader{c: c, timeout: c.opts.ByteTimeout})
+		if errors.Is(err, ErrChecksum) {
+			if _, err := c.port.Write([]byte{NAK}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.port.Write([]byte{ACK}); err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+	return nil, fmt.Errorf("не удалось получить корректный ответ после %d попыток", c.opts.Retries)
+}
+
+// skipToSTX пропускает посторонние байты (помехи на линии) до начала кадра.
+// Помехи не расходуют попытки приема, но их число ограничено maxNoiseBytes.
+func (c *Conn) skipToSTX(timeout time.Duration) error {
+	for skipped := 0; skipped < maxNoiseBytes; skipped++ {
+		b, err := c.readByte(timeout)
+		if err != nil {
+			return err
+		}
+		if b == STX {
+			return nil
+		}
+	}
+	return ErrNoSTX
+}
+
+// waitReady выполняет процедуру ENQ: NAK означает готовность к приему команды,
+// ACK - наличие ответа на предыдущую команду, который вычитывается и отбрасывается.
+func (c *Conn) waitReady() error {
+	for attempt := 0; attempt < c.opts.Retries; attempt++ {
+		if _, err := c.port.Write([]byte{ENQ}); err != nil {
+			return err
+		}
+		b, err := c.readByte(c.opts.ByteTimeout)
+		if err != nil {
+			if errors.Is(err, ErrTimeout) {
+				continue
+			}
+			return err
+		}
+		switch b {
+		case NAK:
+			return nil
+		case ACK:
+			if _, err := c.receive(c.opts.AnswerTimeout); err != nil && !errors.Is(err, ErrTimeout) {
+				return err
+			}
+		}
+	}
+	return ErrNoConnection
+}
+
+// Exchange отправляет команду и возвращает ответ ККТ. Ошибкой считаются
+// только сбои канала; код ошибки ККТ возвращается в Response.Code.
+func (c *Conn) Exchange(cmd uint16, data []byte) (Response, error) {
+	frame, err := Encode(cmd, data)
+	if err != nil {
+		return Response{}, err
+	}
+	if err := c.waitReady(); err != nil {
+		return Response{}, err
+	}
+	sent := false
+	for attempt := 0; attempt < c.opts.Retries && !sent; attempt++ {
+		if _, err := c.port.Write(frame); err != nil {
+			return Response{}, err
+		}
+		b, err := c.readByte(c.opts.ByteTimeout)
+		if err != nil && !errors.Is(err, ErrTimeout) {
+			return Response{}, err
+		}
+		sent = err == nil && b == ACK
+	}
+	if !sent {
+		return Response{}, ErrNoConnection
+	}
+
+	body, err := c.receive(c.opts.AnswerTimeout)
+	if err != nil {
+		return Response{}, err
+	}
+	return ParseResponse(cmd, body)
+}
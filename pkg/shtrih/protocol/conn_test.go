@@ -0,0 +1,184 @@
+// Тесты обмена ENQ/ACK/NAK со стороны хоста
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// scriptedPort имитирует порт ККТ: отдает заранее подготовленные байты
+// и записывает все, что отправил хост.
+type scriptedPort struct {
+	toRead  []byte
+	written bytes.Buffer
+}
+
+func (p *scriptedPort) Read(b []byte) (int, error) {
+	if len(p.toRead) == 0 {
+		return 0, nil // Таймаут, как у go.bug.st/serial.
+	}
+	n := copy(b, p.toRead)
+	p.toRead = p.toRead[n:]
+	return n, nil
+}
+
+func (p *scriptedPort) Write(b []byte) (int, error)          { return p.written.Write(b) }
+func (p *scriptedPort) SetReadTimeout(t time.Duration) error { return nil }
+
+// concat склеивает последовательности байтов.
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// TestConnExchange проверяет полный цикл обмена: ENQ -> NAK, кадр -> ACK, ответ -> ACK.
+func TestConnExchange(t *testing.T) {
+	password := []byte{0x1E, 0, 0, 0}
+	response := mustEncode(t, 0xFF02, append([]byte{0x00}, "9960440300112233"...))
+	p := &scriptedPort{toRead: concat([]byte{NAK, ACK}, response)}
+
+	resp, err := NewConn(p, Options{}).Exchange(0xFF02, password)
+	if err != nil {
+		t.Fatalf("Exchange() вернул неожиданную ошибку: %v", err)
+	}
+	if resp.Code != 0 || string(resp.Data) != "9960440300112233" {
+		t.Errorf("Получен ответ %+v", resp)
+	}
+	expected := concat([]byte{ENQ}, mustEncode(t, 0xFF02, password), []byte{ACK})
+	if !bytes.Equal(p.written.Bytes(), expected) {
+		t.Errorf("Хост отправил % X, ожидалось % X", p.written.Bytes(), expected)
+	}
+}
+
+// TestConnExchange_DeviceErrorCode проверяет, что код ошибки ККТ не считается сбоем канала.
+func TestConnExchange_DeviceErrorCode(t *testing.T) {
+	p := &scriptedPort{toRead: concat([]byte{NAK, ACK}, mustEncode(t, 0x11, []byte{0x4F}))}
+	resp, err := NewConn(p, Options{}).Exchange(0x11, []byte{0, 0, 0, 0})
+	if err != nil {
+		t.Fatalf("Exchange() вернул неожиданную ошибку: %v", err)
+	}
+	if resp.Code != 0x4F {
+		t.Errorf("Код ошибки = 0x%X, ожидается 0x4F", resp.Code)
+	}
+}
+
+// TestConnExchange_ChecksumRetry проверяет запрос повтора (NAK) при неверной LRC ответа.
+func TestConnExchange_ChecksumRetry(t *testing.T) {
+	good := mustEncode(t, 0x11, []byte{0x00})
+	bad := append([]byte(nil), good...)
+	bad[len(bad)-1] ^= 0xFF
+	p := &scriptedPort{toRead: concat([]byte{NAK, ACK}, bad, good)}
+
+	if _, err := NewConn(p, Options{}).Exchange(0x11, nil); err != nil {
+		t.Fatalf("Exchange() вернул неожиданную ошибку: %v", err)
+	}
+	expected := concat([]byte{ENQ}, mustEncode(t, 0x11, nil), []byte{NAK, ACK})
+	if !bytes.Equal(p.written.Bytes(), expected) {
+		t.Errorf("Хост отправил % X, ожидалось % X", p.written.Bytes(), expected)
+	}
+}
+
+// TestConnExchange_ResendUntilAck проверяет повтор кадра, если ККТ ответила NAK.
+func TestConnExchange_ResendUntilAck(t *testing.T) {
+	p := &scriptedPort{toRead: concat([]byte{NAK, NAK, ACK}, mustEncode(t, 0x11, []byte{0x00}))}
+	if _, err := NewConn(p, Options{}).Exchange(0x11, nil); err != nil {
+		t.Fatalf("Exchange() вернул неожиданную ошибку: %v", err)
+	}
+	frame := mustEncode(t, 0x11, nil)
+	expected := concat([]byte{ENQ}, frame, frame, []byte{ACK})
+	if !bytes.Equal(p.written.Bytes(), expected) {
+		t.Errorf("Хост отправил % X, ожидалось % X", p.written.Bytes(), expected)
+	}
+}
+
+// TestConnExchange_PendingAnswer проверяет, что старый ответ (ACK на ENQ)
+// вычитывается перед отправкой новой команды.
+func TestConnExchange_PendingAnswer(t *testing.T) {
+	stale := mustEncode(t, 0x10, []byte{0x00})
+	fresh := mustEncode(t, 0x11, []byte{0x00, 0x2A})
+	p := &scriptedPort{toRead: concat([]byte{ACK}, stale, []byte{NAK, ACK}, fresh)}
+
+	resp, err := NewConn(p, Options{}).Exchange(0x11, nil)
+	if err != nil {
+		t.Fatalf("Exchange() вернул неожиданную ошибку: %v", err)
+	}
+	if !bytes.Equal(resp.Data, []byte{0x2A}) {
+		t.Errorf("Получены данные % X, ожидалось 2A", resp.Data)
+	}
+}
+
+// TestConnExchange_NoiseBeforeAnswer проверяет, что помехи перед ответом
+// пропускаются и не расходуют попытки приема.
+func TestConnExchange_NoiseBeforeAnswer(t *testing.T) {
+	noise := bytes.Repeat([]byte{0xFF, 0x00}, 10)
+	p := &scriptedPort{toRead: concat([]byte{NAK, ACK}, noise, mustEncode(t, 0x11, []byte{0x00, 0x2A}))}
+
+	resp, err := NewConn(p, Options{Retries: 3}).Exchange(0x11, nil)
+	if err != nil {
+		t.Fatalf("Exchange() вернул неожиданную ошибку: %v", err)
+	}
+	if !bytes.Equal(resp.Data, []byte{0x2A}) {
+		t.Errorf("Получены данные % X, ожидалось 2A", resp.Data)
+	}
+	if written := p.written.Bytes(); bytes.Contains(written[1:], []byte{NAK}) {
+		t.Errorf("Помехи не должны вызывать NAK, хост отправил % X", written)
+	}
+}
+
+// TestConnExchange_NoConnection проверяет число попыток ENQ при молчащем устройстве.
+func TestConnExchange_NoConnection(t *testing.T) {
+	p := &scriptedPort{}
+	_, err := NewConn(p, Options{ByteTimeout: time.Millisecond, Retries: 3}).Exchange(0x11, nil)
+	if err != ErrNoConnection {
+		t.Errorf("Exchange() вернул %v, ожидалось %v", err, ErrNoConnection)
+	}
+	if !bytes.Equal(p.written.Bytes(), []byte{ENQ, ENQ, ENQ}) {
+		t.Errorf("Хост отправил % X, ожидалось 3 ENQ", p.written.Bytes())
+	}
+}
+
+// pipePort адаптирует net.Conn к интерфейсу Port через дедлайны.
+type pipePort struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (p *pipePort) SetReadTimeout(t time.Duration) error {
+	p.timeout = t
+	return nil
+}
+
+func (p *pipePort) Read(b []byte) (int, error) {
+	p.Conn.SetReadDeadline(time.Now().Add(p.timeout))
+	n, err := p.Conn.Read(b)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return n, nil
+	}
+	return n, err
+}
+
+// TestServe_WithConn проверяет совместную работу сторон хоста и устройства.
+func TestServe_WithConn(t *testing.T) {
+	hostSide, deviceSide := net.Pipe()
+	defer hostSide.Close()
+	go func() {
+		defer deviceSide.Close()
+		Serve(deviceSide, func(cmd uint16, data []byte) (byte, []byte) {
+			if cmd == 0xFF02 {
+				return 0, []byte("9960440300112233")
+			}
+			return 0x37, nil
+		})
+	}()
+
+	conn := NewConn(&pipePort{Conn: hostSide}, Options{ByteTimeout: time.Second})
+	resp, err := conn.Exchange(0xFF02, []byte{0x1E, 0, 0, 0})
+	if err != nil || string(resp.Data) != "9960440300112233" {
+		t.Fatalf("Exchange(0xFF02) = %+v, %v", resp, err)
+	}
+	resp, err = conn.Exchange(0x2D, []byte{0x1E, 0, 0, 0, 1})
+	if err != nil || resp.Code != 0x37 {
+		t.Fatalf("Exchange(0x2D) = %+v, %v; ожидался код 0x37", resp, err)
+	}
+}
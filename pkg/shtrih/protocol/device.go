@@ -0,0 +1,64 @@
+// Файл: pkg/shtrih/protocol/device.go
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Handler обрабатывает команду на стороне устройства и возвращает код
+// ошибки и данные ответа.
+type Handler func(cmd uint16, data []byte) (code byte, resp []byte)
+
+// Serve реализует сторону устройства: отвечает NAK на ENQ, подтверждает
+// корректные кадры, повторяет неподтвержденный ответ по NAK или ENQ.
+// Работает до закрытия канала.
+func Serve(rw io.ReadWriter, h Handler) error {
+	var pending []byte // Последний отправленный и не подтвержденный ответ.
+	buf := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(rw, buf); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		switch buf[0] {
+		case ENQ:
+			if pending != nil {
+				// Хост не получил ответ - сообщаем о его наличии и повторяем.
+				rw.Write([]byte{ACK})
+				rw.Write(pending)
+			} else {
+				rw.Write([]byte{NAK})
+			}
+		case ACK:
+			pending = nil
+		case NAK:
+			if pending != nil {
+				rw.Write(pending)
+			}
+		case STX:
+			body, err := ReadBody(rw)
+			if errors.Is(err, ErrChecksum) {
+				rw.Write([]byte{NAK})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			rw.Write([]byte{ACK})
+			f, err := DecodeBody(body)
+			if err != nil {
+				continue
+			}
+			code, resp := h(f.Command, f.Data)
+			if pending, err = Encode(f.Command, append([]byte{code}, resp...)); err != nil {
+				return fmt.Errorf("ответ на команду 0x%X: %w", f.Command, err)
+			}
+			rw.Write(pending)
+		}
+	}
+}
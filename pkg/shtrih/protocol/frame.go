@@ -0,0 +1,143 @@
+// Package protocol реализует канальный уровень протокола ККТ "Штрих-М":
+// кодирование и разбор кадров (STX, длина, код команды, данные, LRC)
+// и обмен ENQ/ACK/NAK с повторной передачей. Пакет используется нативным
+// драйвером со стороны хоста и эмулятором со стороны устройства.
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Управляющие байты протокола.
+const (
+	STX byte = 0x02 // Начало сообщения
+	ENQ byte = 0x05 // Запрос состояния
+	ACK byte = 0x06 // Подтверждение
+	NAK byte = 0x15 // Отрицательное подтверждение
+)
+
+// fnPrefix - первый байт двухбайтовых команд ФН (0xFF01, 0xFF02, ...).
+const fnPrefix byte = 0xFF
+
+var (
+	// ErrChecksum возвращается, если LRC кадра не совпадает с вычисленным.
+	ErrChecksum = errors.New("неверная контрольная сумма кадра")
+	// ErrShortFrame возвращается для кадров, в которых не хватает байтов.
+	ErrShortFrame = errors.New("кадр слишком короткий")
+	// ErrNoSTX возвращается, если кадр не начинается с STX.
+	ErrNoSTX = errors.New("кадр не начинается с STX")
+	// ErrFrameTooLong возвращается, если код команды и данные не помещаются
+	// в тело кадра (255 байт).
+	ErrFrameTooLong = errors.New("тело кадра длиннее 255 байт")
+)
+
+// Frame - разобранный кадр: код команды и данные. Для ответа ККТ первый
+// байт Data содержит код ошибки.
+type Frame struct {
+	Command uint16
+	Data    []byte
+}
+
+// CommandBytes возвращает байты кода команды. Команды ФН имеют
+// двухбайтовый код с префиксом 0xFF.
+func CommandBytes(cmd uint16) []byte {
+	if cmd > 0xFF {
+		return []byte{byte(cmd >> 8), byte(cmd)}
+	}
+	return []byte{byte(cmd)}
+}
+
+// LRC вычисляет контрольную сумму (XOR всех байтов).
+func LRC(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum ^= b
+	}
+	return sum
+}
+
+// Encode формирует кадр: STX, длина, код команды, данные, LRC.
+// Если тело кадра длиннее 255 байт, возвращает ErrFrameTooLong.
+func Encode(cmd uint16, data []byte) ([]byte, error) {
+	body := append(CommandBytes(cmd), data...)
+	if len(body) > 0xFF {
+		return nil, ErrFrameTooLong
+	}
+	frame := make([]byte, 0, len(body)+3)
+	frame = append(frame, STX, byte(len(body)))
+	frame = append(frame, body...)
+	return append(frame, LRC(frame[1:])), nil
+}
+
+// Decode разбирает полный кадр, начиная с STX.
+func Decode(frame []byte) (Frame, error) {
+	if len(frame) == 0 {
+		return Frame{}, ErrShortFrame
+	}
+	if frame[0] != STX {
+		return Frame{}, ErrNoSTX
+	}
+	if len(frame) < 2 || len(frame) != int(frame[1])+3 {
+		return Frame{}, ErrShortFrame
+	}
+	if LRC(frame[1:len(frame)-1]) != frame[len(frame)-1] {
+		return Frame{}, ErrChecksum
+	}
+	return DecodeBody(frame[2 : len(frame)-1])
+}
+
+// DecodeBody отделяет код команды от данных в теле кадра.
+func DecodeBody(body []byte) (Frame, error) {
+	if len(body) == 0 {
+		return Frame{}, ErrShortFrame
+	}
+	if body[0] == fnPrefix {
+		if len(body) < 2 {
+			return Frame{}, ErrShortFrame
+		}
+		return Frame{Command: uint16(fnPrefix)<<8 | uint16(body[1]), Data: body[2:]}, nil
+	}
+	return Frame{Command: uint16(body[0]), Data: body[1:]}, nil
+}
+
+// ReadBody читает длину, тело и LRC кадра из потока (STX уже прочитан).
+// При несовпадении контрольной суммы возвращает тело вместе с ErrChecksum.
+func ReadBody(r io.Reader) ([]byte, error) {
+	var length [1]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	rest := make([]byte, int(length[0])+1)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	body := rest[:length[0]]
+	if LRC(append([]byte{length[0]}, body...)) != rest[length[0]] {
+		return body, ErrChecksum
+	}
+	return body, nil
+}
+
+// Response - ответ ККТ на команду.
+type Response struct {
+	Command uint16
+	Code    byte   // Код ошибки ККТ, 0 - успех
+	Data    []byte // Данные ответа после кода ошибки
+}
+
+// ParseResponse разбирает тело кадра ответа и проверяет, что он относится к команде cmd.
+func ParseResponse(cmd uint16, body []byte) (Response, error) {
+	f, err := DecodeBody(body)
+	if err != nil {
+		return Response{}, err
+	}
+	if f.Command != cmd {
+		return Response{}, fmt.Errorf("ответ на команду 0x%X вместо 0x%X", f.Command, cmd)
+	}
+	if len(f.Data) == 0 {
+		return Response{}, ErrShortFrame
+	}
+	return Response{Command: cmd, Code: f.Data[0], Data: f.Data[1:]}, nil
+}
@@ -0,0 +1,120 @@
+// Тесты кодирования и разбора кадров
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// goldenFrame - эталонный вектор: команда, данные и байты кадра в HEX.
+type goldenFrame struct {
+	Name    string `json:"name"`
+	Command uint16 `json:"command"`
+	Data    string `json:"data"`
+	Frame   string `json:"frame"`
+}
+
+// loadGoldenFrames читает эталонные векторы из testdata.
+func loadGoldenFrames(t *testing.T) []goldenFrame {
+	t.Helper()
+	data, err := os.ReadFile("testdata/golden_frames.json")
+	if err != nil {
+		t.Fatalf("Не удалось прочитать эталонные кадры: %v", err)
+	}
+	var frames []goldenFrame
+	if err := json.Unmarshal(data, &frames); err != nil {
+		t.Fatalf("Не удалось распарсить эталонные кадры: %v", err)
+	}
+	return frames
+}
+
+// mustEncode кодирует кадр и прерывает тест при ошибке.
+func mustEncode(t testing.TB, cmd uint16, data []byte) []byte {
+	t.Helper()
+	frame, err := Encode(cmd, data)
+	if err != nil {
+		t.Fatalf("Encode(0x%X) вернул ошибку: %v", cmd, err)
+	}
+	return frame
+}
+
+// TestEncode_Golden проверяет кодирование по эталонным векторам.
+func TestEncode_Golden(t *testing.T) {
+	for _, g := range loadGoldenFrames(t) {
+		t.Run(g.Name, func(t *testing.T) {
+			data, _ := hex.DecodeString(g.Data)
+			expected, _ := hex.DecodeString(g.Frame)
+			if got, err := Encode(g.Command, data); err != nil || !bytes.Equal(got, expected) {
+				t.Errorf("Encode(0x%X, % X) = % X, ожидается % X", g.Command, data, got, expected)
+			}
+		})
+	}
+}
+
+// TestEncode_TooLong проверяет, что тело длиннее 255 байт не обрезается,
+// а возвращается ошибка.
+func TestEncode_TooLong(t *testing.T) {
+	if frame, err := Encode(0x11, make([]byte, 254)); err != nil || len(frame) != 258 {
+		t.Fatalf("Кадр с телом 255 байт: длина %d, ошибка %v", len(frame), err)
+	}
+	if frame, err := Encode(0x11, make([]byte, 255)); !errors.Is(err, ErrFrameTooLong) || frame != nil {
+		t.Errorf("Encode() с телом 256 байт = % X, %v, ожидалась ErrFrameTooLong", frame, err)
+	}
+	if _, err := Encode(0xFF01, make([]byte, 254)); !errors.Is(err, ErrFrameTooLong) {
+		t.Errorf("Двухбайтовая команда с 254 байтами данных: ожидалась ErrFrameTooLong, получено %v", err)
+	}
+}
+
+// TestDecode_Golden проверяет разбор эталонных кадров.
+func TestDecode_Golden(t *testing.T) {
+	for _, g := range loadGoldenFrames(t) {
+		t.Run(g.Name, func(t *testing.T) {
+			frame, _ := hex.DecodeString(g.Frame)
+			data, _ := hex.DecodeString(g.Data)
+			f, err := Decode(frame)
+			if err != nil {
+				t.Fatalf("Decode(% X) вернул ошибку: %v", frame, err)
+			}
+			if f.Command != g.Command || !bytes.Equal(f.Data, data) {
+				t.Errorf("Decode(% X) = {0x%X, % X}, ожидается {0x%X, % X}", frame, f.Command, f.Data, g.Command, data)
+			}
+		})
+	}
+}
+
+// TestDecode_Errors проверяет отказ от поврежденных кадров.
+func TestDecode_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		frame    []byte
+		expected error
+	}{
+		{"Пустой кадр", nil, ErrShortFrame},
+		{"Нет STX", []byte{0x05, 0x01, 0x11, 0x10}, ErrNoSTX},
+		{"Длина больше данных", []byte{0x02, 0x05, 0x11, 0x14}, ErrShortFrame},
+		{"Неверная LRC", []byte{0x02, 0x01, 0x11, 0x00}, ErrChecksum},
+		{"Команда ФН без второго байта", []byte{0x02, 0x01, 0xFF, 0xFE}, ErrShortFrame},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.frame); err != tt.expected {
+				t.Errorf("Decode(% X) вернул %v, ожидается %v", tt.frame, err, tt.expected)
+			}
+		})
+	}
+}
+
+// TestParseResponse проверяет отделение кода ошибки и проверку кода команды.
+func TestParseResponse(t *testing.T) {
+	resp, err := ParseResponse(0xFF02, []byte{0xFF, 0x02, 0x00, '9', '9'})
+	if err != nil || resp.Code != 0 || string(resp.Data) != "99" {
+		t.Errorf("ParseResponse() = %+v, %v", resp, err)
+	}
+	if _, err := ParseResponse(0x11, []byte{0x10, 0x00}); err == nil {
+		t.Error("ParseResponse() принял ответ на другую команду.")
+	}
+}
@@ -0,0 +1,76 @@
+//go:build go1.18
+// +build go1.18
+
+// Fuzz-тесты кодека кадров
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// addGoldenSeeds добавляет эталонные кадры в корпус fuzz-теста.
+func addGoldenSeeds(f *testing.F) {
+	data, err := os.ReadFile("testdata/golden_frames.json")
+	if err != nil {
+		f.Fatalf("Не удалось прочитать эталонные кадры: %v", err)
+	}
+	var frames []goldenFrame
+	if err := json.Unmarshal(data, &frames); err != nil {
+		f.Fatalf("Не удалось распарсить эталонные кадры: %v", err)
+	}
+	for _, g := range frames {
+		frame, _ := hex.DecodeString(g.Frame)
+		f.Add(frame)
+	}
+}
+
+// FuzzDecode проверяет, что разбор произвольных байтов не паникует, а любой
+// успешно разобранный кадр кодируется обратно в те же байты.
+func FuzzDecode(f *testing.F) {
+	addGoldenSeeds(f)
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		decoded, err := Decode(frame)
+		if err != nil {
+			return
+		}
+		if encoded, err := Encode(decoded.Command, decoded.Data); err != nil || !bytes.Equal(encoded, frame) {
+			t.Errorf("Encode(Decode(% X)) = % X", frame, encoded)
+		}
+	})
+}
+
+// FuzzEncode проверяет, что закодированный кадр всегда разбирается обратно.
+func FuzzEncode(f *testing.F) {
+	f.Add(uint16(0x11), []byte{0x1E, 0, 0, 0})
+	f.Add(uint16(0xFF01), []byte{})
+	f.Fuzz(func(t *testing.T, cmd uint16, data []byte) {
+		if cmd > 0xFF && cmd>>8 != uint16(fnPrefix) {
+			t.Skip("двухбайтовые команды существуют только с префиксом 0xFF")
+		}
+		if cmd == uint16(fnPrefix) {
+			t.Skip("0xFF без второго байта не является командой")
+		}
+		frame, err := Encode(cmd, data)
+		if len(data)+len(CommandBytes(cmd)) > 0xFF {
+			if !errors.Is(err, ErrFrameTooLong) {
+				t.Fatalf("Encode(0x%X, %d байт) = %v, ожидалась ErrFrameTooLong", cmd, len(data), err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Encode(0x%X, % X) вернул ошибку: %v", cmd, data, err)
+		}
+		decoded, err := Decode(frame)
+		if err != nil {
+			t.Fatalf("Decode(Encode(0x%X, % X)) вернул ошибку: %v", cmd, data, err)
+		}
+		if decoded.Command != cmd || !bytes.Equal(decoded.Data, data) {
+			t.Errorf("Decode(Encode(0x%X, % X)) = {0x%X, % X}", cmd, data, decoded.Command, decoded.Data)
+		}
+	})
+}
@@ -0,0 +1,614 @@
+// Файл: pkg/shtrih/search.go
+package shtrih
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+	"go.bug.st/serial/enumerator"
+)
+
+// Параметры поиска. Вынесены в переменные пакета, чтобы тесты могли
+// направить поиск на эмулятор вместо реальных портов и подсетей.
+var (
+	// rndisSubnets - стандартные подсети RNDIS, если подсети не удалось определить по интерфейсам.
+	rndisSubnets       = []string{"192.168.137.0/24", "192.168.138.0/24"}
+	rndisPort    int32 = 7778 // Стандартный порт для Штрих-М.
+	// comProbeAvailable определяет, проверять ли COM-порты через COM-драйвер.
+	// 32-битный COM-драйвер доступен только на Windows/386, на остальных
+	// платформах порты проверяются нативным протоколом.
+	comProbeAvailable = runtime.GOOS == "windows" && runtime.GOARCH == "386"
+)
+
+// Значения параметров поиска по умолчанию.
+const (
+	defaultSearchPassword int32 = 30
+	defaultNetworkWorkers       = 50
+	// maxSubnetHosts ограничивает число адресов в одной подсети поиска (/20).
+	maxSubnetHosts = 4094
+)
+
+// SearchOptions задает параметры поиска устройств.
+type SearchOptions struct {
+	// ComTimeout и TCPTimeout - таймауты проверки одного COM-порта и одного IP-адреса.
+	ComTimeout time.Duration
+	TCPTimeout time.Duration
+	// ComParallelism - сколько COM-портов проверяется одновременно.
+	ComParallelism int
+	// ExpectedDevices - после того как найдено столько устройств, поиск
+	// прекращается. 0 - искать на всех портах и адресах.
+	ExpectedDevices int
+	// BaudRates - скорости COM-портов (бод) в порядке проверки.
+	BaudRates []int
+	// Subnets - подсети для поиска по TCP в нотации CIDR, например "10.0.5.0/24".
+	// Если не заданы, строятся по сетевым интерфейсам хоста (см. localSubnets),
+	// а если и там ничего не найдено - берутся стандартные подсети RNDIS.
+	Subnets []string
+	// MaxHosts ограничивает общее число адресов в подсетях, найденных по интерфейсам.
+	MaxHosts int
+	// TCPPort - TCP-порт ККТ.
+	TCPPort int32
+	// Password - пароль, с которым проверяется связь с ККТ.
+	Password int32
+	// Passwords - пароли, которые пробуются по очереди, если ККТ отклонила Password.
+	Passwords []int32
+	// NetworkWorkers - сколько IP-адресов проверяется одновременно.
+	NetworkWorkers int
+	// PreferredUSB - USB-устройства ("VID" или "VID:PID"), порты которых
+	// проверяются первыми. Порты известных USB-COM адаптеров проверяются
+	// первыми и без этой настройки.
+	PreferredUSB []string
+	// KnownUSB - USB-идентификация портов, на которых ККТ были найдены раньше.
+	// Совпадающие порты (см. USBIdentity.Matches) проверяются раньше всех,
+	// поэтому две ККТ одной модели различаются по серийному номеру адаптера.
+	KnownUSB []*USBIdentity
+	// SkipAddresses - COM-порты и адреса "IP:порт", которые не проверяются,
+	// например адреса ККТ, уже ответивших при опросе.
+	SkipAddresses []string
+	// BlacklistUSB - USB-устройства ("VID" или "VID:PID"), порты которых не проверяются.
+	// Порты Bluetooth, модемов и сканеров штрихкода пропускаются всегда.
+	BlacklistUSB []string
+}
+
+// DefaultSearchOptions возвращает параметры поиска по умолчанию.
+func DefaultSearchOptions() SearchOptions {
+	return SearchOptions{
+		ComTimeout:     200 * time.Millisecond,
+		TCPTimeout:     200 * time.Millisecond,
+		ComParallelism: 4,
+		BaudRates:      []int{115200, 4800},
+		MaxHosts:       defaultMaxHosts,
+		TCPPort:        rndisPort,
+		Password:       defaultSearchPassword,
+		NetworkWorkers: defaultNetworkWorkers,
+	}
+}
+
+// SearchAuthError возвращается поиском вместе с найденными устройствами, если
+// часть ККТ ответила, но отклонила все пароли из SearchOptions. Такие ККТ
+// не попадают в список найденных, так как опросить их нельзя.
+type SearchAuthError struct {
+	Devices []Config
+}
+
+func (e *SearchAuthError) Error() string {
+	addrs := make([]string, len(e.Devices))
+	for i, c := range e.Devices {
+		addrs[i] = configAddress(c)
+	}
+	return fmt.Sprintf("ККТ отклонили все пароли: %s", strings.Join(addrs, ", "))
+}
+
+// Is позволяет проверить ошибку через errors.Is(err, CategoryPassword).
+func (e *SearchAuthError) Is(target error) bool {
+	return target == CategoryPassword
+}
+
+// configAddress возвращает порт или адрес ККТ для сообщений.
+func configAddress(c Config) string {
+	if c.ConnectionType == 0 {
+		return c.ComName
+	}
+	return net.JoinHostPort(c.IPAddress, strconv.Itoa(int(c.TCPPort)))
+}
+
+// skipped сообщает, что порт или адрес address есть в opts.SkipAddresses.
+func skipped(address string, opts SearchOptions) bool {
+	for _, a := range opts.SkipAddresses {
+		if strings.EqualFold(a, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeResult - ККТ, ответившая при поиске. denied означает, что ККТ
+// отклонила все пароли из параметров поиска.
+type probeResult struct {
+	config Config
+	denied bool
+}
+
+// SearchPasswords возвращает пароли в порядке проверки: opts.Password,
+// затем opts.Passwords без повторов.
+func SearchPasswords(opts SearchOptions) []int32 {
+	passwords := []int32{opts.Password}
+	for _, p := range opts.Passwords {
+		dup := false
+		for _, q := range passwords {
+			dup = dup || p == q
+		}
+		if !dup {
+			passwords = append(passwords, p)
+		}
+	}
+	return passwords
+}
+
+// SearchDevices выполняет двухэтапный поиск ККТ: сначала на COM-портах,
+// затем в IP-подсетях сетевых интерфейсов хоста.
+func SearchDevices(comTimeout, tcpTimeout time.Duration) ([]Config, error) {
+	return SearchDevicesContext(context.Background(), comTimeout, tcpTimeout)
+}
+
+// SearchDevicesContext выполняет поиск так же, как SearchDevices, но прекращает
+// его при отмене ctx. В этом случае возвращаются устройства, найденные к этому
+// моменту, и ошибка с этапом поиска, на котором истекло время.
+func SearchDevicesContext(ctx context.Context, comTimeout, tcpTimeout time.Duration) ([]Config, error) {
+	opts := DefaultSearchOptions()
+	opts.ComTimeout, opts.TCPTimeout = comTimeout, tcpTimeout
+	return SearchDevicesWithOptions(ctx, opts)
+}
+
+// SearchDevicesWithOptions выполняет поиск с параметрами opts. COM-порты
+// проверяются параллельно, каждый в своем потоке ОС. Когда найдено
+// opts.ExpectedDevices устройств, оставшиеся проверки отменяются.
+// Некорректные скорости и подсети пропускаются с записью в лог.
+// ККТ, отклонившие все пароли, возвращаются в *SearchAuthError, если поиск
+// не был прерван.
+func SearchDevicesWithOptions(ctx context.Context, opts SearchOptions) ([]Config, error) {
+	// searchCtx отменяется и при отмене ctx, и при досрочном завершении поиска.
+	searchCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	enough := func(found int) bool {
+		return opts.ExpectedDevices > 0 && found >= opts.ExpectedDevices
+	}
+
+	// Этап 1: Параллельный поиск на COM-портах.
+	log.Println("--- Начинаю поиск устройств на COM-портах ---")
+	var foundDevices, deniedDevices []Config
+	ports, err := listPorts()
+	if err != nil {
+		log.Printf("Не удалось получить список COM-портов: %v", err)
+	} else if ports = orderPorts(ports, opts); len(ports) == 0 {
+		log.Println("В системе не найдено COM-портов.")
+	} else {
+		log.Printf("Найдены COM-порты: %v. Начинаю проверку...", portNames(ports))
+		foundDevices, deniedDevices = scanComPorts(searchCtx, ports, opts, func(found int) {
+			if enough(found) {
+				stop()
+			}
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		log.Printf("--- Поиск прерван. Найдено устройств: %d ---", len(foundDevices))
+		return foundDevices, contextError(StepSearchCOM, err)
+	}
+	if enough(len(foundDevices)) {
+		log.Printf("--- Найдено ожидаемое количество устройств (%d). Поиск завершен. ---", len(foundDevices))
+		return foundDevices[:opts.ExpectedDevices], authError(deniedDevices)
+	}
+
+	// Этап 2: Параллельный поиск в IP-подсетях.
+	if len(opts.Subnets) == 0 {
+		opts.Subnets = localSubnets(opts.MaxHosts)
+		if len(opts.Subnets) == 0 {
+			log.Println("Подходящие сетевые интерфейсы не найдены, использую стандартные подсети RNDIS.")
+			opts.Subnets = rndisSubnets
+		}
+	}
+	log.Printf("--- Начинаю поиск устройств в сетях %v ---", opts.Subnets)
+	var wg sync.WaitGroup
+	foundChan := make(chan probeResult)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scanRNDISNetworks(searchCtx, opts, foundChan)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(foundChan)
+	}()
+
+	for result := range foundChan {
+		if result.denied {
+			deniedDevices = append(deniedDevices, result.config)
+			continue
+		}
+		if enough(len(foundDevices)) {
+			continue
+		}
+		foundDevices = append(foundDevices, result.config)
+		if enough(len(foundDevices)) {
+			stop()
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		log.Printf("--- Поиск прерван. Найдено устройств: %d ---", len(foundDevices))
+		return foundDevices, contextError(StepSearchNetwork, err)
+	}
+	log.Printf("--- Поиск завершен. Всего найдено устройств: %d ---", len(foundDevices))
+	return foundDevices, authError(deniedDevices)
+}
+
+// authError возвращает *SearchAuthError для ККТ, отклонивших пароли, или nil.
+func authError(denied []Config) error {
+	if len(denied) == 0 {
+		return nil
+	}
+	return &SearchAuthError{Devices: denied}
+}
+
+// portNames возвращает имена портов для сообщений в логе.
+func portNames(ports []*enumerator.PortDetails) []string {
+	names := make([]string, len(ports))
+	for i, p := range ports {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// scanComPorts проверяет порты не более чем opts.ComParallelism за раз.
+// После каждой находки вызывается onFound с общим числом найденных устройств.
+// Результаты упорядочены так же, как ports; для USB-портов в Config.USB
+// записывается идентификация устройства. ККТ, отклонившие все пароли,
+// возвращаются отдельно в denied.
+func scanComPorts(ctx context.Context, ports []*enumerator.PortDetails, opts SearchOptions, onFound func(found int)) (found, denied []Config) {
+	parallelism := opts.ComParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]*probeResult, len(ports))
+	guard := make(chan struct{}, parallelism)
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		count int
+	)
+	for i, port := range ports {
+		select {
+		case guard <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, port *enumerator.PortDetails) {
+			defer wg.Done()
+			defer func() { <-guard }()
+			log.Printf("Проверяю порт %s...", port.Name)
+			config, err := findOnComPort(ctx, port.Name, opts)
+			if config == nil {
+				return
+			}
+			config.USB = usbIdentity(port)
+			if err != nil {
+				log.Printf("ККТ на порту %s отклонила все пароли: %v", port.Name, err)
+				results[i] = &probeResult{config: *config, denied: true}
+				return
+			}
+			mu.Lock()
+			results[i] = &probeResult{config: *config}
+			count++
+			onFound(count)
+			mu.Unlock()
+		}(i, port)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		switch {
+		case r == nil:
+		case r.denied:
+			denied = append(denied, r.config)
+		default:
+			found = append(found, r.config)
+		}
+	}
+	return found, denied
+}
+
+// baudRateIndex возвращает индекс скорости, который понимает драйвер (Config.BaudRate).
+func baudRateIndex(baud int) (int32, bool) {
+	for i, b := range baudRates {
+		if b == baud {
+			return int32(i), true
+		}
+	}
+	return 0, false
+}
+
+// searchBaudIndexes переводит скорости из opts в индексы драйвера,
+// пропуская неподдерживаемые значения.
+func searchBaudIndexes(opts SearchOptions) []int32 {
+	var indexes []int32
+	for _, baud := range opts.BaudRates {
+		idx, ok := baudRateIndex(baud)
+		if !ok {
+			log.Printf("Скорость %d не поддерживается драйвером и пропущена при поиске.", baud)
+			continue
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes
+}
+
+// findOnComPort проверяет один COM-порт на наличие ККТ, перебирая
+// скорости из opts.BaudRates и пароли из opts. Если ККТ ответила, но отклонила
+// все пароли, возвращается ее конфигурация вместе с ошибкой категории CategoryPassword.
+func findOnComPort(ctx context.Context, portName string, opts SearchOptions) (*Config, error) {
+	if comProbeAvailable {
+		return findOnComPortCOM(ctx, portName, opts)
+	}
+	return findOnComPortNative(ctx, portName, opts)
+}
+
+// findOnComPortNative проверяет порт запросом состояния по нативному протоколу.
+func findOnComPortNative(ctx context.Context, portName string, opts SearchOptions) (*Config, error) {
+	for _, idx := range searchBaudIndexes(opts) {
+		if ctx.Err() != nil {
+			break
+		}
+		config := Config{
+			ConnectionType: 0,
+			ComName:        portName,
+			BaudRate:       idx,
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(portName), "COM")); err == nil {
+			config.ComNumber = int32(n)
+		}
+		password, err := probeNative(ctx, config, opts.ComTimeout, SearchPasswords(opts))
+		if err == nil {
+			config.Password = password
+			log.Printf("!!! Устройство найдено на порту %s, скорость %d", portName, baudRates[idx])
+			return &config, nil
+		}
+		if errors.Is(err, CategoryPassword) {
+			config.Password = opts.Password
+			return &config, err
+		}
+	}
+	return nil, fmt.Errorf("устройство не найдено на порту %s", portName)
+}
+
+// probeNative подключается к ККТ нативным драйвером, перебирая passwords, и
+// возвращает пароль, который ККТ приняла. Ошибка категории CategoryPassword
+// означает, что ККТ ответила, но отклонила все пароли.
+func probeNative(ctx context.Context, config Config, timeout time.Duration, passwords []int32) (int32, error) {
+	var denied error
+	for _, password := range passwords {
+		probe := config
+		probe.Password, probe.Timeout = password, timeout
+		driver := NewNative(probe).(*nativeDriver)
+		err := driver.ConnectContext(ctx)
+		if err == nil {
+			driver.Disconnect()
+			return password, nil
+		}
+		if !errors.Is(err, CategoryPassword) {
+			if denied != nil {
+				break
+			}
+			return 0, err
+		}
+		denied = err
+	}
+	return 0, denied
+}
+
+// findOnComPortCOM проверяет порт через COM-драйвер в отдельном потоке ОС,
+// поэтому несколько портов можно проверять одновременно. Прервать вызов
+// Connect COM-объекта нельзя: ctx проверяется между скоростями, а при отмене
+// ожидание прекращается и поток завершается в фоне.
+func findOnComPortCOM(ctx context.Context, portName string, opts SearchOptions) (*Config, error) {
+	comNum, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(portName), "COM"))
+	if err != nil {
+		return nil, fmt.Errorf("некорректное имя порта: %s", portName)
+	}
+
+	w, err := startSTA(comInitialize, ole.CoUninitialize)
+	if err != nil {
+		return nil, fmt.Errorf("COM init failed: %w", err)
+	}
+	defer w.stop()
+
+	var idx, password int32
+	var probeErr error
+	indexes := searchBaudIndexes(opts)
+	if err := w.call(ctx, func() { idx, password, probeErr = probeComPort(ctx, comNum, indexes, opts) }); err != nil {
+		return nil, contextError(StepSearchCOM, err)
+	}
+	config := &Config{
+		ConnectionType: 0,
+		ComName:        portName,
+		ComNumber:      int32(comNum),
+		BaudRate:       idx,
+		Password:       password,
+	}
+	switch {
+	case errors.Is(probeErr, CategoryPassword):
+		return config, probeErr
+	case probeErr != nil:
+		return nil, fmt.Errorf("устройство не найдено на порту %s", portName)
+	}
+	log.Printf("!!! Устройство найдено на порту %s, скорость %d", portName, baudRates[idx])
+	return config, nil
+}
+
+// probeComPort перебирает индексы скоростей и пароли на одном COM-объекте
+// драйвера и возвращает скорость и пароль, с которыми ответила ККТ. Ошибка
+// категории CategoryPassword означает, что ККТ ответила на скорости idx,
+// но отклонила все пароли. Выполняется в потоке STA.
+func probeComPort(ctx context.Context, comNum int, indexes []int32, opts SearchOptions) (idx, password int32, err error) {
+	unknown, err := oleutil.CreateObject("AddIn.DrvFR")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unknown.Release()
+	dispatch, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dispatch.Release()
+
+	// Настройка параметров для быстрой проверки с таймаутом.
+	oleutil.PutProperty(dispatch, "ConnectionType", 0)
+	oleutil.PutProperty(dispatch, "ComNumber", comNum)
+	oleutil.PutProperty(dispatch, "Timeout", opts.ComTimeout.Milliseconds())
+
+	err = errors.New("устройство не найдено")
+	tempDriver := &comDriver{dispatch: dispatch}
+	for _, idx := range indexes {
+		oleutil.PutProperty(dispatch, "BaudRate", idx)
+		for _, password := range SearchPasswords(opts) {
+			if ctx.Err() != nil {
+				return 0, 0, ctx.Err()
+			}
+			oleutil.PutProperty(dispatch, "Password", password)
+
+			// Попытка подключения и проверка кода ошибки драйвера.
+			_, connectErr := oleutil.CallMethod(dispatch, "Connect")
+			if connectErr != nil {
+				break
+			}
+			checkErr := tempDriver.checkError()
+			if checkErr == nil {
+				oleutil.CallMethod(dispatch, "Disconnect")
+				return idx, password, nil
+			}
+			if !errors.Is(checkErr, CategoryPassword) {
+				break
+			}
+			err = checkErr
+		}
+		if errors.Is(err, CategoryPassword) {
+			return idx, opts.Password, err
+		}
+	}
+	return 0, 0, err
+}
+
+// subnetHosts возвращает адреса узлов IPv4-подсети в нотации CIDR без адреса
+// сети и широковещательного адреса. Подсети больше maxSubnetHosts узлов
+// отклоняются, чтобы ошибка в настройке не запустила сканирование /8.
+func subnetHosts(cidr string) ([]string, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	base := ipNet.IP.To4()
+	if base == nil {
+		return nil, fmt.Errorf("подсеть %s не является IPv4", cidr)
+	}
+	ones, bits := ipNet.Mask.Size()
+	size := 1 << uint(bits-ones)
+	if size-2 > maxSubnetHosts {
+		return nil, fmt.Errorf("подсеть %s слишком велика (%d адресов, допустимо не более %d)", cidr, size-2, maxSubnetHosts)
+	}
+	start := binary.BigEndian.Uint32(base)
+	var hosts []string
+	for i := 1; i < size-1; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, start+uint32(i))
+		hosts = append(hosts, ip.String())
+	}
+	return hosts, nil
+}
+
+// scanRNDISNetworks запускает параллельное сканирование подсетей opts.Subnets.
+// Использует пул из opts.NetworkWorkers горутин для ограничения нагрузки.
+// При отмене ctx новые адреса не проверяются.
+func scanRNDISNetworks(ctx context.Context, opts SearchOptions, foundChan chan<- probeResult) {
+	var wg sync.WaitGroup
+
+	// Ограничиваем количество одновременных горутин.
+	workers := opts.NetworkWorkers
+	if workers < 1 {
+		workers = defaultNetworkWorkers
+	}
+	guard := make(chan struct{}, workers)
+
+	for _, subnet := range opts.Subnets {
+		hosts, err := subnetHosts(subnet)
+		if err != nil {
+			log.Printf("Подсеть '%s' пропущена: %v", subnet, err)
+			continue
+		}
+		for _, ip := range hosts {
+			select {
+			case guard <- struct{}{}: // Занимаем слот в пуле.
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			}
+			wg.Add(1)
+			go func(ip string) {
+				defer wg.Done()
+				checkIP(ctx, ip, opts, foundChan)
+				<-guard // Освобождаем слот.
+			}(ip)
+		}
+	}
+	wg.Wait()
+}
+
+// checkIP выполняет двухэтапную проверку одного IP-адреса:
+// 1. Быстрая проверка доступности порта через net.Dialer.
+// 2. Запрос состояния по протоколу "Штрих-М" напрямую через сокет, чтобы
+// убедиться, что это ККТ. Проверка не зависит от COM-драйвера и работает на любой ОС.
+// Пароли перебираются так же, как на COM-портах.
+func checkIP(ctx context.Context, ip string, opts SearchOptions, foundChan chan<- probeResult) {
+	address := net.JoinHostPort(ip, strconv.Itoa(int(opts.TCPPort)))
+	if skipped(address, opts) {
+		return
+	}
+	dialer := net.Dialer{Timeout: opts.TCPTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return // Порт закрыт или хост недоступен.
+	}
+	conn.Close()
+
+	log.Printf("Найден открытый порт на %s. Проверяю совместимость...", address)
+	config := Config{
+		ConnectionType: 6,
+		IPAddress:      ip,
+		TCPPort:        opts.TCPPort,
+	}
+	password, err := probeNative(ctx, config, opts.TCPTimeout, SearchPasswords(opts))
+	switch {
+	case err == nil:
+		config.Password = password
+		log.Printf("!!! Найдено и подтверждено устройство по TCP/IP: %s", address)
+		foundChan <- probeResult{config: config}
+	case errors.Is(err, CategoryPassword):
+		config.Password = opts.Password
+		log.Printf("ККТ на %s отклонила все пароли: %v", address, err)
+		foundChan <- probeResult{config: config, denied: true}
+	}
+}
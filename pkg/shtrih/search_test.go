@@ -0,0 +1,71 @@
+// Тесты параметров поиска
+package shtrih
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// TestSubnetHosts проверяет перечисление адресов подсети и ограничение ее размера.
+func TestSubnetHosts(t *testing.T) {
+	hosts, err := subnetHosts("10.0.5.0/30")
+	if err != nil {
+		t.Fatalf("subnetHosts() вернул неожиданную ошибку: %v", err)
+	}
+	if want := []string{"10.0.5.1", "10.0.5.2"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("Получены адреса %v, ожидались %v", hosts, want)
+	}
+	if hosts, _ := subnetHosts("192.168.137.77/24"); len(hosts) != 254 || hosts[0] != "192.168.137.1" {
+		t.Errorf("Подсеть /24 с адресом узла перечислена неверно: %d адресов, первый %v", len(hosts), hosts[:1])
+	}
+	for _, bad := range []string{"10.0.0.0/8", "192.168.137.", "fe80::/120"} {
+		if _, err := subnetHosts(bad); err == nil {
+			t.Errorf("subnetHosts(%q) не вернул ошибку", bad)
+		}
+	}
+}
+
+// TestSearchBaudIndexes проверяет перевод скоростей в индексы драйвера.
+func TestSearchBaudIndexes(t *testing.T) {
+	opts := SearchOptions{BaudRates: []int{9600, 12345, 115200}}
+	if got, want := searchBaudIndexes(opts), []int32{2, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Получены индексы %v, ожидались %v", got, want)
+	}
+}
+
+// TestLocalSubnets проверяет построение списка подсетей по интерфейсам хоста:
+// USB-интерфейсы первыми, крупные подсети обычных интерфейсов и туннели
+// точка-точка пропускаются, общее число адресов ограничено.
+func TestLocalSubnets(t *testing.T) {
+	// Arrange
+	orig := listInterfaces
+	defer func() { listInterfaces = orig }()
+	ipNet := func(ip string, ones int) *net.IPNet {
+		return &net.IPNet{IP: net.ParseIP(ip).To4(), Mask: net.CIDRMask(ones, 32)}
+	}
+	listInterfaces = func() ([]hostInterface, error) {
+		return []hostInterface{
+			{Name: "lo", Flags: net.FlagUp | net.FlagLoopback, Nets: []*net.IPNet{ipNet("127.0.0.1", 8)}},
+			{Name: "Ethernet", Flags: net.FlagUp, Nets: []*net.IPNet{ipNet("10.1.0.5", 16)}},
+			{Name: "wlan0", Flags: net.FlagUp, Nets: []*net.IPNet{ipNet("192.168.1.20", 24)}},
+			{Name: "eth1", Flags: 0, Nets: []*net.IPNet{ipNet("172.16.0.2", 24)}},
+			{Name: "ppp0", Flags: net.FlagUp | net.FlagPointToPoint, Nets: []*net.IPNet{ipNet("10.8.0.6", 24)}},
+			{Name: "Ethernet 2", Flags: net.FlagUp, Nets: []*net.IPNet{ipNet("192.168.137.1", 24)}},
+			{Name: "usb0", Flags: net.FlagUp, Nets: []*net.IPNet{ipNet("10.2.0.5", 16)}},
+		}, nil
+	}
+
+	// Act
+	subnets := localSubnets(1024)
+	capped := localSubnets(300)
+
+	// Assert
+	want := []string{"192.168.137.0/24", "10.2.0.0/24", "192.168.1.0/24"}
+	if !reflect.DeepEqual(subnets, want) {
+		t.Errorf("Получены подсети %v, ожидались %v", subnets, want)
+	}
+	if want := []string{"192.168.137.0/24"}; !reflect.DeepEqual(capped, want) {
+		t.Errorf("С лимитом 300 адресов получены подсети %v, ожидались %v", capped, want)
+	}
+}
@@ -0,0 +1,104 @@
+// Файл: pkg/shtrih/sta.go
+package shtrih
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// errSTAStopped возвращается при вызове в остановленном COM-потоке.
+var errSTAStopped = errors.New("COM-поток драйвера остановлен")
+
+// staWorker выполняет функции в одном заблокированном потоке ОС. COM-объект
+// в модели Single-Threaded Apartment можно вызывать только из потока, который
+// его создал, поэтому все обращения к IDispatch драйвера проходят через
+// канал вызовов этого потока, а вызывающие горутины могут быть любыми.
+type staWorker struct {
+	calls chan func()
+	quit  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+	// busy - число вызовов, ожидание которых прервано по ctx и которые еще
+	// не завершились в потоке.
+	busy int32
+}
+
+// startSTA запускает поток, выполняет в нем init (инициализация COM), а при
+// остановке - uninit. Возвращает ошибку init, если поток не удалось подготовить.
+func startSTA(init func() error, uninit func()) (*staWorker, error) {
+	w := &staWorker{
+		calls: make(chan func()),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	initErr := make(chan error, 1)
+	go w.loop(init, uninit, initErr)
+	if err := <-initErr; err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *staWorker) loop(init func() error, uninit func(), initErr chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer close(w.done)
+
+	if err := init(); err != nil {
+		initErr <- err
+		return
+	}
+	initErr <- nil
+	for {
+		select {
+		case fn := <-w.calls:
+			fn()
+		case <-w.quit:
+			uninit()
+			return
+		}
+	}
+}
+
+// call выполняет fn в потоке и ждет ее завершения. При отмене ctx ожидание
+// прекращается и возвращается ctx.Err(), но прервать сам COM-вызов нельзя:
+// он завершится в фоне, а следующие вызовы будут ждать его в очереди.
+func (w *staWorker) call(ctx context.Context, fn func()) error {
+	finished := make(chan struct{})
+	task := func() {
+		defer close(finished)
+		fn()
+	}
+	select {
+	case w.calls <- task:
+	case <-w.done:
+		return errSTAStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt32(&w.busy, 1)
+		go func() {
+			<-finished
+			atomic.AddInt32(&w.busy, -1)
+		}()
+		return ctx.Err()
+	}
+}
+
+// stop останавливает поток. Если поток занят прерванными вызовами, stop не ждет
+// их завершения и возвращает false: ресурсы освободятся, когда вызовы вернутся.
+func (w *staWorker) stop() bool {
+	w.once.Do(func() { close(w.quit) })
+	if atomic.LoadInt32(&w.busy) != 0 {
+		return false
+	}
+	<-w.done
+	return true
+}
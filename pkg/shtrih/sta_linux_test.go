@@ -0,0 +1,40 @@
+// Тест привязки потока COM-драйвера к одному потоку ОС
+package shtrih
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// TestSTAWorker_SameThread проверяет, что инициализация и все вызовы выполняются
+// в одном потоке ОС, из какой бы горутины они ни пришли.
+func TestSTAWorker_SameThread(t *testing.T) {
+	// Arrange
+	var initTid int
+	w, err := startSTA(func() error { initTid = syscall.Gettid(); return nil }, func() {})
+	if err != nil {
+		t.Fatalf("startSTA() вернул неожиданную ошибку: %v", err)
+	}
+	defer w.stop()
+
+	// Act
+	tids := make([]int, 10)
+	var wg sync.WaitGroup
+	for i := range tids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w.call(context.Background(), func() { tids[i] = syscall.Gettid() })
+		}(i)
+	}
+	wg.Wait()
+
+	// Assert
+	for i, tid := range tids {
+		if tid != initTid {
+			t.Errorf("Вызов %d выполнен в потоке %d, ожидался %d", i, tid, initTid)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Тесты потока COM-драйвера
+package shtrih
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSTAWorker_SerializesCalls проверяет, что вызовы из разных горутин
+// выполняются в потоке по одному.
+func TestSTAWorker_SerializesCalls(t *testing.T) {
+	// Arrange
+	uninitCalled := false
+	w, err := startSTA(func() error { return nil }, func() { uninitCalled = true })
+	if err != nil {
+		t.Fatalf("startSTA() вернул неожиданную ошибку: %v", err)
+	}
+	var active, maxActive, total int
+
+	// Act
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.call(context.Background(), func() {
+				active++
+				if active > maxActive {
+					maxActive = active
+				}
+				time.Sleep(time.Millisecond)
+				total++
+				active--
+			})
+		}()
+	}
+	wg.Wait()
+	stopped := w.stop()
+
+	// Assert
+	if maxActive != 1 || total != 20 {
+		t.Errorf("Вызовы выполнялись параллельно или потерялись: одновременно %d, всего %d", maxActive, total)
+	}
+	if !stopped || !uninitCalled {
+		t.Error("При остановке потока не выполнена деинициализация.")
+	}
+	if err := w.call(context.Background(), func() {}); !errors.Is(err, errSTAStopped) {
+		t.Errorf("Ожидалась ошибка errSTAStopped после остановки, получено: %v", err)
+	}
+}
+
+// TestSTAWorker_AbandonedCall проверяет, что отмена ctx прекращает ожидание
+// зависшего вызова, а остановка потока не блокируется на нем.
+func TestSTAWorker_AbandonedCall(t *testing.T) {
+	// Arrange
+	w, err := startSTA(func() error { return nil }, func() {})
+	if err != nil {
+		t.Fatalf("startSTA() вернул неожиданную ошибку: %v", err)
+	}
+	release := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err = w.call(ctx, func() { <-release })
+
+	// Assert
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Ожидалась ошибка context.DeadlineExceeded, получено: %v", err)
+	}
+	if w.stop() {
+		t.Error("stop() не должен ждать завершения прерванного вызова.")
+	}
+	close(release)
+	select {
+	case <-w.done:
+	case <-time.After(time.Second):
+		t.Error("Поток не завершился после возврата прерванного вызова.")
+	}
+}
+
+// TestSTAWorker_TwoAbandonedCalls проверяет, что остановка потока не
+// блокируется, пока выполняется второй из двух прерванных вызовов, даже если
+// первый уже завершился.
+func TestSTAWorker_TwoAbandonedCalls(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		// Arrange
+		w, err := startSTA(func() error { return nil }, func() {})
+		if err != nil {
+			t.Fatalf("startSTA() вернул неожиданную ошибку: %v", err)
+		}
+		releaseFirst, releaseSecond := make(chan struct{}), make(chan struct{})
+		firstCtx, cancelFirst := context.WithCancel(context.Background())
+		secondCtx, cancelSecond := context.WithCancel(context.Background())
+		errs := make(chan error, 2)
+
+		// Act
+		go func() { errs <- w.call(firstCtx, func() { <-releaseFirst }) }()
+		go func() {
+			// Второй вызов попадает в поток сразу после первого и прерывается,
+			// как только начался.
+			errs <- w.call(secondCtx, func() {
+				cancelSecond()
+				<-releaseSecond
+			})
+		}()
+		time.Sleep(time.Millisecond)
+		cancelFirst()
+		close(releaseFirst)
+		for j := 0; j < 2; j++ {
+			if err := <-errs; !errors.Is(err, context.Canceled) {
+				t.Fatalf("Ожидалась ошибка context.Canceled, получено: %v", err)
+			}
+		}
+		stopped := make(chan bool, 1)
+		go func() { stopped <- w.stop() }()
+
+		// Assert
+		select {
+		case ok := <-stopped:
+			if ok {
+				t.Fatal("stop() не должен ждать завершения прерванного вызова.")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("stop() заблокирован на втором прерванном вызове.")
+		}
+		close(releaseSecond)
+		<-w.done
+	}
+}
+
+// TestSTAWorker_InitError проверяет, что ошибка инициализации возвращается вызывающему.
+func TestSTAWorker_InitError(t *testing.T) {
+	initErr := errors.New("CoInitialize failed")
+	if _, err := startSTA(func() error { return initErr }, func() {}); !errors.Is(err, initErr) {
+		t.Errorf("Ожидалась ошибка инициализации, получено: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Файл: pkg/shtrih/tables.go
+package shtrih
+
+import "strconv"
+
+// TableDriver расширяет Driver доступом к внутренним таблицам настроек ККТ.
+// Реализуется всеми драйверами пакета; проверяется приведением типа:
+//
+//	if td, ok := driver.(shtrih.TableDriver); ok { ... }
+type TableDriver interface {
+	Driver
+	// GetTableStruct возвращает название таблицы, число рядов и полей.
+	GetTableStruct(table int) (*TableStruct, error)
+	// GetFieldStruct возвращает описание поля: название, тип, размер и допустимый диапазон.
+	GetFieldStruct(table, field int) (*FieldStruct, error)
+	// ReadTable читает значение поля в указанном ряду таблицы.
+	ReadTable(table, row, field int) (*TableValue, error)
+}
+
+// Все драйверы пакета поддерживают работу с таблицами.
+var (
+	_ TableDriver = (*comDriver)(nil)
+	_ TableDriver = (*nativeDriver)(nil)
+	_ TableDriver = (*mockDriver)(nil)
+)
+
+// FieldType - тип поля таблицы ККТ.
+type FieldType int
+
+const (
+	// FieldNumber - целое беззнаковое число (BIN).
+	FieldNumber FieldType = 0
+	// FieldString - строка в кодировке Windows-1251 (CHAR).
+	FieldString FieldType = 1
+)
+
+func (t FieldType) String() string {
+	if t == FieldString {
+		return "string"
+	}
+	return "number"
+}
+
+// MarshalText представляет тип поля в JSON как "number" или "string".
+func (t FieldType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText разбирает тип поля из "number" или "string".
+func (t *FieldType) UnmarshalText(text []byte) error {
+	if string(text) == "string" {
+		*t = FieldString
+	} else {
+		*t = FieldNumber
+	}
+	return nil
+}
+
+// TableStruct описывает таблицу ККТ, как ее возвращает команда 0x2D.
+type TableStruct struct {
+	Number int    `json:"number"`
+	Name   string `json:"name"`
+	Rows   int    `json:"rows"`
+	Fields int    `json:"fields"`
+}
+
+// FieldStruct описывает поле таблицы ККТ, как его возвращает команда 0x2E.
+// Min и Max заполняются только для числовых полей.
+type FieldStruct struct {
+	Table  int       `json:"table"`
+	Number int       `json:"number"`
+	Name   string    `json:"name"`
+	Type   FieldType `json:"type"`
+	Size   int       `json:"size"`
+	Min    uint64    `json:"min,omitempty"`
+	Max    uint64    `json:"max,omitempty"`
+}
+
+// TableValue - значение поля таблицы вместе с его описанием.
+// Для числовых полей заполнено Int, для строковых - Str.
+type TableValue struct {
+	Field FieldStruct `json:"field"`
+	Row   int         `json:"row"`
+	Int   uint64      `json:"int,omitempty"`
+	Str   string      `json:"str,omitempty"`
+}
+
+// IsString сообщает, что поле строковое.
+func (v *TableValue) IsString() bool {
+	return v.Field.Type == FieldString
+}
+
+// String возвращает значение в текстовом виде, как свойство ValueOfFieldString COM-драйвера.
+func (v *TableValue) String() string {
+	if v.IsString() {
+		return v.Str
+	}
+	return strconv.FormatUint(v.Int, 10)
+}
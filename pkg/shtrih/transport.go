@@ -0,0 +1,138 @@
+// Файл: pkg/shtrih/transport.go
+package shtrih
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"shtrih-kkt/pkg/shtrih/protocol"
+)
+
+// Параметры TCP-транспорта по умолчанию.
+const (
+	defaultDialTimeout = 3 * time.Second
+	tcpKeepAlive       = 15 * time.Second
+)
+
+// tcpPort реализует интерфейс port поверх TCP-соединения (порт 7778 у ККТ
+// "Штрих-М" с Ethernet/RNDIS). Таймаут чтения превращается в дедлайн сокета,
+// а истечение дедлайна - в чтение 0 байт, как у последовательного порта.
+type tcpPort struct {
+	address     string
+	dialTimeout time.Duration
+	conn        net.Conn
+	readTimeout time.Duration
+
+	// mu защищает conn и closed: Close может вызываться из другой горутины
+	// при отмене контекста операции.
+	mu     sync.Mutex
+	closed bool
+}
+
+// dialTCP устанавливает соединение с ККТ. Для обнаружения полуоткрытых
+// соединений включается TCP keep-alive. Отмена ctx прерывает установку соединения.
+func dialTCP(ctx context.Context, host string, portNum int32, dialTimeout time.Duration) (*tcpPort, error) {
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	p := &tcpPort{
+		address:     net.JoinHostPort(host, strconv.Itoa(int(portNum))),
+		dialTimeout: dialTimeout,
+	}
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.conn = conn
+	return p, nil
+}
+
+func (p *tcpPort) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: p.dialTimeout, KeepAlive: tcpKeepAlive}
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return nil, fmt.Errorf("tcp connect to %s failed: %w", p.address, err)
+	}
+	return conn, nil
+}
+
+// Reconnect закрывает текущее соединение и устанавливает новое.
+// После Close переподключение не выполняется.
+func (p *tcpPort) Reconnect() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return net.ErrClosed
+	}
+	p.conn.Close()
+	p.mu.Unlock()
+
+	conn, err := p.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		conn.Close()
+		return net.ErrClosed
+	}
+	p.conn = conn
+	return nil
+}
+
+func (p *tcpPort) SetReadTimeout(t time.Duration) error {
+	p.readTimeout = t
+	return nil
+}
+
+func (p *tcpPort) Read(b []byte) (int, error) {
+	if p.readTimeout > 0 {
+		if err := p.conn.SetReadDeadline(time.Now().Add(p.readTimeout)); err != nil {
+			return 0, err
+		}
+	} else if err := p.conn.SetReadDeadline(time.Time{}); err != nil {
+		return 0, err
+	}
+	n, err := p.conn.Read(b)
+	var netErr net.Error
+	if err != nil && errors.As(err, &netErr) && netErr.Timeout() {
+		return n, nil
+	}
+	return n, err
+}
+
+func (p *tcpPort) Write(b []byte) (int, error) {
+	if err := p.conn.SetWriteDeadline(time.Now().Add(p.dialTimeout)); err != nil {
+		return 0, err
+	}
+	return p.conn.Write(b)
+}
+
+func (p *tcpPort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return p.conn.Close()
+}
+
+// reconnector реализуется транспортами, которые умеют восстанавливать соединение.
+type reconnector interface {
+	Reconnect() error
+}
+
+// isLinkError сообщает, что ошибка относится к каналу связи, а не к ответу ККТ:
+// устройство молчит (в т.ч. при полуоткрытом сокете), соединение закрыто или сброшено.
+func isLinkError(err error) bool {
+	if errors.Is(err, protocol.ErrNoConnection) || errors.Is(err, protocol.ErrTimeout) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
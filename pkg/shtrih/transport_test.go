@@ -0,0 +1,107 @@
+// Тесты TCP-транспорта
+package shtrih
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"shtrih-kkt/pkg/shtrih/protocol"
+)
+
+// answerECRStatus имитирует ответ ККТ на одну команду: NAK на ENQ, ACK на кадр,
+// затем кадр ответа с нулевым кодом ошибки. Возвращает false, если клиент отключился.
+func answerECRStatus(conn net.Conn) bool {
+	buf := make([]byte, 64)
+	if _, err := conn.Read(buf[:1]); err != nil || buf[0] != protocol.ENQ {
+		return false
+	}
+	conn.Write([]byte{protocol.NAK})
+	// STX, длина, тело и LRC.
+	if _, err := conn.Read(buf[:2]); err != nil {
+		return false
+	}
+	n := int(buf[1]) + 1
+	for read := 0; read < n; {
+		m, err := conn.Read(buf[read:n])
+		if err != nil {
+			return false
+		}
+		read += m
+	}
+	conn.Write([]byte{protocol.ACK})
+	frame, _ := protocol.Encode(uint16(buf[0]), []byte{0x00})
+	conn.Write(frame)
+	_, err := conn.Read(buf[:1]) // ACK от драйвера
+	return err == nil
+}
+
+// TestTCPPort_ReconnectAfterDrop проверяет, что драйвер восстанавливает
+// соединение, если ККТ закрыла сокет, и повторяет команду.
+func TestTCPPort_ReconnectAfterDrop(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Не удалось открыть слушающий сокет: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		// Первое соединение: отвечаем на Connect и закрываем сокет.
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		answerECRStatus(conn)
+		conn.Close()
+		// Второе соединение: обслуживаем до отключения клиента.
+		conn, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for answerECRStatus(conn) {
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	d := NewNative(Config{ConnectionType: 6, IPAddress: "127.0.0.1", TCPPort: int32(addr.Port), Password: 30, Timeout: 200 * time.Millisecond}).(*nativeDriver)
+	if err := d.Connect(); err != nil {
+		t.Fatalf("Connect() вернул неожиданную ошибку: %v", err)
+	}
+	defer d.Disconnect()
+
+	if _, err := d.command(cmdGetECRStatus); err != nil {
+		t.Fatalf("Команда после разрыва соединения не выполнена: %v", err)
+	}
+}
+
+// TestTCPPort_ReadTimeout проверяет, что истечение дедлайна сокета
+// превращается в чтение 0 байт без ошибки.
+func TestTCPPort_ReadTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Не удалось открыть слушающий сокет: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	p, err := dialTCP(context.Background(), "127.0.0.1", int32(addr.Port), time.Second)
+	if err != nil {
+		t.Fatalf("dialTCP() вернул неожиданную ошибку: %v", err)
+	}
+	defer p.Close()
+
+	p.SetReadTimeout(20 * time.Millisecond)
+	n, err := p.Read(make([]byte, 1))
+	if n != 0 || err != nil {
+		t.Errorf("Read() = (%d, %v), ожидалось (0, nil) по таймауту", n, err)
+	}
+}
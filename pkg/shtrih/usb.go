@@ -0,0 +1,170 @@
+// Файл: pkg/shtrih/usb.go
+package shtrih
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// USBIdentity - USB-идентификация последовательного порта, на котором найдена
+// ККТ. Сохраняется в connect.json, чтобы найти устройство, если ОС назначит
+// ему другое имя порта.
+type USBIdentity struct {
+	VID          string `json:"vid"`
+	PID          string `json:"pid"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	Product      string `json:"product,omitempty"`
+}
+
+// Matches сообщает, описывает ли порт p то же USB-устройство. Серийный номер
+// сравнивается, только если он известен с обеих сторон.
+func (u *USBIdentity) Matches(p *enumerator.PortDetails) bool {
+	if u == nil || !p.IsUSB {
+		return false
+	}
+	if !strings.EqualFold(u.VID, p.VID) || !strings.EqualFold(u.PID, p.PID) {
+		return false
+	}
+	return u.SerialNumber == "" || p.SerialNumber == "" || u.SerialNumber == p.SerialNumber
+}
+
+// usbIdentity возвращает идентификацию порта или nil для портов не на USB.
+func usbIdentity(p *enumerator.PortDetails) *USBIdentity {
+	if !p.IsUSB {
+		return nil
+	}
+	return &USBIdentity{
+		VID:          strings.ToUpper(p.VID),
+		PID:          strings.ToUpper(p.PID),
+		SerialNumber: p.SerialNumber,
+		Product:      p.Product,
+	}
+}
+
+// listPorts возвращает последовательные порты с USB-идентификацией. Вынесена
+// в переменную пакета, чтобы тесты могли подставить свой список портов.
+var listPorts = systemPorts
+
+// systemPorts запрашивает подробный список портов, а если ОС его не поддерживает -
+// только имена портов.
+func systemPorts() ([]*enumerator.PortDetails, error) {
+	details, err := enumerator.GetDetailedPortsList()
+	if err == nil {
+		return details, nil
+	}
+	names, nerr := serial.GetPortsList()
+	if nerr != nil {
+		return nil, nerr
+	}
+	ports := make([]*enumerator.PortDetails, 0, len(names))
+	for _, name := range names {
+		ports = append(ports, &enumerator.PortDetails{Name: name})
+	}
+	return ports, nil
+}
+
+// Приоритеты проверки портов: чем меньше, тем раньше.
+const (
+	portPriorityRemembered = iota // Порт, на котором ККТ была найдена раньше
+	portPriorityKnown             // Известный адаптер ККТ или USB-COM
+	portPriorityUSB               // Прочие USB-порты
+	portPriorityOther             // Встроенные и неизвестные порты
+)
+
+// knownUSBVendors - производители USB-COM адаптеров и микросхем CDC-ACM,
+// через которые обычно подключаются ККТ "Штрих-М" (FTDI, Prolific, Silicon Labs, WCH).
+var knownUSBVendors = []string{"0403", "067B", "10C4", "1A86"}
+
+// knownProductMarkers - части описания порта, по которым он считается портом ККТ.
+var knownProductMarkers = []string{"shtrih", "штрих", "ккт", "kkt", "cdc", "virtual com", "vcom"}
+
+// blacklistedUSBVendors - производители сканеров штрихкода
+// (Honeywell, Zebra/Symbol, Datalogic, Newland), их порты не проверяются.
+var blacklistedUSBVendors = []string{"0C2E", "05E0", "05F9", "1EAB"}
+
+// blacklistedMarkers - части имени или описания порта Bluetooth, модемов и сканеров.
+var blacklistedMarkers = []string{"bluetooth", "rfcomm", "modem", "scanner", "сканер"}
+
+// usbIDMatches проверяет, есть ли VID или VID:PID порта в списке ids.
+func usbIDMatches(p *enumerator.PortDetails, ids []string) bool {
+	if !p.IsUSB {
+		return false
+	}
+	for _, id := range ids {
+		vid, pid := id, ""
+		if i := strings.Index(id, ":"); i >= 0 {
+			vid, pid = id[:i], id[i+1:]
+		}
+		if strings.EqualFold(vid, p.VID) && (pid == "" || strings.EqualFold(pid, p.PID)) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny сообщает, содержит ли s (без учета регистра) одну из подстрок.
+func containsAny(s string, markers []string) bool {
+	s = strings.ToLower(s)
+	for _, m := range markers {
+		if strings.Contains(s, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// portPriority возвращает приоритет проверки порта или false, если порт
+// относится к классу устройств, которые не проверяются.
+func portPriority(p *enumerator.PortDetails, opts SearchOptions) (int, bool) {
+	for _, id := range opts.KnownUSB {
+		if id.Matches(p) {
+			return portPriorityRemembered, true
+		}
+	}
+	if usbIDMatches(p, opts.PreferredUSB) {
+		return portPriorityKnown, true
+	}
+	if usbIDMatches(p, opts.BlacklistUSB) || usbIDMatches(p, blacklistedUSBVendors) ||
+		containsAny(p.Name, blacklistedMarkers) || containsAny(p.Product, blacklistedMarkers) {
+		return 0, false
+	}
+	switch {
+	case usbIDMatches(p, knownUSBVendors) || containsAny(p.Product, knownProductMarkers):
+		return portPriorityKnown, true
+	case p.IsUSB:
+		return portPriorityUSB, true
+	default:
+		return portPriorityOther, true
+	}
+}
+
+// orderPorts убирает порты из черного списка и opts.SkipAddresses и сортирует остальные по приоритету,
+// сохраняя исходный порядок внутри одного приоритета.
+func orderPorts(ports []*enumerator.PortDetails, opts SearchOptions) []*enumerator.PortDetails {
+	type ranked struct {
+		port     *enumerator.PortDetails
+		priority int
+	}
+	var list []ranked
+	for _, p := range ports {
+		if skipped(p.Name, opts) {
+			continue
+		}
+		priority, ok := portPriority(p, opts)
+		if !ok {
+			log.Printf("Порт %s (%s %s:%s) пропущен: устройство в черном списке.", p.Name, p.Product, p.VID, p.PID)
+			continue
+		}
+		list = append(list, ranked{p, priority})
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].priority < list[j].priority })
+	ordered := make([]*enumerator.PortDetails, len(list))
+	for i, r := range list {
+		ordered[i] = r.port
+	}
+	return ordered
+}
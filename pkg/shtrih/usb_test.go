@@ -0,0 +1,75 @@
+// Тесты выбора последовательных портов по USB-идентификации
+package shtrih
+
+import (
+	"reflect"
+	"testing"
+
+	"go.bug.st/serial/enumerator"
+)
+
+// namedPorts возвращает функцию для listPorts со списком портов без USB-идентификации.
+func namedPorts(names ...string) func() ([]*enumerator.PortDetails, error) {
+	return func() ([]*enumerator.PortDetails, error) {
+		ports := make([]*enumerator.PortDetails, len(names))
+		for i, name := range names {
+			ports[i] = &enumerator.PortDetails{Name: name}
+		}
+		return ports, nil
+	}
+}
+
+// TestOrderPorts проверяет, что известные адаптеры проверяются первыми,
+// а Bluetooth, модемы, сканеры и порты из черного списка пропускаются.
+func TestOrderPorts(t *testing.T) {
+	// Arrange
+	ports := []*enumerator.PortDetails{
+		{Name: "COM1"},
+		{Name: "COM3", Product: "Standard Serial over Bluetooth link"},
+		{Name: "COM4", IsUSB: true, VID: "2341", PID: "0043", Product: "USB Serial Device"},
+		{Name: "COM5", IsUSB: true, VID: "0c2e", PID: "0b61", Product: "Honeywell"},
+		{Name: "COM6", IsUSB: true, VID: "0403", PID: "6001", Product: "USB Serial Port"},
+		{Name: "COM7", IsUSB: true, VID: "1234", PID: "5678", Product: "USB Modem"},
+		{Name: "COM8", IsUSB: true, VID: "AAAA", PID: "0001"},
+		{Name: "COM9", IsUSB: true, VID: "BBBB", PID: "0002"},
+		{Name: "COM10", IsUSB: true, VID: "1A86", PID: "7523", SerialNumber: "A1", Product: "USB-SERIAL CH340"},
+		{Name: "COM11", IsUSB: true, VID: "1A86", PID: "7523", SerialNumber: "B2", Product: "USB-SERIAL CH340"},
+	}
+	opts := SearchOptions{
+		PreferredUSB:  []string{"aaaa:0001"},
+		BlacklistUSB:  []string{"BBBB"},
+		SkipAddresses: []string{"com4"},
+		KnownUSB:      []*USBIdentity{{VID: "1A86", PID: "7523", SerialNumber: "B2"}},
+	}
+
+	// Act
+	ordered := orderPorts(ports, opts)
+
+	// Assert: адаптер ККТ с сохраненным серийным номером проверяется раньше
+	// такого же адаптера другой ККТ.
+	if got, want := portNames(ordered), []string{"COM11", "COM6", "COM8", "COM10", "COM1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Порядок портов %v, ожидался %v", got, want)
+	}
+}
+
+// TestUSBIdentity_Matches проверяет сопоставление сохраненной идентификации с портом.
+func TestUSBIdentity_Matches(t *testing.T) {
+	id := usbIdentity(&enumerator.PortDetails{Name: "COM6", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A1"})
+	cases := []struct {
+		port *enumerator.PortDetails
+		want bool
+	}{
+		{&enumerator.PortDetails{Name: "COM9", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A1"}, true},
+		{&enumerator.PortDetails{Name: "COM9", IsUSB: true, VID: "0403", PID: "6001"}, true},
+		{&enumerator.PortDetails{Name: "COM9", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "B2"}, false},
+		{&enumerator.PortDetails{Name: "COM6"}, false},
+	}
+	for _, c := range cases {
+		if got := id.Matches(c.port); got != c.want {
+			t.Errorf("Matches(%+v) = %v, ожидалось %v", c.port, got, c.want)
+		}
+	}
+	if usbIdentity(&enumerator.PortDetails{Name: "COM1"}) != nil {
+		t.Error("Для порта не на USB идентификация должна быть nil.")
+	}
+}
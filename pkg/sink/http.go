@@ -0,0 +1,321 @@
+// Файл: pkg/sink/http.go
+package sink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Параметры отправки по HTTP.
+const (
+	// defaultHTTPTimeout - таймаут запроса, если он не задан в настройках.
+	defaultHTTPTimeout = 30 * time.Second
+	// spoolBaseDelay и spoolMaxDelay - пауза перед повторной отправкой из очереди:
+	// удваивается после каждой неудачной попытки, но не превышает spoolMaxDelay.
+	spoolBaseDelay = time.Minute
+	spoolMaxDelay  = 6 * time.Hour
+)
+
+// Способы подписи запросов в Config.Auth.
+const (
+	AuthNone   = ""
+	AuthBearer = "bearer"
+	AuthHMAC   = "hmac"
+)
+
+// Заголовки запроса при подписи HMAC.
+const (
+	HeaderTimestamp = "X-Shtrih-Timestamp"
+	HeaderSignature = "X-Shtrih-Signature"
+)
+
+// httpSink отправляет каждую запись POST-запросом с телом в JSON. Если задан
+// каталог очереди, неотправленные записи сохраняются в нем и отправляются
+// повторно в следующих запусках: перед первой записью, а если записей не
+// было - при закрытии приемника. В очереди хранится только последняя запись
+// каждой ККТ: более старые данные устаревают. Записи, которые сервер отклонил
+// окончательно (см. rejectedError), в очередь не попадают.
+type httpSink struct {
+	url      string
+	auth     string
+	secret   []byte
+	spoolDir string
+	client   *http.Client
+	now      func() time.Time
+	// offline устанавливается после первой неудачной отправки: остальные
+	// записи этого запуска сразу попадают в очередь, не ожидая таймаута.
+	offline bool
+	// replayed устанавливается после отправки очереди в этом запуске.
+	replayed bool
+}
+
+// rejectedDir - подкаталог очереди для записей, отклоненных сервером окончательно.
+const rejectedDir = "rejected"
+
+// rejectedError - ответ сервера с кодом 4xx, кроме 408 и 429. Повтор такого
+// запроса не поможет: данные или подпись не принимаются сервером.
+type rejectedError struct {
+	url    string
+	status string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("сервер '%s' отклонил данные: %s", e.url, e.status)
+}
+
+// isRejected сообщает, что запрос с кодом ответа code не следует повторять.
+func isRejected(code int) bool {
+	return code >= 400 && code <= 499 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+// spoolEntry - запись в очереди на повторную отправку.
+type spoolEntry struct {
+	SerialNumber string          `json:"serial_number"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  time.Time       `json:"next_attempt"`
+	Body         json.RawMessage `json:"body"`
+}
+
+// NewHTTP создает приемник, который отправляет записи на cfg.URL. Ответ с кодом
+// не из диапазона 2xx считается ошибкой. Запросы подписываются по cfg.Auth:
+// "bearer" - заголовок Authorization с токеном cfg.Secret, "hmac" - заголовки
+// HeaderTimestamp и HeaderSignature ("sha256=" и HMAC-SHA256 ключом cfg.Secret
+// от строки "{timestamp}.{тело запроса}" в hex).
+func NewHTTP(cfg Config) (Sink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("для приемника '%s' не указан адрес 'url'", TypeHTTP)
+	}
+	auth := strings.ToLower(cfg.Auth)
+	switch auth {
+	case AuthNone:
+	case AuthBearer, AuthHMAC:
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("для подписи '%s' не задан секрет 'secret'", auth)
+		}
+	default:
+		return nil, fmt.Errorf("неизвестный способ подписи '%s'", cfg.Auth)
+	}
+	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+	return &httpSink{
+		url:      cfg.URL,
+		auth:     auth,
+		secret:   []byte(cfg.Secret),
+		spoolDir: cfg.SpoolDir,
+		client:   &http.Client{Timeout: timeout},
+		now:      time.Now,
+	}, nil
+}
+
+func (s *httpSink) Write(rec Record) error {
+	body, err := json.Marshal(rec.Data)
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга итогового JSON: %w", err)
+	}
+	if err := s.replay(); err != nil {
+		log.Printf("Ошибка очереди '%s': %v", s.spoolDir, err)
+	}
+	if !s.offline {
+		err = s.send(body)
+		if err == nil {
+			if s.spoolDir != "" {
+				os.Remove(s.spoolPath(rec.SerialNumber))
+			}
+			return nil
+		}
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			return fmt.Errorf("данные ККТ %s не приняты: %w", rec.SerialNumber, err)
+		}
+		s.offline = true
+	} else {
+		err = fmt.Errorf("сервер '%s' недоступен в этом запуске", s.url)
+	}
+	if s.spoolDir == "" {
+		return fmt.Errorf("ошибка отправки данных ККТ %s: %w", rec.SerialNumber, err)
+	}
+	entry := spoolEntry{SerialNumber: rec.SerialNumber, Body: body}
+	if prev, ok := s.readSpool(s.spoolPath(rec.SerialNumber)); ok {
+		entry.Attempts = prev.Attempts
+	}
+	entry.Attempts++
+	entry.NextAttempt = s.now().Add(spoolDelay(entry.Attempts))
+	if spoolErr := s.spool(entry); spoolErr != nil {
+		return fmt.Errorf("ошибка отправки данных ККТ %s: %v; %w", rec.SerialNumber, err, spoolErr)
+	}
+	log.Printf("Данные ККТ %s не отправлены (%v) и сохранены в очередь '%s'.", rec.SerialNumber, err, s.spoolDir)
+	return nil
+}
+
+// Close отправляет очередь, если в этом запуске записей не было.
+func (s *httpSink) Close() error {
+	return s.replay()
+}
+
+// replay один раз за запуск отправляет записи из очереди, для которых
+// подошло время повтора. После первой неудачи отправка прекращается, а пауза
+// перед следующей попыткой для этой записи удваивается. Записи, отклоненные
+// сервером окончательно, переносятся в подкаталог rejectedDir.
+func (s *httpSink) replay() error {
+	if s.spoolDir == "" || s.offline || s.replayed {
+		return nil
+	}
+	s.replayed = true
+	names, err := filepath.Glob(filepath.Join(s.spoolDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	sent := 0
+	for _, name := range names {
+		entry, ok := s.readSpool(name)
+		if !ok || s.now().Before(entry.NextAttempt) {
+			continue
+		}
+		err := s.send(entry.Body)
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			s.reject(name, entry, err)
+			continue
+		}
+		if err != nil {
+			s.offline = true
+			entry.Attempts++
+			entry.NextAttempt = s.now().Add(spoolDelay(entry.Attempts))
+			if spoolErr := s.spool(entry); spoolErr != nil {
+				return spoolErr
+			}
+			log.Printf("Повторная отправка данных ККТ %s не удалась (попытка %d, следующая после %s): %v",
+				entry.SerialNumber, entry.Attempts, entry.NextAttempt.Format("2006-01-02 15:04:05"), err)
+			break
+		}
+		os.Remove(name)
+		sent++
+	}
+	if sent > 0 {
+		log.Printf("Из очереди '%s' отправлено записей: %d.", s.spoolDir, sent)
+	}
+	return nil
+}
+
+// send отправляет тело запроса с подписью.
+func (s *httpSink) send(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("некорректный адрес приемника '%s': %w", s.url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch s.auth {
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+string(s.secret))
+	case AuthHMAC:
+		timestamp := strconv.FormatInt(s.now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, "sha256="+Sign(s.secret, timestamp, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if isRejected(resp.StatusCode) {
+		return &rejectedError{url: s.url, status: resp.Status}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("сервер '%s' не принял данные: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// Sign возвращает подпись HMAC-SHA256 в hex для заголовка HeaderSignature.
+// Используется и сервером для проверки запроса.
+func Sign(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// spoolPath возвращает путь к записи очереди для ККТ serial.
+func (s *httpSink) spoolPath(serial string) string {
+	return filepath.Join(s.spoolDir, safeName(serial)+".json")
+}
+
+// readSpool читает запись очереди. Поврежденная запись удаляется.
+func (s *httpSink) readSpool(name string) (spoolEntry, bool) {
+	var entry spoolEntry
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Printf("Запись очереди '%s' повреждена (%v) и удалена.", name, err)
+		os.Remove(name)
+		return entry, false
+	}
+	return entry, true
+}
+
+// spool записывает entry в очередь, заменяя предыдущую запись той же ККТ.
+// Файл сначала пишется во временный и затем переименовывается, чтобы
+// прерванный запуск не оставил в очереди неполную запись.
+func (s *httpSink) spool(entry spoolEntry) error {
+	if err := os.MkdirAll(s.spoolDir, 0755); err != nil {
+		return fmt.Errorf("не удалось создать каталог очереди '%s': %w", s.spoolDir, err)
+	}
+	name := s.spoolPath(entry.SerialNumber)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("не удалось сохранить запись в очередь: %w", err)
+	}
+	return os.Rename(tmp, name)
+}
+
+// reject переносит запись очереди name в подкаталог rejectedDir, откуда она
+// больше не отправляется, но остается для разбора.
+func (s *httpSink) reject(name string, entry spoolEntry, err error) {
+	dir := filepath.Join(s.spoolDir, rejectedDir)
+	if mkErr := os.MkdirAll(dir, 0755); mkErr != nil {
+		log.Printf("Не удалось создать каталог '%s': %v", dir, mkErr)
+		return
+	}
+	if mvErr := os.Rename(name, filepath.Join(dir, filepath.Base(name))); mvErr != nil {
+		log.Printf("Не удалось перенести запись очереди '%s': %v", name, mvErr)
+		return
+	}
+	log.Printf("Данные ККТ %s из очереди не приняты (%v) и перенесены в '%s'.", entry.SerialNumber, err, dir)
+}
+
+// spoolDelay возвращает паузу перед следующей попыткой после attempts неудач.
+func spoolDelay(attempts int) time.Duration {
+	delay := spoolBaseDelay
+	for i := 1; i < attempts && delay < spoolMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > spoolMaxDelay {
+		delay = spoolMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,210 @@
+// Тесты HTTP-приемника
+package sink
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// inventoryServer - заглушка сервера инвентаризации, которая проверяет подпись
+// и может отвечать ошибкой.
+type inventoryServer struct {
+	mu       sync.Mutex
+	failing  bool
+	reject   int // Код ответа 4xx вместо приема данных, если задан
+	check    func(r *http.Request, body []byte) bool
+	received [][]byte
+}
+
+func (s *inventoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		http.Error(w, "недоступно", http.StatusServiceUnavailable)
+		return
+	}
+	if s.reject != 0 {
+		http.Error(w, "данные не приняты", s.reject)
+		return
+	}
+	if s.check != nil && !s.check(r, body) {
+		http.Error(w, "неверная подпись", http.StatusUnauthorized)
+		return
+	}
+	s.received = append(s.received, body)
+}
+
+// newTestHTTP создает HTTP-приемник с подставленными часами.
+func newTestHTTP(t *testing.T, cfg Config, now time.Time) *httpSink {
+	t.Helper()
+	s, err := NewHTTP(cfg)
+	if err != nil {
+		t.Fatalf("NewHTTP() вернул неожиданную ошибку: %v", err)
+	}
+	hs := s.(*httpSink)
+	hs.now = func() time.Time { return now }
+	return hs
+}
+
+// TestHTTPSink_Auth проверяет подпись запросов токеном и HMAC.
+func TestHTTPSink_Auth(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		cfg   Config
+		check func(r *http.Request, body []byte) bool
+	}{
+		{"bearer", Config{Auth: AuthBearer, Secret: "token-1"}, func(r *http.Request, body []byte) bool {
+			return r.Header.Get("Authorization") == "Bearer token-1"
+		}},
+		{"hmac", Config{Auth: AuthHMAC, Secret: "key-1"}, func(r *http.Request, body []byte) bool {
+			ts := r.Header.Get(HeaderTimestamp)
+			return ts == "1773144000" && r.Header.Get(HeaderSignature) == "sha256="+Sign([]byte("key-1"), ts, body)
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			inventory := &inventoryServer{check: tc.check}
+			server := httptest.NewServer(inventory)
+			defer server.Close()
+			tc.cfg.URL = server.URL
+
+			if err := newTestHTTP(t, tc.cfg, now).Write(sampleRecord()); err != nil {
+				t.Fatalf("Write() вернул неожиданную ошибку: %v", err)
+			}
+			if len(inventory.received) != 1 {
+				t.Errorf("Сервер получил %d запросов, ожидался 1", len(inventory.received))
+			}
+		})
+	}
+
+	if _, err := NewHTTP(Config{URL: "http://localhost", Auth: AuthHMAC}); err == nil {
+		t.Error("Ожидалась ошибка для подписи HMAC без ключа.")
+	}
+}
+
+// TestHTTPSink_Spool проверяет сохранение неотправленной записи в очередь
+// и ее повторную отправку с паузой в следующих запусках.
+func TestHTTPSink_Spool(t *testing.T) {
+	// Arrange
+	inventory := &inventoryServer{failing: true}
+	server := httptest.NewServer(inventory)
+	defer server.Close()
+	cfg := Config{URL: server.URL, SpoolDir: t.TempDir()}
+	spooled := filepath.Join(cfg.SpoolDir, "0012345678901234.json")
+	t0 := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	// Act: сервер недоступен, запись сохраняется в очередь.
+	first := newTestHTTP(t, cfg, t0)
+	if err := first.Write(sampleRecord()); err != nil {
+		t.Fatalf("Write() должен сохранить запись в очередь, получено: %v", err)
+	}
+	first.Close()
+
+	// Assert
+	if _, err := os.Stat(spooled); err != nil {
+		t.Fatalf("Запись не сохранена в очередь: %v", err)
+	}
+
+	// Act: следующий запуск до истечения паузы - запись остается в очереди.
+	inventory.failing = false
+	newTestHTTP(t, cfg, t0.Add(30*time.Second)).Close()
+	if _, err := os.Stat(spooled); err != nil || len(inventory.received) != 0 {
+		t.Fatalf("Запись не должна отправляться до истечения паузы: %v, получено %d", err, len(inventory.received))
+	}
+
+	// Act: после паузы запись отправляется и удаляется из очереди.
+	newTestHTTP(t, cfg, t0.Add(2*time.Minute)).Close()
+	if _, err := os.Stat(spooled); !os.IsNotExist(err) {
+		t.Errorf("Отправленная запись должна быть удалена из очереди: %v", err)
+	}
+	if len(inventory.received) != 1 {
+		t.Errorf("Сервер получил %d запросов, ожидался 1", len(inventory.received))
+	}
+}
+
+// TestHTTPSink_ReplayBeforeWrite проверяет, что очередь отправляется перед
+// первой записью запуска, а не только при закрытии приемника.
+func TestHTTPSink_ReplayBeforeWrite(t *testing.T) {
+	// Arrange
+	inventory := &inventoryServer{failing: true}
+	server := httptest.NewServer(inventory)
+	defer server.Close()
+	cfg := Config{URL: server.URL, SpoolDir: t.TempDir()}
+	t0 := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	queued := Record{SerialNumber: "0000000000000001", Data: map[string]interface{}{"serialNumber": "0000000000000001"}}
+	if err := newTestHTTP(t, cfg, t0).Write(queued); err != nil {
+		t.Fatalf("Write() должен сохранить запись в очередь, получено: %v", err)
+	}
+	inventory.failing = false
+
+	// Act
+	err := newTestHTTP(t, cfg, t0.Add(time.Hour)).Write(sampleRecord())
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Write() вернул неожиданную ошибку: %v", err)
+	}
+	if len(inventory.received) != 2 || !strings.Contains(string(inventory.received[0]), "0000000000000001") {
+		t.Errorf("Ожидалась отправка записи из очереди перед новой, получено: %q", inventory.received)
+	}
+	if names, _ := filepath.Glob(filepath.Join(cfg.SpoolDir, "*.json")); len(names) != 0 {
+		t.Errorf("Очередь должна быть пуста, осталось: %v", names)
+	}
+}
+
+// TestHTTPSink_Rejected проверяет, что ответы 4xx (кроме 408 и 429) не
+// повторяются: новая запись не попадает в очередь, а запись из очереди
+// переносится в rejectedDir.
+func TestHTTPSink_Rejected(t *testing.T) {
+	// Arrange
+	inventory := &inventoryServer{failing: true}
+	server := httptest.NewServer(inventory)
+	defer server.Close()
+	cfg := Config{URL: server.URL, SpoolDir: t.TempDir()}
+	spooled := filepath.Join(cfg.SpoolDir, "0012345678901234.json")
+	t0 := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	newTestHTTP(t, cfg, t0).Write(sampleRecord())
+	inventory.failing, inventory.reject = false, http.StatusUnprocessableEntity
+
+	// Act
+	replayErr := newTestHTTP(t, cfg, t0.Add(time.Hour)).Close()
+	writeErr := newTestHTTP(t, cfg, t0.Add(time.Hour)).Write(sampleRecord())
+
+	// Assert
+	if replayErr != nil {
+		t.Errorf("Close() вернул неожиданную ошибку: %v", replayErr)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.SpoolDir, rejectedDir, "0012345678901234.json")); err != nil {
+		t.Errorf("Отклоненная запись должна быть перенесена в '%s': %v", rejectedDir, err)
+	}
+	if writeErr == nil {
+		t.Error("Write() должен вернуть ошибку для отклоненных данных.")
+	}
+	if _, err := os.Stat(spooled); !os.IsNotExist(err) {
+		t.Errorf("Отклоненная запись не должна попадать в очередь: %v", err)
+	}
+	for code, want := range map[int]bool{400: true, 401: true, 404: true, 408: false, 429: false, 500: false} {
+		if got := isRejected(code); got != want {
+			t.Errorf("isRejected(%d) = %v, ожидалось %v", code, got, want)
+		}
+	}
+}
+
+// TestSpoolDelay проверяет удвоение паузы между попытками и ее предел.
+func TestSpoolDelay(t *testing.T) {
+	cases := map[int]time.Duration{1: time.Minute, 3: 4 * time.Minute, 20: spoolMaxDelay}
+	for attempts, want := range cases {
+		if got := spoolDelay(attempts); got != want {
+			t.Errorf("spoolDelay(%d) = %v, ожидалось %v", attempts, got, want)
+		}
+	}
+}
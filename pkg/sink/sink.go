@@ -0,0 +1,222 @@
+// Файл: pkg/sink/sink.go
+// Package sink содержит приемники данных опрошенных ККТ: файлы, stdout и HTTP.
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Record - объединенные данные одного устройства: данные ККТ поверх данных
+// рабочей станции в том виде, в каком они сохраняются в JSON.
+type Record struct {
+	SerialNumber string                 // Заводской номер ККТ
+	Hostname     string                 // Имя рабочей станции
+	Data         map[string]interface{} // Объединенные данные
+}
+
+// Sink принимает записи об опрошенных устройствах.
+type Sink interface {
+	// Write передает одну запись.
+	Write(rec Record) error
+	// Close завершает передачу и освобождает ресурсы приемника.
+	Close() error
+}
+
+// Типы приемников в Config.Type.
+const (
+	TypeFile    = "file"
+	TypeStdout  = "stdout"
+	TypeHTTP    = "http"
+	TypeHostDir = "host_dir"
+)
+
+// Config описывает приемник в service.json.
+type Config struct {
+	Type       string `json:"type"`                  // Тип приемника: file, stdout, http или host_dir
+	Dir        string `json:"dir,omitempty"`         // Каталог для file и host_dir
+	URL        string `json:"url,omitempty"`         // Адрес для http
+	TimeoutSec int    `json:"timeout_sec,omitempty"` // Таймаут запроса для http, секунды
+	Auth       string `json:"auth,omitempty"`        // Подпись запросов http: bearer или hmac
+	Secret     string `json:"secret,omitempty"`      // Токен bearer или ключ hmac
+	SecretRef  string `json:"secret_ref,omitempty"`  // Ссылка на секрет (env:ИМЯ или file:путь), разрешается приложением
+	SpoolDir   string `json:"spool_dir,omitempty"`   // Каталог очереди неотправленных записей для http
+}
+
+// New создает приемник по описанию cfg.
+func New(cfg Config) (Sink, error) {
+	switch cfg.Type {
+	case TypeFile:
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("для приемника '%s' не указан каталог 'dir'", cfg.Type)
+		}
+		return NewFile(cfg.Dir), nil
+	case TypeHostDir:
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("для приемника '%s' не указан каталог 'dir'", cfg.Type)
+		}
+		return NewHostDir(cfg.Dir), nil
+	case TypeStdout:
+		return NewJSONLines(os.Stdout), nil
+	case TypeHTTP:
+		return NewHTTP(cfg)
+	default:
+		return nil, fmt.Errorf("неизвестный тип приемника '%s'", cfg.Type)
+	}
+}
+
+// NewFromConfigs создает приемник, передающий записи во все приемники cfgs.
+// Некорректные описания пропускаются, их ошибки возвращаются вместе с приемником.
+// Если не удалось создать ни одного приемника, возвращается nil.
+func NewFromConfigs(cfgs []Config) (Sink, error) {
+	var sinks []Sink
+	var errs errorList
+	for _, cfg := range cfgs {
+		s, err := New(cfg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sinks = append(sinks, s)
+	}
+	if len(sinks) == 0 {
+		return nil, errs.err()
+	}
+	return Multi(sinks...), errs.err()
+}
+
+// fileSink записывает каждую запись в файл {SerialNumber}.json.
+type fileSink struct {
+	dir     string
+	perHost bool
+}
+
+// NewFile создает приемник, который записывает каждую ККТ в файл dir/{ЗН}.json.
+func NewFile(dir string) Sink {
+	return &fileSink{dir: dir}
+}
+
+// NewHostDir создает приемник, который записывает ККТ в подкаталог рабочей
+// станции: dir/{hostname}/{ЗН}.json. Подходит для общего сетевого каталога.
+func NewHostDir(dir string) Sink {
+	return &fileSink{dir: dir, perHost: true}
+}
+
+func (s *fileSink) Write(rec Record) error {
+	dir := s.dir
+	if s.perHost {
+		dir = filepath.Join(dir, safeName(rec.Hostname))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("не удалось создать директорию '%s': %w", dir, err)
+	}
+	data, err := json.MarshalIndent(rec.Data, "", "    ")
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга итогового JSON: %w", err)
+	}
+	path := filepath.Join(dir, safeName(rec.SerialNumber)+".json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("ошибка записи в файл '%s': %w", path, err)
+	}
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	return nil
+}
+
+// safeName заменяет в имени символы, недопустимые в имени файла.
+func safeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "unknown"
+	}
+	return name
+}
+
+// jsonLinesSink пишет каждую запись одной строкой JSON.
+type jsonLinesSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLines создает приемник, который пишет записи в w по одной строке JSON.
+func NewJSONLines(w io.Writer) Sink {
+	return &jsonLinesSink{w: w}
+}
+
+func (s *jsonLinesSink) Write(rec Record) error {
+	data, err := json.Marshal(rec.Data)
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга итогового JSON: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *jsonLinesSink) Close() error {
+	return nil
+}
+
+// multiSink передает записи в несколько приемников.
+type multiSink []Sink
+
+// Multi объединяет приемники: запись передается во все, ошибка одного
+// приемника не мешает передаче в остальные.
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+func (m multiSink) Write(rec Record) error {
+	var errs errorList
+	for _, s := range m {
+		if err := s.Write(rec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
+}
+
+func (m multiSink) Close() error {
+	var errs errorList
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
+}
+
+// errorList объединяет ошибки нескольких приемников.
+type errorList []error
+
+func (e errorList) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// err возвращает nil для пустого списка.
+func (e errorList) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	if len(e) == 1 {
+		return e[0]
+	}
+	return e
+}
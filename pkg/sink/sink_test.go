@@ -0,0 +1,78 @@
+// Тесты приемников данных
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sampleRecord() Record {
+	return Record{
+		SerialNumber: "0012345678901234",
+		Hostname:     "POS-01",
+		Data:         map[string]interface{}{"serialNumber": "0012345678901234", "hostname": "POS-01"},
+	}
+}
+
+// TestFileSinks проверяет размещение файлов приемниками file и host_dir.
+func TestFileSinks(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name string
+		sink Sink
+		path string
+	}{
+		{"file", NewFile(filepath.Join(dir, "date")), filepath.Join(dir, "date", "0012345678901234.json")},
+		{"host_dir", NewHostDir(filepath.Join(dir, "share")), filepath.Join(dir, "share", "POS-01", "0012345678901234.json")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.sink.Write(sampleRecord()); err != nil {
+				t.Fatalf("Write() вернул неожиданную ошибку: %v", err)
+			}
+			data, err := os.ReadFile(tc.path)
+			if err != nil {
+				t.Fatalf("Файл не создан: %v", err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(data, &got); err != nil || got["hostname"] != "POS-01" {
+				t.Errorf("Содержимое файла некорректно: %s", data)
+			}
+		})
+	}
+}
+
+// TestMulti проверяет, что ошибка одного приемника не мешает записи в остальные.
+func TestMulti(t *testing.T) {
+	// Arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "недоступно", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	var buf bytes.Buffer
+	s, err := NewFromConfigs([]Config{{Type: TypeHTTP, URL: server.URL}, {Type: "ftp"}})
+	if err == nil || !strings.Contains(err.Error(), "ftp") {
+		t.Errorf("Ожидалась ошибка неизвестного типа приемника, получено: %v", err)
+	}
+	s = Multi(s, NewJSONLines(&buf))
+
+	// Act
+	err = s.Write(sampleRecord())
+
+	// Assert
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Ожидалась ошибка HTTP 503, получено: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], "{") {
+		t.Errorf("Ожидалась одна строка JSON, получено: %q", buf.String())
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() вернул неожиданную ошибку: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+// Файл: poll.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"shtrih-kkt/pkg/shtrih"
+)
+
+// pollDevices опрашивает устройства пулом из pollConcurrency воркеров. Устройства
+// на одном последовательном порту объединяются в группу и опрашиваются одним
+// воркером по очереди. Результаты возвращаются в порядке configs независимо
+// от порядка завершения опроса. После отмены ctx оставшиеся устройства не опрашиваются.
+func pollDevices(ctx context.Context, configs []shtrih.Config, newDriverFunc func(shtrih.Config) shtrih.Driver) []PolledDevice {
+	groups := groupByPort(configs)
+	workers := pollConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+
+	results := make([]*shtrih.FiscalInfo, len(configs))
+	jobs := make(chan []int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range jobs {
+				for _, idx := range group {
+					if ctx.Err() == nil {
+						results[idx] = pollOne(ctx, configs[idx], newDriverFunc)
+					}
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		jobs <- group
+	}
+	close(jobs)
+	wg.Wait()
+
+	var polledDevices []PolledDevice
+	for i, info := range results {
+		if info != nil {
+			polledDevices = append(polledDevices, PolledDevice{Config: configs[i], Info: info})
+		}
+	}
+	return polledDevices
+}
+
+// pollOne опрашивает одно устройство в пределах бюджета deviceTimeout и
+// дополняет данные прогнозом по ФН. Возвращает nil, если данные не получены.
+func pollOne(ctx context.Context, config shtrih.Config, newDriverFunc func(shtrih.Config) shtrih.Driver) *shtrih.FiscalInfo {
+	log.Printf("--- Опрашиваю устройство: %s ---", deviceAddress(config))
+	ctx, cancel := context.WithTimeout(ctx, deviceTimeout)
+	defer cancel()
+
+	started := time.Now()
+	info, err := pollDevice(ctx, config, newDriverFunc)
+	if errors.Is(err, shtrih.CategoryPassword) {
+		info, err = pollWithSearchPasswords(ctx, config, newDriverFunc, err)
+	}
+	if err == nil && (info == nil || info.SerialNumber == "") {
+		log.Printf("%s: получена пустая информация или отсутствует серийный номер, данные проигнорированы.", deviceAddress(config))
+		notifyPoll(config, nil, errEmptyInfo, started)
+		return nil
+	}
+	if err != nil {
+		logPollError(fmt.Errorf("%s: %w", deviceAddress(config), err))
+		notifyPoll(config, nil, err, started)
+		return nil
+	}
+	logOfdBacklog(info)
+	info.FnForecast = shtrih.ForecastFn(info, time.Now(), fnThresholds)
+	logFnForecast(info)
+	notifyPoll(config, info, nil, started)
+	return info
+}
+
+// errEmptyInfo - результат опроса устройства, не вернувшего заводской номер.
+var errEmptyInfo = errors.New("получена пустая информация или отсутствует серийный номер")
+
+// notifyPoll передает результат опроса в pollObserver, если он задан.
+func notifyPoll(config shtrih.Config, info *shtrih.FiscalInfo, err error, started time.Time) {
+	if pollObserver != nil {
+		pollObserver(config, info, err, time.Since(started))
+	}
+}
+
+// groupByPort разбивает индексы configs на группы по физическому порту:
+// устройства на одном COM-порту попадают в одну группу, каждое TCP-устройство -
+// в отдельную. Порядок групп и индексов внутри групп сохраняет порядок configs.
+func groupByPort(configs []shtrih.Config) [][]int {
+	var groups [][]int
+	byPort := make(map[string]int)
+	for i, config := range configs {
+		if config.ConnectionType != 0 {
+			groups = append(groups, []int{i})
+			continue
+		}
+		key := serialPortKey(config)
+		if g, ok := byPort[key]; ok {
+			groups[g] = append(groups[g], i)
+			continue
+		}
+		byPort[key] = len(groups)
+		groups = append(groups, []int{i})
+	}
+	return groups
+}
+
+// serialPortKey возвращает имя последовательного порта устройства без учета регистра.
+func serialPortKey(config shtrih.Config) string {
+	if config.ComName != "" {
+		return strings.ToUpper(config.ComName)
+	}
+	return fmt.Sprintf("COM%d", config.ComNumber)
+}
+
+// deviceAddress возвращает адрес устройства для сообщений в логе.
+func deviceAddress(config shtrih.Config) string {
+	if config.ConnectionType == 0 {
+		return serialPortKey(config)
+	}
+	return fmt.Sprintf("%s:%d", config.IPAddress, config.TCPPort)
+}
@@ -0,0 +1,155 @@
+// Файл: recovery.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"shtrih-kkt/pkg/shtrih"
+)
+
+// runState - состояние стационарного режима между запусками.
+type runState struct {
+	// FailedRuns - сколько запусков подряд не ответило ни одно устройство.
+	FailedRuns int `json:"failed_runs"`
+	// LastSeen - время последнего ответа устройства по ключу settingsKey.
+	LastSeen map[string]time.Time `json:"last_seen"`
+	// LastRelocation - время последнего поиска пропавших ККТ (см. relocateDevices).
+	LastRelocation time.Time `json:"last_relocation"`
+}
+
+// loadRunState читает файл состояния. Если файла нет или он поврежден,
+// возвращается пустое состояние.
+func loadRunState() *runState {
+	state := &runState{}
+	data, err := os.ReadFile(stateFileName)
+	if err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			log.Printf("Предупреждение: файл состояния '%s' поврежден (%v). Состояние сброшено.", stateFileName, err)
+			state = &runState{}
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Предупреждение: не удалось прочитать файл состояния '%s': %v", stateFileName, err)
+	}
+	if state.LastSeen == nil {
+		state.LastSeen = make(map[string]time.Time)
+	}
+	return state
+}
+
+// save записывает состояние в файл.
+func (s *runState) save() {
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		log.Printf("Ошибка: не удалось преобразовать состояние в JSON: %v", err)
+		return
+	}
+	if err := os.WriteFile(stateFileName, data, 0644); err != nil {
+		log.Printf("Ошибка: не удалось записать файл состояния '%s': %v", stateFileName, err)
+	}
+}
+
+// settingsKey возвращает ключ устройства в файле состояния: заводской номер,
+// а для записей без него - адрес.
+func settingsKey(s ConnectionSettings) string {
+	if s.SerialNumber != "" {
+		return s.SerialNumber
+	}
+	return settingsAddress(s)
+}
+
+// recoverDevices применяет политику самовосстановления к результатам опроса
+// устройств из settings. Если ни одно устройство не отвечает rediscoverAfterRuns
+// запусков подряд, выполняется полный поиск, иначе пропавшие ККТ ищутся по
+// заводским номерам (см. relocateDevices). Затем из settings удаляются
+// устройства, которые не отвечают дольше retireAfter. Возвращает обновленные
+// settings, опрошенные устройства и признак изменения settings.
+func recoverDevices(ctx context.Context, settings []ConnectionSettings, polled []PolledDevice, newDriverFunc func(shtrih.Config) shtrih.Driver, state *runState, now time.Time) ([]ConnectionSettings, []PolledDevice, bool) {
+	if len(polled) == 0 {
+		state.FailedRuns++
+		log.Printf("Ни одно устройство из '%s' не ответило. Запусков подряд без ответа: %d.", configFileName, state.FailedRuns)
+	} else {
+		state.FailedRuns = 0
+	}
+
+	var changed bool
+	if rediscoverAfterRuns > 0 && state.FailedRuns >= rediscoverAfterRuns {
+		log.Printf("--- Устройства не отвечают %d запусков подряд. Запускаю повторный автопоиск ---", state.FailedRuns)
+		settings, polled, changed = rediscoverDevices(ctx, settings, polled, newDriverFunc)
+		state.FailedRuns = 0
+	} else {
+		polled, changed = relocateDevices(ctx, settings, polled, newDriverFunc, state, now)
+	}
+
+	settings, retired := retireDevices(settings, polled, state, now)
+	return settings, polled, changed || retired
+}
+
+// rediscoverDevices выполняет полный поиск и объединяет найденные ККТ с settings:
+// записи известных ККТ обновляются по заводскому номеру, новые ККТ добавляются.
+func rediscoverDevices(ctx context.Context, settings []ConnectionSettings, polled []PolledDevice, newDriverFunc func(shtrih.Config) shtrih.Driver) ([]ConnectionSettings, []PolledDevice, bool) {
+	known := make(map[string]PolledDevice)
+	for _, pd := range polled {
+		known[deviceAddress(pd.Config)] = pd
+	}
+	bySerial := make(map[string]int)
+	for i, s := range settings {
+		if s.SerialNumber != "" {
+			bySerial[s.SerialNumber] = i
+		}
+	}
+
+	changed := false
+	for _, pd := range searchAndPoll(ctx, searchOptionsFor(settings), known, newDriverFunc) {
+		serial := pd.Info.SerialNumber
+		if i, ok := bySerial[serial]; ok {
+			if settingsAddress(settings[i]) != deviceAddress(pd.Config) {
+				log.Printf("ККТ %s найдена по адресу %s, обновляю '%s'.", serial, deviceAddress(pd.Config), configFileName)
+				settings[i] = relocatedSettings(settings[i], pd.Config)
+				changed = true
+			}
+		} else {
+			log.Printf("Найдена новая ККТ %s по адресу %s, добавляю в '%s'.", serial, deviceAddress(pd.Config), configFileName)
+			s := convertConfigToSettings(pd.Config)
+			s.SerialNumber = serial
+			bySerial[serial] = len(settings)
+			settings = append(settings, s)
+			changed = true
+		}
+		polled = append(polled, pd)
+	}
+	return settings, polled, changed
+}
+
+// retireDevices отмечает в state время ответа устройств из settings и удаляет
+// записи устройств, которые не отвечают дольше retireAfter. Если в этом запуске
+// не ответило ни одно устройство, записи не удаляются: вероятнее, что пропала
+// связь у самого компьютера.
+func retireDevices(settings []ConnectionSettings, polled []PolledDevice, state *runState, now time.Time) ([]ConnectionSettings, bool) {
+	answered := make(map[string]bool)
+	for _, pd := range polled {
+		answered[pd.Info.SerialNumber] = true
+		answered[deviceAddress(pd.Config)] = true
+	}
+
+	lastSeen := make(map[string]time.Time)
+	var kept []ConnectionSettings
+	for _, s := range settings {
+		key := settingsKey(s)
+		last, tracked := state.LastSeen[key]
+		if answered[key] || !tracked {
+			last = now
+		}
+		if retireAfter > 0 && len(polled) > 0 && now.Sub(last) > retireAfter {
+			log.Printf("ККТ %s (%s) не отвечает с %s, запись удалена из '%s'.", key, settingsAddress(s), last.Format("2006-01-02 15:04"), configFileName)
+			continue
+		}
+		lastSeen[key] = last
+		kept = append(kept, s)
+	}
+	state.LastSeen = lastSeen
+	return kept, len(kept) != len(settings)
+}
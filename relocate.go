@@ -0,0 +1,172 @@
+// Файл: relocate.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"shtrih-kkt/pkg/shtrih"
+)
+
+// relocateDevices сверяет заводские номера ККТ, ответивших по адресам из settings,
+// с ожидаемыми (ConnectionSettings.SerialNumber). ККТ, которая пропала или вместо
+// которой ответила другая, ищется сначала среди уже опрошенных устройств, затем
+// автопоиском. Поиск не проверяет адреса ответивших ККТ, завершается, когда
+// найдено столько устройств, сколько пропало, и выполняется не чаще
+// relocateInterval (время последнего поиска хранится в state). Записи найденных
+// ККТ обновляются в settings на месте, записям без заводского номера
+// присваивается номер ответившей ККТ. Возвращает опрошенные устройства,
+// дополненные найденными, и признак изменения settings.
+func relocateDevices(ctx context.Context, settings []ConnectionSettings, polled []PolledDevice, newDriverFunc func(shtrih.Config) shtrih.Driver, state *runState, now time.Time) ([]PolledDevice, bool) {
+	byAddress := make(map[string]PolledDevice)
+	bySerial := make(map[string]PolledDevice)
+	for _, pd := range polled {
+		byAddress[deviceAddress(pd.Config)] = pd
+		bySerial[pd.Info.SerialNumber] = pd
+	}
+
+	changed := false
+	missing := make(map[string]int)
+	for i, s := range settings {
+		address := settingsAddress(s)
+		pd, answered := byAddress[address]
+		if s.SerialNumber == "" {
+			if answered {
+				log.Printf("Запись %s привязана к ККТ %s.", address, pd.Info.SerialNumber)
+				settings[i].SerialNumber = pd.Info.SerialNumber
+				changed = true
+			}
+			continue
+		}
+		if answered && pd.Info.SerialNumber == s.SerialNumber {
+			continue
+		}
+		if answered {
+			log.Printf("ВНИМАНИЕ: по адресу %s ответила ККТ %s вместо %s.", address, pd.Info.SerialNumber, s.SerialNumber)
+		}
+		if moved, ok := bySerial[s.SerialNumber]; ok {
+			log.Printf("ККТ %s отвечает по адресу %s, обновляю '%s'.", s.SerialNumber, deviceAddress(moved.Config), configFileName)
+			settings[i] = relocatedSettings(s, moved.Config)
+			changed = true
+			continue
+		}
+		log.Printf("ККТ %s не найдена по адресу %s.", s.SerialNumber, address)
+		missing[s.SerialNumber] = i
+	}
+	if len(missing) == 0 {
+		return polled, changed
+	}
+	if relocateInterval > 0 && !state.LastRelocation.IsZero() && now.Sub(state.LastRelocation) < relocateInterval {
+		log.Printf("Поиск пропавших ККТ (%d) отложен до %s.", len(missing), state.LastRelocation.Add(relocateInterval).Format("2006-01-02 15:04"))
+		return polled, changed
+	}
+	state.LastRelocation = now
+
+	var wanted []ConnectionSettings
+	for i, s := range settings {
+		if j, ok := missing[s.SerialNumber]; ok && i == j {
+			wanted = append(wanted, s)
+		}
+	}
+	opts := searchOptionsFor(wanted)
+	opts.ExpectedDevices = len(missing)
+	for address := range byAddress {
+		opts.SkipAddresses = append(opts.SkipAddresses, address)
+	}
+	log.Printf("--- Начинаю поиск пропавших ККТ (%d) ---", len(missing))
+	for _, pd := range searchAndPoll(ctx, opts, byAddress, newDriverFunc) {
+		i, ok := missing[pd.Info.SerialNumber]
+		if !ok {
+			log.Printf("Найдена ККТ %s по адресу %s, которой нет в '%s'.", pd.Info.SerialNumber, deviceAddress(pd.Config), configFileName)
+			continue
+		}
+		log.Printf("ККТ %s найдена по адресу %s, обновляю '%s'.", pd.Info.SerialNumber, deviceAddress(pd.Config), configFileName)
+		settings[i] = relocatedSettings(settings[i], pd.Config)
+		delete(missing, pd.Info.SerialNumber)
+		polled = append(polled, pd)
+		changed = true
+	}
+	for serial := range missing {
+		log.Printf("ККТ %s не найдена при поиске, запись в '%s' оставлена без изменений.", serial, configFileName)
+	}
+	return polled, changed
+}
+
+// searchOptionsFor возвращает параметры поиска устройств settings: дополнительно
+// пробуются их пароли, а порты с их USB-идентификацией (с учетом серийного
+// номера адаптера) проверяются первыми.
+func searchOptionsFor(settings []ConnectionSettings) shtrih.SearchOptions {
+	opts := searchOptions
+	opts.Passwords = append([]int32(nil), opts.Passwords...)
+	opts.KnownUSB = append([]*shtrih.USBIdentity(nil), opts.KnownUSB...)
+	opts.SkipAddresses = append([]string(nil), opts.SkipAddresses...)
+	for _, s := range settings {
+		if password, err := settingsPassword(s); err == nil {
+			opts.Passwords = append(opts.Passwords, password)
+		}
+		if s.USB != nil {
+			opts.KnownUSB = append(opts.KnownUSB, s.USB)
+		}
+	}
+	return opts
+}
+
+// findDevices выполняет автопоиск через searchDevices и передает его итог
+// в discoveryObserver, если он задан. Прерванный поиск не учитывается.
+func findDevices(ctx context.Context, opts shtrih.SearchOptions) ([]shtrih.Config, error) {
+	started := time.Now()
+	configs, err := searchDevices(ctx, opts)
+	if discoveryObserver != nil && ctx.Err() == nil {
+		denied := 0
+		var authErr *shtrih.SearchAuthError
+		if errors.As(err, &authErr) {
+			denied = len(authErr.Devices)
+		}
+		discoveryObserver(len(configs), denied, time.Since(started))
+	}
+	return configs, err
+}
+
+// searchAndPoll выполняет поиск и опрашивает найденные устройства, кроме уже
+// опрошенных по адресам из known.
+func searchAndPoll(ctx context.Context, opts shtrih.SearchOptions, known map[string]PolledDevice, newDriverFunc func(shtrih.Config) shtrih.Driver) []PolledDevice {
+	configs, err := findDevices(ctx, opts)
+	var authErr *shtrih.SearchAuthError
+	if errors.As(err, &authErr) {
+		logAuthFailures(authErr)
+	} else if err != nil {
+		log.Printf("Во время поиска устройств произошла ошибка: %v", err)
+	}
+
+	var candidates []shtrih.Config
+	for _, config := range configs {
+		if _, ok := known[deviceAddress(config)]; !ok {
+			candidates = append(candidates, config)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return pollDevices(ctx, candidates, newDriverFunc)
+}
+
+// relocatedSettings возвращает запись s с транспортными параметрами config.
+// Заводской номер, пароль и ссылка на пароль сохраняются.
+func relocatedSettings(s ConnectionSettings, config shtrih.Config) ConnectionSettings {
+	updated := convertConfigToSettings(config)
+	updated.SerialNumber = s.SerialNumber
+	updated.Password, updated.PasswordRef = s.Password, s.PasswordRef
+	return updated
+}
+
+// settingsAddress возвращает адрес устройства из connect.json в том же виде, что deviceAddress.
+func settingsAddress(s ConnectionSettings) string {
+	if s.TypeConnect == 0 {
+		return strings.ToUpper(s.ComPort)
+	}
+	return fmt.Sprintf("%s:%s", s.IP, s.IPPort)
+}
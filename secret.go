@@ -0,0 +1,78 @@
+// Файл: secret.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"shtrih-kkt/pkg/shtrih"
+)
+
+// settingsPassword возвращает пароль устройства из connect.json: по ссылке
+// PasswordRef, из поля Password или пароль по умолчанию.
+func settingsPassword(s ConnectionSettings) (int32, error) {
+	if s.PasswordRef == "" {
+		if s.Password != 0 {
+			return s.Password, nil
+		}
+		return defaultDevicePassword, nil
+	}
+	value, err := resolveSecret(s.PasswordRef)
+	if err != nil {
+		return 0, err
+	}
+	password, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("пароль по ссылке '%s' не является числом", s.PasswordRef)
+	}
+	return int32(password), nil
+}
+
+// resolveSecret читает значение по ссылке "env:ИМЯ" (переменная окружения)
+// или "file:путь" (первая строка файла), чтобы пароли не хранились в connect.json.
+func resolveSecret(ref string) (string, error) {
+	i := strings.Index(ref, ":")
+	if i < 0 {
+		return "", fmt.Errorf("некорректная ссылка на секрет '%s': ожидается env:ИМЯ или file:путь", ref)
+	}
+	kind, name := ref[:i], ref[i+1:]
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("переменная окружения '%s' не задана", name)
+		}
+		return strings.TrimSpace(value), nil
+	case "file":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("не удалось прочитать секрет из файла: %w", err)
+		}
+		return strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0]), nil
+	default:
+		return "", fmt.Errorf("неизвестный тип ссылки на секрет '%s'", kind)
+	}
+}
+
+// pollWithSearchPasswords повторяет опрос ККТ, отклонившей пароль config,
+// с паролями автопоиска из service.json (см. shtrih.SearchPasswords). Так
+// опрашиваются ККТ, найденные с паролем не по умолчанию: сам пароль в
+// connect.json не записывается. Если ни один пароль не подошел, возвращает denied.
+func pollWithSearchPasswords(ctx context.Context, config shtrih.Config, newDriverFunc func(shtrih.Config) shtrih.Driver, denied error) (*shtrih.FiscalInfo, error) {
+	for _, password := range shtrih.SearchPasswords(searchOptions) {
+		if password == config.Password {
+			continue
+		}
+		candidate := config
+		candidate.Password = password
+		info, err := pollDevice(ctx, candidate, newDriverFunc)
+		if !errors.Is(err, shtrih.CategoryPassword) {
+			return info, err
+		}
+	}
+	return nil, denied
+}
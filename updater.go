@@ -27,10 +27,21 @@ type UpdateInfo struct {
 }
 
 // checkForUpdates проверяет наличие новой версии и запускает процесс обновления.
+// Если новая версия запущена, текущий процесс завершается.
 func checkForUpdates(currentVersion, manifestURL string, wg *sync.WaitGroup) {
 	defer wg.Done() // Ожидающая передаёт в группу завершение горутины
+	if updateApplication(currentVersion, manifestURL) {
+		log.Println("Приложение успешно обновлено и перезапущено. Текущий процесс завершается.")
+		os.Exit(0)
+	}
+}
+
+// updateApplication проверяет манифест и при наличии новой версии обновляет
+// исполняемый файл и запускает его. Возвращает true, если новая версия запущена
+// и текущий процесс должен завершиться.
+func updateApplication(currentVersion, manifestURL string) bool {
 	if manifestURL == "" {
-		return
+		return false
 	}
 	log.Printf("Проверка обновлений по адресу: %s", manifestURL)
 
@@ -38,30 +49,30 @@ func checkForUpdates(currentVersion, manifestURL string, wg *sync.WaitGroup) {
 	resp, err := client.Get(manifestURL)
 	if err != nil {
 		log.Printf("Ошибка при проверке обновлений: не удалось получить данные с сервера: %v", err)
-		return
+		return false
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("Ошибка при проверке обновлений: сервер вернул статус %d", resp.StatusCode)
-		return
+		return false
 	}
 
 	var info UpdateInfo
 	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
 		log.Printf("Ошибка при проверке обновлений: не удалось разобрать JSON-манифест: %v", err)
-		return
+		return false
 	}
 
 	vCurrent, err := gover.NewVersion(currentVersion)
 	if err != nil {
 		log.Printf("Ошибка: некорректный формат текущей версии '%s': %v", currentVersion, err)
-		return
+		return false
 	}
 	vLatest, err := gover.NewVersion(info.Version)
 	if err != nil {
 		log.Printf("Ошибка: некорректный формат версии на сервере '%s': %v", info.Version, err)
-		return
+		return false
 	}
 
 	if vLatest.GreaterThan(vCurrent) {
@@ -70,20 +81,18 @@ func checkForUpdates(currentVersion, manifestURL string, wg *sync.WaitGroup) {
 		downloadURL, err := resolveDownloadURL(manifestURL, info.Url)
 		if err != nil {
 			log.Printf("Некорректный URL для скачивания обновления: %v", err)
-			return
+			return false
 		}
 		log.Printf("URL для скачивания exe-файла: %s", downloadURL)
 
 		restarted, err := doUpdate(info.Url, info.Sha256)
 		if err != nil {
 			log.Printf("Не удалось обновить приложение: %v", err)
-		} else if restarted {
-			log.Println("Приложение успешно обновлено и перезапущено. Текущий процесс завершается.")
-			os.Exit(0)
 		}
-	} else {
-		log.Printf("Установлена актуальная версия приложения (%s).", currentVersion)
+		return restarted
 	}
+	log.Printf("Установлена актуальная версия приложения (%s).", currentVersion)
+	return false
 }
 
 // Она объединяет URL манифеста с путем к файлу из JSON.